@@ -0,0 +1,179 @@
+package imap
+
+import (
+	"mime"
+	"net/mail"
+	"strings"
+
+	"github.com/jhillyerd/enmime/v2"
+)
+
+// Address is one mailbox of an address header such as From or To
+type Address struct {
+	// Name is the decoded display name, or ""
+	Name string
+	// Mailbox and Host are the local part and the domain, in their
+	// original case
+	Mailbox string
+	Host    string
+	// Group is the name of the RFC 5322 group the address is listed in, e.g.
+	// "undisclosed-recipients", or "" if it is not in a group. An empty group
+	// is listed as an Address with only Group set.
+	Group string
+}
+
+// Addr returns the address as given, e.g. "Ann.Lee@Example.com", or "" for an
+// empty group
+func (a Address) Addr() string {
+	if a.Mailbox == "" && a.Host == "" {
+		return ""
+	}
+	return a.Mailbox + "@" + a.Host
+}
+
+// String formats the address as in a header, e.g. `"Lee, Ann" <ann@example.com>`.
+// The display name is not RFC 2047 encoded.
+func (a Address) String() string {
+	if a.Name == "" {
+		return a.Addr()
+	}
+	return quoteName(a.Name) + " <" + a.Addr() + ">"
+}
+
+// quoteName quotes a display name that contains characters other than atext
+// and spaces
+func quoteName(name string) string {
+	if strings.ContainsAny(name, `()<>[]:;@\,."`) {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+	}
+	return name
+}
+
+// AddressList is the addresses of a header in the order they appear,
+// including duplicates and groups
+type AddressList []Address
+
+// String formats the list as in a header, with groups written as
+// "name: a, b;" and empty groups as "name:;"
+func (l AddressList) String() string {
+	var b strings.Builder
+	for i, a := range l {
+		inGroup := a.Group != "" && i > 0 && l[i-1].Group == a.Group
+		switch {
+		case i > 0 && inGroup:
+			b.WriteString(", ")
+		case i > 0:
+			if l[i-1].Group != "" {
+				b.WriteString(";")
+			}
+			b.WriteString(", ")
+		}
+		if a.Group != "" && !inGroup {
+			b.WriteString(quoteName(a.Group) + ":")
+			if a.Addr() != "" {
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString(a.String())
+	}
+	if len(l) > 0 && l[len(l)-1].Group != "" {
+		b.WriteString(";")
+	}
+	return b.String()
+}
+
+// Map returns the addresses as an EmailAddresses map keyed by lower-case
+// address. Empty groups are left out, and of duplicate addresses the last
+// display name wins.
+func (l AddressList) Map() EmailAddresses {
+	m := make(EmailAddresses, len(l))
+	for _, a := range l {
+		if addr := a.Addr(); addr != "" {
+			m[strings.ToLower(addr)] = a.Name
+		}
+	}
+	return m
+}
+
+// ParseAddressList parses the value of an address header such as To, with
+// RFC 2047 encoded display names. Groups are kept, and malformed addresses
+// are tolerated where possible. On error the addresses parsed so far are
+// returned with it.
+func ParseAddressList(s string) (AddressList, error) {
+	var list AddressList
+	dec := mime.WordDecoder{CharsetReader: CharsetReader}
+	for _, run := range splitGroups(s) {
+		if decoded, err := dec.DecodeHeader(run.group); err == nil {
+			run.group = decoded
+		}
+		var addrs []*mail.Address
+		if members := strings.Trim(run.list, " \t\r\n,"); members != "" {
+			var err error
+			if addrs, err = enmime.ParseAddressList(members); err != nil {
+				return list, err
+			}
+		}
+		if len(addrs) == 0 && run.group != "" {
+			list = append(list, Address{Group: run.group})
+		}
+		for _, a := range addrs {
+			mailbox, host := a.Address, ""
+			if i := strings.LastIndexByte(a.Address, '@'); i >= 0 {
+				mailbox, host = a.Address[:i], a.Address[i+1:]
+			}
+			list = append(list, Address{Name: a.Name, Mailbox: mailbox, Host: host, Group: run.group})
+		}
+	}
+	return list, nil
+}
+
+// groupRun is a run of addresses in an address header and the group they
+// are listed in, if any
+type groupRun struct {
+	group, list string
+}
+
+// splitGroups splits an address header into the addresses outside groups and
+// the members of each group. A colon or semicolon inside quotes, comments,
+// angle brackets or domain literals does not count.
+func splitGroups(s string) []groupRun {
+	var runs []groupRun
+	start, item := 0, 0 // the start of the run and of its last address
+	group := ""
+	quoted, escaped := false, false
+	depth := 0 // of comments, angle brackets and domain literals
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case quoted:
+			quoted = c != '"'
+		case c == '"':
+			quoted = true
+		case c == '(' || c == '<' || c == '[':
+			depth++
+		case c == ')' || c == '>' || c == ']':
+			depth = max(depth-1, 0)
+		case depth > 0:
+		case c == ',':
+			item = i + 1
+		case c == ':' && group == "":
+			if item > start {
+				runs = append(runs, groupRun{"", s[start : item-1]})
+			}
+			group = strings.Trim(strings.TrimSpace(s[item:i]), `"`)
+			start, item = i+1, i+1
+		case c == ';' && group != "":
+			runs = append(runs, groupRun{group, s[start:i]})
+			group = ""
+			start, item = i+1, i+1
+		}
+	}
+	if rest := s[start:]; strings.Trim(rest, " \t\r\n,") != "" || group != "" {
+		runs = append(runs, groupRun{group, rest})
+	}
+	return runs
+}
@@ -0,0 +1,85 @@
+package imap
+
+import (
+	"fmt"
+	"mime"
+	"testing"
+)
+
+func TestParseAddressList(t *testing.T) {
+	t.Parallel()
+	l, err := ParseAddressList(`"Lee, Ann" <Ann.Lee@Example.com>, bob@example.com, ` +
+		`Team: =?UTF-8?Q?Ren=C3=A9?= <rene@example.com>, "a:b;c" <x@example.com>;, ` +
+		`undisclosed-recipients:;, Bob <BOB@example.com>`)
+	if err != nil {
+		t.Fatalf("ParseAddressList() error = %v", err)
+	}
+	want := AddressList{
+		{Name: "Lee, Ann", Mailbox: "Ann.Lee", Host: "Example.com"},
+		{Mailbox: "bob", Host: "example.com"},
+		{Name: "René", Mailbox: "rene", Host: "example.com", Group: "Team"},
+		{Name: "a:b;c", Mailbox: "x", Host: "example.com", Group: "Team"},
+		{Group: "undisclosed-recipients"},
+		{Name: "Bob", Mailbox: "BOB", Host: "example.com"},
+	}
+	if fmt.Sprintf("%+v", []Address(l)) != fmt.Sprintf("%+v", []Address(want)) {
+		t.Fatalf("ParseAddressList() = %+v\nwant %+v", []Address(l), []Address(want))
+	}
+
+	wantString := `"Lee, Ann" <Ann.Lee@Example.com>, bob@example.com, ` +
+		`Team: René <rene@example.com>, "a:b;c" <x@example.com>;, undisclosed-recipients:;, Bob <BOB@example.com>`
+	if got := l.String(); got != wantString {
+		t.Errorf("String() = %q\nwant       %q", got, wantString)
+	}
+
+	// The map keeps one entry per lower-case address, without the empty group
+	m := l.Map()
+	if len(m) != 4 || m["ann.lee@example.com"] != "Lee, Ann" || m["bob@example.com"] != "Bob" {
+		t.Errorf("Map() = %v", m)
+	}
+
+	if l, err := ParseAddressList(""); err != nil || l != nil {
+		t.Errorf("ParseAddressList(\"\") = %v, %v", l, err)
+	}
+}
+
+func TestParseEnvelopeAddresses_Groups(t *testing.T) {
+	t.Parallel()
+	d := &Dialer{}
+	tks, err := parseFetchTokens(`TO ((NIL NIL "team" NIL)("Ann" NIL "Ann" "Example.com")(NIL NIL NIL NIL)` +
+		`(NIL NIL "undisclosed-recipients" NIL)(NIL NIL NIL NIL)("Bob" NIL "bob" "example.com"))`)
+	if err != nil {
+		t.Fatalf("parseFetchTokens() error = %v", err)
+	}
+	var l AddressList
+	if err := d.parseEnvelopeAddresses(&l, tks[1], &mime.WordDecoder{}, nil, "TO"); err != nil {
+		t.Fatalf("parseEnvelopeAddresses() error = %v", err)
+	}
+	if got, want := l.String(), "team: Ann <Ann@Example.com>;, undisclosed-recipients:;, Bob <bob@example.com>"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestParseEmailBody_AddressOrder(t *testing.T) {
+	t.Parallel()
+	d := &Dialer{}
+	e := &Email{}
+	body := "From: Zed <zed@example.com>\r\nTo: b@example.com, a@example.com, B@example.com\r\n" +
+		"Cc: undisclosed-recipients:;\r\nSubject: x\r\n\r\nHi"
+	if !d.parseEmailBody(e, body) {
+		t.Fatal("expected parsing to succeed")
+	}
+	if got := e.ToList.String(); got != "b@example.com, a@example.com, B@example.com" {
+		t.Errorf("ToList = %q", got)
+	}
+	if got := e.To.String(); got != "a@example.com, b@example.com" {
+		t.Errorf("To = %q", got)
+	}
+	if len(e.CCList) != 1 || e.CCList[0].Group != "undisclosed-recipients" || len(e.CC) != 0 {
+		t.Errorf("CCList = %+v, CC = %v", e.CCList, e.CC)
+	}
+	if got := e.String(); got != "Subject: x\nTo: b@example.com, a@example.com, B@example.com\n"+
+		"From: Zed <zed@example.com>\nCC: undisclosed-recipients:;\nText: Hi(2 B)\n" {
+		t.Errorf("String() = %q", got)
+	}
+}
@@ -1,8 +1,8 @@
 package imap
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -10,24 +10,24 @@ import (
 	"github.com/rs/xid"
 )
 
-// waitForTaggedOK reads lines from r until it finds the tagged response matching tag.
-// It returns nil if the response is OK, or an error otherwise.
-func (d *Dialer) waitForTaggedOK(r *bufio.Reader, tag []byte) error {
+// waitForTaggedOK reads the responses routed to cmd until it finds the
+// tagged response matching tag. It returns nil if the response is OK, or an
+// error otherwise.
+func (d *Dialer) waitForTaggedOK(ctx context.Context, cmd *pendingCommand, tag []byte, expired <-chan time.Time) error {
 	taglen := len(tag)
 	for {
-		line, err := r.ReadBytes('\n')
+		line, err := d.reader.next(ctx, cmd, expired)
 		if err != nil {
-			_ = d.Close()
-			return fmt.Errorf("imap append read response: %w", err)
+			_ = d.closeLocked()
+			return fmt.Errorf("imap append read response: %w", d.contextError(ctx, err))
 		}
 
-		if Verbose && !SkipResponses {
-			debugLog(d.ConnNum, d.Folder, "server response", "response", string(dropNl(line)))
-		}
+		d.responseLog(line)
+		d.handleUnsolicited(d.config.UnsolicitedHandler, "APPEND", line)
 
 		if len(line) >= taglen+3 && bytes.Equal(line[:taglen], tag) {
 			if !bytes.Equal(line[taglen+1:taglen+3], []byte("OK")) {
-				return fmt.Errorf("imap append failed: %s", dropNl(line[taglen+1:]))
+				return fmt.Errorf("imap append: %w", parseStatusError(line[taglen+1:]))
 			}
 			return nil
 		}
@@ -51,6 +51,13 @@ func (d *Dialer) waitForTaggedOK(r *bufio.Reader, tag []byte) error {
 //	msg := []byte("From: a@b.com\r\nTo: c@d.com\r\nSubject: Hi\r\n\r\nHello!")
 //	err := conn.Append("INBOX", []string{`\Seen`}, time.Time{}, msg)
 func (d *Dialer) Append(folder string, flags []string, date time.Time, message []byte) error {
+	return d.AppendContext(context.Background(), folder, flags, date, message)
+}
+
+// AppendContext is like Append but honors ctx. Because APPEND is never
+// retried, an interrupted upload leaves the connection closed and the
+// message may or may not have been stored by the server.
+func (d *Dialer) AppendContext(ctx context.Context, folder string, flags []string, date time.Time, message []byte) error {
 	// Build the APPEND command prefix
 	flagStr := ""
 	if len(flags) > 0 {
@@ -61,38 +68,57 @@ func (d *Dialer) Append(folder string, flags []string, date time.Time, message [
 		dateStr = fmt.Sprintf(` "%s"`, date.Format(TimeFormat))
 	}
 
-	cmd := fmt.Sprintf(`APPEND "%s"%s%s {%d}`,
-		AddSlashes.Replace(folder), flagStr, dateStr, len(message))
+	command := fmt.Sprintf(`APPEND %s%s%s {%d}`,
+		d.quoteMailbox(folder), flagStr, dateStr, len(message))
 
 	tag := []byte(strings.ToUpper(xid.New().String()))
 
-	if CommandTimeout != 0 {
-		_ = d.conn.SetDeadline(time.Now().Add(CommandTimeout))
-		defer func() { _ = d.conn.SetDeadline(time.Time{}) }()
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	if Verbose {
-		debugLog(d.ConnNum, d.Folder, "sending command", "command", string(tag)+" "+cmd)
-	}
+	d.lock()
+	defer d.unlock()
 
-	// Phase 1: Send the APPEND command with literal size
-	_, err := fmt.Fprintf(d.conn, "%s %s\r\n", tag, cmd)
+	cmd, err := d.startCommand(tag, modeBuffered)
 	if err != nil {
-		return fmt.Errorf("imap append write command: %w", err)
+		return fmt.Errorf("imap append: %w", err)
 	}
+	defer d.reader.release(cmd)
+
+	stop := d.watchContext(ctx)
+	defer stop()
+	expired, stopTimer := deadlineTimer(d.commandDeadline(ctx))
+	defer stopTimer()
+
+	d.debugLog("sending command", "command", string(tag)+" "+command)
 
-	// Phase 2: Wait for continuation response (+)
-	r := bufio.NewReader(d.conn)
-	line, err := r.ReadBytes('\n')
+	// Phase 1: Send the APPEND command with literal size
+	_, err = fmt.Fprintf(d.conn, "%s %s\r\n", tag, command)
 	if err != nil {
-		_ = d.Close()
-		return fmt.Errorf("imap append read continuation: %w", err)
+		return fmt.Errorf("imap append write command: %w", d.contextError(ctx, err))
 	}
 
-	if Verbose && !SkipResponses {
-		debugLog(d.ConnNum, d.Folder, "server response", "response", string(dropNl(line)))
+	// Phase 2: Wait for continuation response (+), skipping untagged data
+	var line []byte
+	for {
+		line, err = d.reader.next(ctx, cmd, expired)
+		if err != nil {
+			_ = d.closeLocked()
+			return fmt.Errorf("imap append read continuation: %w", d.contextError(ctx, err))
+		}
+		d.responseLog(line)
+		d.handleUnsolicited(d.config.UnsolicitedHandler, "APPEND", line)
+		if !bytes.HasPrefix(line, []byte("* ")) {
+			break
+		}
 	}
 
+	// The server may refuse the message before the literal is sent, e.g.
+	// with NO [TRYCREATE] when the folder does not exist
+	if len(line) > len(tag) && bytes.HasPrefix(line, tag) && line[len(tag)] == ' ' {
+		return fmt.Errorf("imap append: %w", parseStatusError(line[len(tag)+1:]))
+	}
 	if !bytes.HasPrefix(bytes.TrimSpace(line), []byte("+")) {
 		return fmt.Errorf("imap append: expected continuation (+), got: %s", dropNl(line))
 	}
@@ -100,15 +126,15 @@ func (d *Dialer) Append(folder string, flags []string, date time.Time, message [
 	// Phase 3: Send the literal message bytes
 	_, err = d.conn.Write(message)
 	if err != nil {
-		_ = d.Close()
-		return fmt.Errorf("imap append write literal: %w", err)
+		_ = d.closeLocked()
+		return fmt.Errorf("imap append write literal: %w", d.contextError(ctx, err))
 	}
 	_, err = d.conn.Write([]byte("\r\n"))
 	if err != nil {
-		_ = d.Close()
-		return fmt.Errorf("imap append write crlf: %w", err)
+		_ = d.closeLocked()
+		return fmt.Errorf("imap append write crlf: %w", d.contextError(ctx, err))
 	}
 
 	// Phase 4: Read the tagged response
-	return d.waitForTaggedOK(r, tag)
+	return d.waitForTaggedOK(ctx, cmd, tag, expired)
 }
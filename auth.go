@@ -1,6 +1,7 @@
 package imap
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/sqs/go-xoauth2"
@@ -8,15 +9,50 @@ import (
 
 // Authenticate performs XOAUTH2 authentication using an access token
 func (d *Dialer) Authenticate(user string, accessToken string) (err error) {
+	return d.AuthenticateContext(context.Background(), user, accessToken)
+}
+
+// AuthenticateContext is like Authenticate but honors ctx
+func (d *Dialer) AuthenticateContext(ctx context.Context, user string, accessToken string) (err error) {
+	d.lock()
+	defer d.unlock()
+	return d.authenticateLocked(ctx, user, accessToken)
+}
+
+// authenticateLocked is AuthenticateContext for callers holding the
+// connection lock
+func (d *Dialer) authenticateLocked(ctx context.Context, user string, accessToken string) (err error) {
 	b64 := xoauth2.XOAuth2String(user, accessToken)
+	// Capabilities change once authenticated; the OK may carry the new list
+	d.caps = nil
 	// Don't retry authentication - auth failures should not trigger reconnection
-	_, err = d.Exec(fmt.Sprintf("AUTHENTICATE XOAUTH2 %s", b64), false, 0, nil)
-	return err
+	_, err = d.execLocked(ctx, fmt.Sprintf("AUTHENTICATE XOAUTH2 %s", b64), false, true, 0, nil)
+	if err != nil {
+		return fmt.Errorf("imap authenticate: %w", err)
+	}
+	return nil
 }
 
 // Login performs LOGIN authentication using username and password
 func (d *Dialer) Login(username string, password string) (err error) {
+	return d.LoginContext(context.Background(), username, password)
+}
+
+// LoginContext is like Login but honors ctx
+func (d *Dialer) LoginContext(ctx context.Context, username string, password string) (err error) {
+	d.lock()
+	defer d.unlock()
+	return d.loginLocked(ctx, username, password)
+}
+
+// loginLocked is LoginContext for callers holding the connection lock
+func (d *Dialer) loginLocked(ctx context.Context, username string, password string) (err error) {
+	// Capabilities change once authenticated; the OK may carry the new list
+	d.caps = nil
 	// Don't retry authentication - auth failures should not trigger reconnection
-	_, err = d.Exec(fmt.Sprintf(`LOGIN "%s" "%s"`, AddSlashes.Replace(username), AddSlashes.Replace(password)), false, 0, nil)
-	return err
+	_, err = d.execLocked(ctx, fmt.Sprintf(`LOGIN "%s" "%s"`, AddSlashes.Replace(username), AddSlashes.Replace(password)), false, true, 0, nil)
+	if err != nil {
+		return fmt.Errorf("imap login: %w", err)
+	}
+	return nil
 }
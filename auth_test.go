@@ -14,6 +14,7 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -27,12 +28,34 @@ type mockIMAPServer struct {
 	validPass      string
 	failAuth       bool
 	failConnection bool
-	responses      map[string]string
-	failCommands   map[string]bool // commands that should return NO (keyed by uppercase command name)
+	responses      map[string]string // untagged lines sent before OK (keyed by command without tag)
+	failCommands   map[string]bool   // commands that should return NO (keyed by uppercase command name)
+	failLines      map[string]bool   // commands that should return NO (keyed by command without tag)
+	hangCommands   map[string]bool   // commands that never get a response (keyed by uppercase command name)
+	failCodes      map[string]string // response code sent with a failCommands NO, e.g. "TRYCREATE"
+	okCodes        map[string]string // response code sent with OK (keyed by uppercase command name), e.g. "MODIFIED 2"
 	tlsConfig      *tls.Config
+	plaintext      bool   // listener is unencrypted; connections start without TLS
+	starttls       bool   // advertise and accept STARTTLS on plaintext connections
+	plainLogins    int32  // authentication attempts made over an unencrypted connection
+	capabilities   string // capability list advertised once secure; empty means the default
+	idleEvents     string // untagged lines sent after accepting IDLE
+
+	mu       sync.Mutex
+	received []string // commands received, without tags
 }
 
 func newMockIMAPServer(validUser, validPass string) (*mockIMAPServer, error) {
+	return startMockIMAPServer(validUser, validPass, false, false)
+}
+
+// newPlaintextMockIMAPServer starts a mock server without implicit TLS. When
+// starttls is set, it advertises STARTTLS and upgrades on request.
+func newPlaintextMockIMAPServer(validUser, validPass string, starttls bool) (*mockIMAPServer, error) {
+	return startMockIMAPServer(validUser, validPass, true, starttls)
+}
+
+func startMockIMAPServer(validUser, validPass string, plaintext, starttls bool) (*mockIMAPServer, error) {
 	// Generate a certificate for testing
 	cert, err := generateSelfSignedCertificate()
 	if err != nil {
@@ -43,9 +66,14 @@ func newMockIMAPServer(validUser, validPass string) (*mockIMAPServer, error) {
 		Certificates: []tls.Certificate{cert},
 	}
 
-	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	var listener net.Listener
+	if plaintext {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	} else {
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create TLS listener: %v", err)
+		return nil, fmt.Errorf("failed to create listener: %v", err)
 	}
 
 	server := &mockIMAPServer{
@@ -55,7 +83,13 @@ func newMockIMAPServer(validUser, validPass string) (*mockIMAPServer, error) {
 		validPass:    validPass,
 		responses:    make(map[string]string),
 		failCommands: make(map[string]bool),
+		failLines:    make(map[string]bool),
+		hangCommands: make(map[string]bool),
+		failCodes:    make(map[string]string),
+		okCodes:      make(map[string]string),
 		tlsConfig:    tlsConfig,
+		plaintext:    plaintext,
+		starttls:     starttls,
 	}
 
 	go server.serve()
@@ -73,7 +107,7 @@ func (s *mockIMAPServer) serve() {
 }
 
 func (s *mockIMAPServer) handleConnection(conn net.Conn) {
-	defer conn.Close()
+	defer func() { conn.Close() }()
 
 	if s.failConnection {
 		// Simulate connection failure
@@ -86,6 +120,8 @@ func (s *mockIMAPServer) handleConnection(conn net.Conn) {
 	writer.WriteString("* OK IMAP4rev1 Mock Server Ready\r\n")
 	writer.Flush()
 
+	secure := !s.plaintext
+
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil {
@@ -100,10 +136,16 @@ func (s *mockIMAPServer) handleConnection(conn net.Conn) {
 
 		tag := parts[0]
 		command := strings.ToUpper(parts[1])
+		s.mu.Lock()
+		s.received = append(s.received, strings.TrimPrefix(line, tag+" "))
+		s.mu.Unlock()
 
 		switch command {
 		case "LOGIN":
 			atomic.AddInt32(&s.authAttempts, 1)
+			if !secure {
+				atomic.AddInt32(&s.plainLogins, 1)
+			}
 			if s.failAuth {
 				writer.WriteString(fmt.Sprintf("%s NO LOGIN failed\r\n", tag))
 			} else if len(parts) >= 4 {
@@ -122,6 +164,9 @@ func (s *mockIMAPServer) handleConnection(conn net.Conn) {
 
 		case "AUTHENTICATE":
 			atomic.AddInt32(&s.authAttempts, 1)
+			if !secure {
+				atomic.AddInt32(&s.plainLogins, 1)
+			}
 			if s.failAuth {
 				writer.WriteString(fmt.Sprintf("%s NO AUTHENTICATE failed\r\n", tag))
 			} else {
@@ -130,10 +175,37 @@ func (s *mockIMAPServer) handleConnection(conn net.Conn) {
 			}
 
 		case "CAPABILITY":
-			writer.WriteString("* CAPABILITY IMAP4rev1 LOGIN AUTHENTICATE\r\n")
+			if s.starttls && !secure {
+				writer.WriteString("* CAPABILITY IMAP4rev1 STARTTLS LOGINDISABLED\r\n")
+			} else if s.capabilities != "" {
+				writer.WriteString("* CAPABILITY " + s.capabilities + "\r\n")
+			} else {
+				writer.WriteString("* CAPABILITY IMAP4rev1 LOGIN AUTHENTICATE\r\n")
+			}
 			writer.WriteString(fmt.Sprintf("%s OK CAPABILITY completed\r\n", tag))
 
+		case "STARTTLS":
+			if !s.starttls || secure {
+				writer.WriteString(fmt.Sprintf("%s BAD STARTTLS not available\r\n", tag))
+				break
+			}
+			writer.WriteString(fmt.Sprintf("%s OK Begin TLS negotiation now\r\n", tag))
+			writer.Flush()
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			reader = bufio.NewReader(conn)
+			writer = bufio.NewWriter(conn)
+			secure = true
+			continue
+
 		case "APPEND":
+			if s.failCommands[command] {
+				writer.WriteString(fmt.Sprintf("%s NO %s%s failed\r\n", tag, s.responseCode(command), command))
+				break
+			}
 			// Two-phase APPEND literal continuation protocol
 			literalSize := 0
 			if idx := strings.LastIndex(line, "{"); idx != -1 {
@@ -163,6 +235,15 @@ func (s *mockIMAPServer) handleConnection(conn net.Conn) {
 			writer.WriteString("* 0 EXISTS\r\n* 0 RECENT\r\n")
 			writer.WriteString(fmt.Sprintf("%s OK EXAMINE completed\r\n", tag))
 
+		case "IDLE":
+			writer.WriteString("+ idling\r\n")
+			writer.WriteString(s.idleEvents)
+			writer.Flush()
+			if _, err := reader.ReadString('\n'); err != nil {
+				return
+			}
+			writer.WriteString(fmt.Sprintf("%s OK IDLE terminated\r\n", tag))
+
 		case "LOGOUT":
 			writer.WriteString("* BYE IMAP4rev1 Server logging out\r\n")
 			writer.WriteString(fmt.Sprintf("%s OK LOGOUT completed\r\n", tag))
@@ -170,10 +251,16 @@ func (s *mockIMAPServer) handleConnection(conn net.Conn) {
 			return
 
 		default:
-			if s.failCommands[command] {
-				writer.WriteString(fmt.Sprintf("%s NO %s failed\r\n", tag, command))
+			if s.hangCommands[command] {
+				continue
+			}
+			if resp, ok := s.responses[strings.TrimPrefix(line, tag+" ")]; ok {
+				writer.WriteString(resp)
+			}
+			if s.failCommands[command] || s.failLines[strings.TrimPrefix(line, tag+" ")] {
+				writer.WriteString(fmt.Sprintf("%s NO %s%s failed\r\n", tag, s.responseCode(command), command))
 			} else {
-				writer.WriteString(fmt.Sprintf("%s OK %s completed\r\n", tag, command))
+				writer.WriteString(fmt.Sprintf("%s OK %s%s completed\r\n", tag, bracketed(s.okCodes[command]), command))
 			}
 		}
 
@@ -185,6 +272,32 @@ func (s *mockIMAPServer) GetAuthAttempts() int {
 	return int(atomic.LoadInt32(&s.authAttempts))
 }
 
+func (s *mockIMAPServer) GetPlaintextAuthAttempts() int {
+	return int(atomic.LoadInt32(&s.plainLogins))
+}
+
+// responseCode returns the bracketed response code configured for command,
+// followed by a space, or "" if there is none
+func (s *mockIMAPServer) responseCode(command string) string {
+	return bracketed(s.failCodes[command])
+}
+
+// bracketed returns code in brackets followed by a space, or "" if code is
+// empty
+func bracketed(code string) string {
+	if code == "" {
+		return ""
+	}
+	return "[" + code + "] "
+}
+
+// Commands returns the commands received so far, without their tags
+func (s *mockIMAPServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.received...)
+}
+
 func (s *mockIMAPServer) ResetAuthAttempts() {
 	atomic.StoreInt32(&s.authAttempts, 0)
 }
@@ -0,0 +1,510 @@
+package imap
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// BodyStructure is the MIME structure of a message or of one of its parts, as
+// returned by FETCH BODYSTRUCTURE. It tells which parts a message has, and how
+// large they are, without downloading them.
+//
+// Parts are numbered as in RFC 3501 §6.4.5: the parts of a multipart message
+// are "1", "2", ..., their subparts "2.1", "2.2" and so on. A message that is
+// not multipart has a single part "1". The body of an encapsulated
+// message/rfc822 part "3" is numbered "3.1" if it is a single part; if it is
+// multipart its parts are "3.1", "3.2", ...
+type BodyStructure struct {
+	// Path is the part number, e.g. "2.1", or "" for the top-level
+	// multipart of a message. See Section for the specifier to fetch it with.
+	Path string
+	// MIMEType and MIMESubtype are the lower-case media type, e.g. "text"
+	// and "plain"
+	MIMEType    string
+	MIMESubtype string
+	// Params holds the Content-Type parameters with lower-case names, e.g.
+	// "charset" or "name". RFC 2231 continuations and encoded values such
+	// as name*=utf-8''%E2%82%AC.txt are merged and decoded under the plain
+	// name.
+	Params      map[string]string
+	ID          string
+	Description string
+	// Encoding is the lower-case Content-Transfer-Encoding, e.g. "base64"
+	Encoding string
+	// Size is the encoded size of the part in bytes
+	Size int
+	// Lines is the encoded size in lines of text and message/rfc822 parts
+	Lines int
+	MD5   string
+	// Disposition is the lower-case Content-Disposition, e.g. "attachment",
+	// or "" if the part has none; DispositionParams holds its parameters
+	// with lower-case names, e.g. "filename", decoded as Params are
+	Disposition       string
+	DispositionParams map[string]string
+	Language          []string
+	Location          string
+
+	// Parts holds the parts of a multipart part
+	Parts []*BodyStructure
+	// Envelope and Message describe the encapsulated message of a
+	// message/rfc822 part
+	Envelope *Email
+	Message  *BodyStructure
+
+	// encapsulated is set on the multipart body of a message/rfc822 part,
+	// which shares its part number
+	encapsulated bool
+}
+
+// MediaType returns the lower-case media type, e.g. "text/plain"
+func (b *BodyStructure) MediaType() string {
+	return b.MIMEType + "/" + b.MIMESubtype
+}
+
+// IsMultipart reports whether the part is a multipart with subparts
+func (b *BodyStructure) IsMultipart() bool {
+	return b.MIMEType == "multipart"
+}
+
+// Filename returns the decoded file name from the Content-Disposition
+// filename parameter, or from the Content-Type name parameter, or "" if the
+// part has neither
+func (b *BodyStructure) Filename() string {
+	name := b.DispositionParams["filename"]
+	if name == "" {
+		name = b.Params["name"]
+	}
+	dec := mime.WordDecoder{CharsetReader: CharsetReader}
+	if decoded, err := dec.DecodeHeader(name); err == nil {
+		return decoded
+	}
+	return name
+}
+
+// Section returns the specifier to fetch the part's content with, e.g.
+// "2.1", or "TEXT" for the top-level multipart. Use it with
+// FetchBuilder.Section or FetchBuilder.Partial.
+func (b *BodyStructure) Section() string {
+	switch {
+	case b.Path == "":
+		return "TEXT"
+	case b.encapsulated:
+		return b.Path + ".TEXT"
+	}
+	return b.Path
+}
+
+// MIMESection returns the specifier of the part's MIME header, e.g. "2.1.MIME",
+// or "HEADER" for the top-level multipart
+func (b *BodyStructure) MIMESection() string {
+	switch {
+	case b.Path == "":
+		return "HEADER"
+	case b.encapsulated:
+		return b.Path + ".HEADER"
+	}
+	return b.Path + ".MIME"
+}
+
+// Walk calls fn for the part and each of its subparts, depth first, including
+// the bodies of encapsulated messages. If fn returns false, the subparts of
+// that part are skipped.
+func (b *BodyStructure) Walk(fn func(part *BodyStructure) bool) {
+	if !fn(b) {
+		return
+	}
+	for _, p := range b.Parts {
+		p.Walk(fn)
+	}
+	if b.Message != nil {
+		b.Message.Walk(fn)
+	}
+}
+
+// Part returns the part numbered path, e.g. "2.1", or nil if there is none.
+// For a message/rfc822 part, the part itself is returned rather than the
+// multipart body sharing its number.
+func (b *BodyStructure) Part(path string) *BodyStructure {
+	var found *BodyStructure
+	b.Walk(func(p *BodyStructure) bool {
+		if found == nil && p.Path == path {
+			found = p
+		}
+		return found == nil
+	})
+	return found
+}
+
+// IsAttachment reports whether the part is meant to be saved rather than
+// displayed: it has an attachment disposition, or it is a non-multipart part
+// with a file name that is not inline text
+func (b *BodyStructure) IsAttachment() bool {
+	switch {
+	case b.Disposition == "attachment":
+		return true
+	case b.IsMultipart(), b.Filename() == "":
+		return false
+	}
+	return b.Disposition != "inline" || b.MIMEType != "text"
+}
+
+// Attachments returns the attachments of the message, in order. An attached
+// message/rfc822 part is returned as a whole, without its own attachments.
+func (b *BodyStructure) Attachments() []*BodyStructure {
+	var parts []*BodyStructure
+	b.Walk(func(p *BodyStructure) bool {
+		if p.IsAttachment() {
+			parts = append(parts, p)
+			return false
+		}
+		return true
+	})
+	return parts
+}
+
+// BestText returns the text part to display, preferring the subtypes in the
+// order given, e.g. BestText("html", "plain"). Attachments and encapsulated
+// messages are skipped, and of the alternatives of a multipart/alternative
+// the last one wins, as RFC 2046 orders them from plainest to richest. With
+// no subtypes, any text part is accepted. It returns nil if there is no such
+// part.
+func (b *BodyStructure) BestText(subtypes ...string) *BodyStructure {
+	best, _ := b.bestText(subtypes)
+	return best
+}
+
+// bestText returns the best text part within b and its rank, the index of its
+// subtype in subtypes
+func (b *BodyStructure) bestText(subtypes []string) (*BodyStructure, int) {
+	switch {
+	case b.IsAttachment(), b.MediaType() == "message/rfc822":
+		return nil, 0
+	case b.IsMultipart():
+		var best *BodyStructure
+		rank := 0
+		for _, p := range b.Parts {
+			found, r := p.bestText(subtypes)
+			// Of equally good parts, the last alternative is the richest, but
+			// the first part of a multipart/mixed is the body
+			if found != nil && (best == nil || r < rank || (r == rank && b.MIMESubtype == "alternative")) {
+				best, rank = found, r
+			}
+		}
+		return best, rank
+	case b.MIMEType != "text":
+		return nil, 0
+	case len(subtypes) == 0:
+		return b, 0
+	}
+	for i, s := range subtypes {
+		if strings.EqualFold(s, b.MIMESubtype) {
+			return b, i
+		}
+	}
+	return nil, 0
+}
+
+// ParseBodyStructure parses the value of a BODYSTRUCTURE (or BODY) item, as
+// found in the tokens returned by ParseFetchResponse, into a BodyStructure
+func (d *Dialer) ParseBodyStructure(t *Token) (*BodyStructure, error) {
+	if err := d.CheckType(t, []TType{TContainer}, nil, "for BODYSTRUCTURE"); err != nil {
+		return nil, err
+	}
+	path := "1"
+	if len(t.Tokens) > 0 && t.Tokens[0].Type == TContainer {
+		path = ""
+	}
+	return d.parseBodyPart(t, path)
+}
+
+// parseBodyPart parses the body of the part numbered path
+func (d *Dialer) parseBodyPart(t *Token, path string) (*BodyStructure, error) {
+	if err := d.CheckType(t, []TType{TContainer}, nil, "for BODYSTRUCTURE part %q", path); err != nil {
+		return nil, err
+	}
+	b := &BodyStructure{Path: path}
+	tks := t.Tokens
+	if len(tks) > 0 && tks[0].Type == TContainer {
+		if err := d.parseMultipart(b, tks); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+
+	if len(tks) < 7 {
+		return nil, fmt.Errorf("IMAP%d:%s: BODYSTRUCTURE part %q has %d fields, want at least 7", d.ConnNum, d.Folder, path, len(tks))
+	}
+	var err error
+	for i, f := range []struct {
+		dest *string
+		loc  string
+	}{
+		{&b.MIMEType, "type"},
+		{&b.MIMESubtype, "subtype"},
+		{&b.ID, "id"},
+		{&b.Description, "description"},
+		{&b.Encoding, "encoding"},
+	} {
+		// The parameters are the third field
+		if i >= 2 {
+			i++
+		}
+		if *f.dest, err = d.bodyString(tks[i], path, f.loc); err != nil {
+			return nil, err
+		}
+	}
+	if b.Params, err = d.bodyParams(tks[2], path); err != nil {
+		return nil, err
+	}
+	b.MIMEType = strings.ToLower(b.MIMEType)
+	b.MIMESubtype = strings.ToLower(b.MIMESubtype)
+	b.Encoding = strings.ToLower(b.Encoding)
+	if b.Size, err = d.bodyNumber(tks[6], path, "size"); err != nil {
+		return nil, err
+	}
+
+	i := 7
+	switch {
+	case b.MIMEType == "text" && len(tks) > 7:
+		if b.Lines, err = d.bodyNumber(tks[7], path, "lines"); err != nil {
+			return nil, err
+		}
+		i = 8
+	case b.MediaType() == "message/rfc822":
+		// Some servers leave out the envelope, body or line count, so each
+		// is optional
+		if len(tks) > 7 && tks[7].Type != TNil {
+			b.Envelope = &Email{}
+			if err := d.parseEnvelope(b.Envelope, tks[7], nil); err != nil {
+				return nil, err
+			}
+		}
+		if len(tks) > 8 && tks[8].Type != TNil {
+			// A single-part body is part 1 of the message; the parts of a
+			// multipart body are numbered directly below it
+			sub := path + ".1"
+			if tks[8].Type == TContainer && len(tks[8].Tokens) > 0 && tks[8].Tokens[0].Type == TContainer {
+				sub = path
+			}
+			if b.Message, err = d.parseBodyPart(tks[8], sub); err != nil {
+				return nil, err
+			}
+			b.Message.encapsulated = b.Message.IsMultipart()
+		}
+		if len(tks) > 9 {
+			if b.Lines, err = d.bodyNumber(tks[9], path, "lines"); err != nil {
+				return nil, err
+			}
+		}
+		i = 10
+	}
+
+	// Extension data: MD5, disposition, language, location
+	if i < len(tks) {
+		if b.MD5, err = d.bodyString(tks[i], path, "md5"); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.parseBodyExtension(b, tks[min(i+1, len(tks)):]); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// parseMultipart parses the parts, subtype and extension data of a multipart
+func (d *Dialer) parseMultipart(b *BodyStructure, tks []*Token) error {
+	b.MIMEType = "multipart"
+	i := 0
+	for ; i < len(tks) && tks[i].Type == TContainer; i++ {
+		sub := strconv.Itoa(i + 1)
+		if b.Path != "" {
+			sub = b.Path + "." + sub
+		}
+		part, err := d.parseBodyPart(tks[i], sub)
+		if err != nil {
+			return err
+		}
+		b.Parts = append(b.Parts, part)
+	}
+	if i == len(tks) {
+		return fmt.Errorf("IMAP%d:%s: BODYSTRUCTURE multipart %q has no subtype", d.ConnNum, d.Folder, b.Path)
+	}
+	subtype, err := d.bodyString(tks[i], b.Path, "subtype")
+	if err != nil {
+		return err
+	}
+	b.MIMESubtype = strings.ToLower(subtype)
+	i++
+
+	// Extension data: parameters, disposition, language, location
+	if i < len(tks) {
+		if b.Params, err = d.bodyParams(tks[i], b.Path); err != nil {
+			return err
+		}
+		i++
+	}
+	return d.parseBodyExtension(b, tks[i:])
+}
+
+// parseBodyExtension parses the disposition, language and location that
+// follow the type-specific extension data. Each is optional.
+func (d *Dialer) parseBodyExtension(b *BodyStructure, tks []*Token) (err error) {
+	if len(tks) > 0 && tks[0].Type == TContainer && len(tks[0].Tokens) > 0 {
+		dsp := tks[0].Tokens
+		if b.Disposition, err = d.bodyString(dsp[0], b.Path, "disposition"); err != nil {
+			return err
+		}
+		b.Disposition = strings.ToLower(b.Disposition)
+		if len(dsp) > 1 {
+			if b.DispositionParams, err = d.bodyParams(dsp[1], b.Path); err != nil {
+				return err
+			}
+		}
+	}
+	if len(tks) > 1 {
+		switch t := tks[1]; t.Type {
+		case TContainer:
+			for _, l := range t.Tokens {
+				lang, err := d.bodyString(l, b.Path, "language")
+				if err != nil {
+					return err
+				}
+				b.Language = append(b.Language, lang)
+			}
+		case TNil:
+		default:
+			lang, err := d.bodyString(t, b.Path, "language")
+			if err != nil {
+				return err
+			}
+			b.Language = []string{lang}
+		}
+	}
+	if len(tks) > 2 {
+		if b.Location, err = d.bodyString(tks[2], b.Path, "location"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bodyString returns the value of a BODYSTRUCTURE string field; NIL is ""
+func (d *Dialer) bodyString(t *Token, path, loc string) (string, error) {
+	if err := d.CheckType(t, []TType{TQuoted, TAtom, TLiteral, TNil}, nil, "for BODYSTRUCTURE part %q %s", path, loc); err != nil {
+		return "", err
+	}
+	return t.Str, nil
+}
+
+// bodyNumber returns the value of a BODYSTRUCTURE number field; NIL is 0
+func (d *Dialer) bodyNumber(t *Token, path, loc string) (int, error) {
+	if err := d.CheckType(t, []TType{TNumber, TNil}, nil, "for BODYSTRUCTURE part %q %s", path, loc); err != nil {
+		return 0, err
+	}
+	return t.Num, nil
+}
+
+// bodyParams returns a BODYSTRUCTURE parameter list with lower-case names and
+// RFC 2231 parameters decoded
+func (d *Dialer) bodyParams(t *Token, path string) (map[string]string, error) {
+	if t.Type == TNil {
+		return nil, nil
+	}
+	if err := d.CheckType(t, []TType{TContainer}, nil, "for BODYSTRUCTURE part %q parameters", path); err != nil {
+		return nil, err
+	}
+	params := make(map[string]string, len(t.Tokens)/2)
+	for i := 0; i+1 < len(t.Tokens); i += 2 {
+		name, err := d.bodyString(t.Tokens[i], path, "parameter name")
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.bodyString(t.Tokens[i+1], path, "parameter value")
+		if err != nil {
+			return nil, err
+		}
+		params[strings.ToLower(name)] = value
+	}
+	return decodeParams(params), nil
+}
+
+// paramSection is one section of an RFC 2231 parameter, e.g. the value of
+// "filename*1*"
+type paramSection struct {
+	value    string
+	extended bool // percent-encoded, with a charset in section 0
+}
+
+// decodeParams merges RFC 2231 continuations ("filename*0", "filename*1",
+// ...) and decodes extended values ("filename*=utf-8'en'%E2%82%AC.pdf") under
+// the plain name, where they take precedence over a plain value. Sections
+// that cannot be decoded are dropped, keeping the plain value if there is one.
+func decodeParams(raw map[string]string) map[string]string {
+	params := make(map[string]string, len(raw))
+	sections := make(map[string]map[int]paramSection)
+	for key, value := range raw {
+		name, rest, ok := strings.Cut(key, "*")
+		if !ok {
+			params[key] = value
+			continue
+		}
+		number, extended := strings.CutSuffix(rest, "*")
+		n := 0
+		if rest != "" {
+			var err error
+			if n, err = strconv.Atoi(number); err != nil || n < 0 {
+				continue
+			}
+		} else {
+			extended = true
+		}
+		if sections[name] == nil {
+			sections[name] = make(map[int]paramSection)
+		}
+		sections[name][n] = paramSection{value: value, extended: extended}
+	}
+
+	for name, parts := range sections {
+		var (
+			buf      []byte
+			charset  string
+			extended bool
+			ok       = true
+		)
+		for n := 0; ok; n++ {
+			s, found := parts[n]
+			if !found {
+				ok = n > 0
+				break
+			}
+			if !s.extended {
+				buf = append(buf, s.value...)
+				continue
+			}
+			value := s.value
+			if n == 0 {
+				// charset'language'value
+				if cs, rest, found := strings.Cut(value, "'"); found {
+					if _, v, found := strings.Cut(rest, "'"); found {
+						charset, value = cs, v
+					}
+				}
+			}
+			decoded, err := url.PathUnescape(value)
+			ok = err == nil
+			buf = append(buf, decoded...)
+			extended = true
+		}
+		switch {
+		case !ok:
+		case extended:
+			params[name] = decodeCharset(buf, charset)
+		default:
+			params[name] = string(buf)
+		}
+	}
+	return params
+}
@@ -0,0 +1,192 @@
+package imap
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// parseBodyStructureString parses the BODYSTRUCTURE value s
+func parseBodyStructureString(t *testing.T, s string) *BodyStructure {
+	t.Helper()
+	tks, err := parseFetchTokens("BODYSTRUCTURE " + s)
+	if err != nil {
+		t.Fatalf("parseFetchTokens() error = %v", err)
+	}
+	d := &Dialer{}
+	b, err := d.ParseBodyStructure(tks[1])
+	if err != nil {
+		t.Fatalf("ParseBodyStructure() error = %v", err)
+	}
+	return b
+}
+
+func TestParseBodyStructure_Multipart(t *testing.T) {
+	t.Parallel()
+	b := parseBodyStructureString(t, `(`+
+		`(("TEXT" "PLAIN" ("CHARSET" "utf-8") NIL NIL "7BIT" 12 1 NIL NIL NIL NIL)`+
+		`("TEXT" "HTML" ("CHARSET" "utf-8") NIL NIL "QUOTED-PRINTABLE" 40 2 NIL NIL NIL NIL) "ALTERNATIVE" ("BOUNDARY" "alt") NIL NIL NIL)`+
+		`("APPLICATION" "PDF" ("NAME" "x.pdf") "<id1>" "Resume" "BASE64" 5000 NIL `+
+		`("ATTACHMENT" ("FILENAME" "=?UTF-8?Q?r=C3=A9sum=C3=A9.pdf?=")) "EN" "https://example.com/r.pdf")`+
+		`("MESSAGE" "RFC822" NIL NIL NIL "7BIT" 900 `+
+		`("Thu, 9 Apr 2026 21:06:17 +0000" "Fwd" NIL NIL NIL NIL NIL NIL NIL "<2@example.com>") `+
+		`(("TEXT" "PLAIN" NIL NIL NIL "7BIT" 10 1)("IMAGE" "PNG" ("NAME" "a.png") NIL NIL "BASE64" 300) "MIXED") 30)`+
+		` "MIXED" ("BOUNDARY" "xyz") NIL ("en" "de") NIL)`)
+
+	if b.Path != "" || b.Section() != "TEXT" || b.MediaType() != "multipart/mixed" || b.Params["boundary"] != "xyz" {
+		t.Errorf("root = %+v", b)
+	}
+	if !slices.Equal(b.Language, []string{"en", "de"}) {
+		t.Errorf("root Language = %q", b.Language)
+	}
+
+	var paths []string
+	b.Walk(func(p *BodyStructure) bool {
+		paths = append(paths, p.MediaType()+" "+p.Section())
+		return true
+	})
+	want := []string{
+		"multipart/mixed TEXT",
+		"multipart/alternative 1", "text/plain 1.1", "text/html 1.2",
+		"application/pdf 2",
+		"message/rfc822 3", "multipart/mixed 3.TEXT", "text/plain 3.1", "image/png 3.2",
+	}
+	if !slices.Equal(paths, want) {
+		t.Errorf("Walk() visited %q\nwant %q", paths, want)
+	}
+
+	pdf := b.Parts[1]
+	if pdf.Filename() != "résumé.pdf" || pdf.Disposition != "attachment" || pdf.Encoding != "base64" ||
+		pdf.Size != 5000 || pdf.ID != "<id1>" || pdf.Description != "Resume" ||
+		pdf.Location != "https://example.com/r.pdf" || !slices.Equal(pdf.Language, []string{"EN"}) {
+		t.Errorf("attachment = %+v", pdf)
+	}
+	if pdf.MIMESection() != "2.MIME" {
+		t.Errorf("MIMESection() = %q", pdf.MIMESection())
+	}
+	var attachments []string
+	for _, a := range b.Attachments() {
+		attachments = append(attachments, a.Path+" "+a.Filename())
+	}
+	if !slices.Equal(attachments, []string{"2 résumé.pdf", "3.2 a.png"}) {
+		t.Errorf("Attachments() = %q", attachments)
+	}
+
+	msg := b.Parts[2]
+	if msg.Envelope == nil || msg.Envelope.Subject != "Fwd" || msg.Lines != 30 || msg.Message.MIMESection() != "3.HEADER" {
+		t.Errorf("message/rfc822 part = %+v", msg)
+	}
+
+	for _, tt := range []struct {
+		subtypes []string
+		want     string
+	}{
+		{[]string{"html", "plain"}, "1.2"},
+		{[]string{"plain", "html"}, "1.1"},
+		{nil, "1.2"}, // the richer alternative
+		{[]string{"enriched"}, ""},
+	} {
+		got := ""
+		if p := b.BestText(tt.subtypes...); p != nil {
+			got = p.Path
+		}
+		if got != tt.want {
+			t.Errorf("BestText(%q) = %q, want %q", tt.subtypes, got, tt.want)
+		}
+	}
+}
+
+func TestParseBodyStructure_SinglePart(t *testing.T) {
+	t.Parallel()
+	b := parseBodyStructureString(t, `("TEXT" "PLAIN" ("CHARSET" "us-ascii") NIL NIL "7BIT" 5 1)`)
+	if b.Path != "1" || b.Section() != "1" || b.MIMESection() != "1.MIME" || b.Lines != 1 || b.Params["charset"] != "us-ascii" {
+		t.Errorf("single part = %+v", b)
+	}
+	if len(b.Attachments()) != 0 || b.BestText("plain") != b {
+		t.Error("a plain text message has its body as text and no attachments")
+	}
+
+	// A message with a single encapsulated part numbers its body 1.1
+	b = parseBodyStructureString(t, `("MESSAGE" "RFC822" NIL NIL NIL "7BIT" 50 NIL ("TEXT" "PLAIN" NIL NIL NIL "7BIT" 5 1) 3)`)
+	if b.Message == nil || b.Message.Section() != "1.1" {
+		t.Errorf("encapsulated part = %+v", b.Message)
+	}
+}
+
+func TestParseBodyStructure_RFC2231(t *testing.T) {
+	t.Parallel()
+	const want = "Отчёт.pdf"
+	for _, tt := range []struct {
+		name string
+		s    string
+	}{
+		{"extended", `("APPLICATION" "PDF" NIL NIL NIL "BASE64" 100 NIL ("ATTACHMENT" ("FILENAME" "Otchet.pdf" "FILENAME*" "utf-8''%D0%9E%D1%82%D1%87%D1%91%D1%82.pdf")) NIL)`},
+		{"continued", `("APPLICATION" "PDF" NIL NIL NIL "BASE64" 100 NIL ("ATTACHMENT" ("filename*0*" "utf-8'ru'%D0%9E%D1%82" "filename*1*" "%D1%87%D1%91%D1%82" "filename*2" ".pdf")) NIL)`},
+		{"name without disposition", `("APPLICATION" "PDF" ("NAME*" "utf-8''%D0%9E%D1%82%D1%87%D1%91%D1%82.pdf") NIL NIL "BASE64" 100 NIL NIL NIL)`},
+		{"other charset", `("APPLICATION" "PDF" ("name*0*" "koi8-r''%EF%D4%DE" "name*1*" "%A3%D4" "name*2" ".pdf") NIL NIL "BASE64" 100 NIL NIL NIL)`},
+	} {
+		b := parseBodyStructureString(t, tt.s)
+		if got := b.Filename(); got != want {
+			t.Errorf("%s: Filename() = %q, want %q", tt.name, got, want)
+		}
+		if !b.IsAttachment() {
+			t.Errorf("%s: IsAttachment() = false", tt.name)
+		}
+		for k := range b.Params {
+			if strings.Contains(k, "*") {
+				t.Errorf("%s: raw parameter %q kept", tt.name, k)
+			}
+		}
+	}
+
+	// Plain continuations are joined; a broken extended value keeps the
+	// plain one
+	params := decodeParams(map[string]string{"name*0": "long ", "name*1": "name.txt", "filename": "a.txt", "filename*": "utf-8''%zz", "title*1": "orphan"})
+	if params["name"] != "long name.txt" || params["filename"] != "a.txt" || len(params) != 2 {
+		t.Errorf("decodeParams() = %q", params)
+	}
+}
+
+func TestParseBodyStructure_TruncatedMessage(t *testing.T) {
+	t.Parallel()
+	envelope := `(NIL "Fwd" NIL NIL NIL NIL NIL NIL NIL NIL)`
+	body := `("TEXT" "PLAIN" NIL NIL NIL "7BIT" 5 1)`
+	b := parseBodyStructureString(t, `(("TEXT" "PLAIN" NIL NIL NIL "7BIT" 5 1)`+
+		`("MESSAGE" "RFC822" NIL NIL NIL "7BIT" 50)`+
+		`("MESSAGE" "RFC822" NIL NIL NIL "7BIT" 50 `+envelope+`)`+
+		`("MESSAGE" "RFC822" NIL NIL NIL "7BIT" 50 `+envelope+` `+body+`) "MIXED")`)
+	if len(b.Parts) != 4 {
+		t.Fatalf("parts = %d, want 4", len(b.Parts))
+	}
+	if p := b.Parts[1]; p.Envelope != nil || p.Message != nil || p.Lines != 0 {
+		t.Errorf("part without envelope = %+v", p)
+	}
+	if p := b.Parts[2]; p.Envelope == nil || p.Envelope.Subject != "Fwd" || p.Message != nil {
+		t.Errorf("part without body = %+v", p)
+	}
+	if p := b.Parts[3]; p.Message == nil || p.Message.Section() != "4.1" || p.Lines != 0 {
+		t.Errorf("part without line count = %+v", p)
+	}
+	var paths []string
+	b.Walk(func(p *BodyStructure) bool {
+		paths = append(paths, p.Path)
+		return true
+	})
+	if want := []string{"", "1", "2", "3", "4", "4.1"}; !slices.Equal(paths, want) {
+		t.Errorf("Walk() visited %q, want %q", paths, want)
+	}
+}
+
+func TestParseBodyStructure_Errors(t *testing.T) {
+	t.Parallel()
+	d := &Dialer{}
+	for _, s := range []string{`"TEXT"`, `("TEXT" "PLAIN")`, `(("TEXT" "PLAIN" NIL NIL NIL "7BIT" 5 1))`, `("TEXT" "PLAIN" NIL NIL NIL "7BIT" "five")`} {
+		tks, err := parseFetchTokens("BODYSTRUCTURE " + s)
+		if err != nil {
+			t.Fatalf("parseFetchTokens(%q) error = %v", s, err)
+		}
+		if _, err := d.ParseBodyStructure(tks[1]); err == nil {
+			t.Errorf("ParseBodyStructure(%s) succeeded", s)
+		}
+	}
+}
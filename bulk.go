@@ -0,0 +1,311 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FlagOp is how StoreFlags changes the flags of the messages in a set
+type FlagOp int
+
+const (
+	// FlagsAdd adds the flags to those already set (+FLAGS)
+	FlagsAdd FlagOp = iota
+	// FlagsRemove removes the flags, leaving the others set (-FLAGS)
+	FlagsRemove
+	// FlagsReplace replaces all the messages' flags with the given ones
+	// (FLAGS)
+	FlagsReplace
+)
+
+// item returns the STORE data item for op
+func (op FlagOp) item() (string, error) {
+	switch op {
+	case FlagsAdd:
+		return "+FLAGS", nil
+	case FlagsRemove:
+		return "-FLAGS", nil
+	case FlagsReplace:
+		return "FLAGS", nil
+	}
+	return "", fmt.Errorf("imap: unknown flag operation %d", op)
+}
+
+// StoreOptions controls how StoreFlags sends UID STORE
+type StoreOptions struct {
+	// Silent sends FLAGS.SILENT, so the server does not answer with the
+	// resulting flags of every message it changed
+	Silent bool
+	// UnchangedSince, if not zero, stores the flags only on messages whose
+	// mod-sequence is at most UnchangedSince (UNCHANGEDSINCE, RFC 7162), so
+	// that changes made by other clients since then are not overwritten. It
+	// requires CONDSTORE.
+	UnchangedSince uint64
+}
+
+// BulkResult is the outcome of an operation on a UIDSet, which is sent as one
+// command per chunk so that no command exceeds Config.MaxLineLength
+type BulkResult struct {
+	// Chunks holds the result of each command in the order they were sent
+	Chunks []ChunkResult
+}
+
+// ChunkResult is the outcome of one command of a bulk operation
+type ChunkResult struct {
+	// UIDs is the part of the set the command covered
+	UIDs UIDSet
+	// Err is the command's error, or nil if it completed with OK
+	Err error
+}
+
+// Succeeded returns the UIDs covered by the commands that completed with OK
+func (r *BulkResult) Succeeded() UIDSet {
+	var set UIDSet
+	for _, c := range r.Chunks {
+		if c.Err == nil {
+			set.AddSet(c.UIDs)
+		}
+	}
+	return set
+}
+
+// Failed returns the UIDs covered by the commands that failed
+func (r *BulkResult) Failed() UIDSet {
+	var set UIDSet
+	for _, c := range r.Chunks {
+		if c.Err != nil {
+			set.AddSet(c.UIDs)
+		}
+	}
+	return set
+}
+
+// StoreResult is the outcome of StoreFlags
+type StoreResult struct {
+	BulkResult
+	// Flags maps the UID of each message the server reported on to its flags
+	// after the store. Servers report the messages they changed, so it is
+	// usually empty when StoreOptions.Silent is set.
+	Flags map[int][]string
+	// Modified holds the UIDs that were left unchanged because they had been
+	// modified since StoreOptions.UnchangedSince
+	Modified UIDSet
+}
+
+// err returns the first chunk's error
+func (r *BulkResult) err() error {
+	for _, c := range r.Chunks {
+		if c.Err != nil {
+			return c.Err
+		}
+	}
+	return nil
+}
+
+// MoveEmails moves the messages in set to folder with one command per chunk.
+// Like MoveEmail, it uses UID MOVE when the server supports it and otherwise
+// UID COPY, UID STORE and UID EXPUNGE, which requires UIDPLUS; without either
+// extension an *UnsupportedError is returned and nothing is sent.
+//
+// A chunk the server refuses does not stop the others. The returned error is
+// the first chunk's error; the result reports which UIDs were moved. If the
+// folder is selected read-only, it is reselected read-write once for the whole
+// operation.
+func (d *Dialer) MoveEmails(set UIDSet, folder string) (*BulkResult, error) {
+	return d.MoveEmailsContext(context.Background(), set, folder)
+}
+
+// MoveEmailsContext is like MoveEmails but honors ctx
+func (d *Dialer) MoveEmailsContext(ctx context.Context, set UIDSet, folder string) (*BulkResult, error) {
+	move, err := d.HasCapabilityContext(ctx, "MOVE")
+	if err != nil {
+		return &BulkResult{}, err
+	}
+	if !move {
+		uidplus, err := d.HasCapabilityContext(ctx, "UIDPLUS")
+		if err != nil {
+			return &BulkResult{}, err
+		}
+		if !uidplus {
+			return &BulkResult{}, &UnsupportedError{Extension: "MOVE"}
+		}
+	}
+
+	mailbox := " " + d.quoteMailbox(folder)
+	// The emulation's longest command is UID STORE
+	overhead := len("UID MOVE ") + len(mailbox)
+	if !move {
+		overhead = max(len("UID COPY ")+len(mailbox), len(`UID STORE  +FLAGS.SILENT (\Deleted)`))
+	}
+	return d.bulk(ctx, set, overhead, func(uids string) error {
+		if move {
+			_, err := d.exec(ctx, "UID MOVE "+uids+mailbox, false, false, nil)
+			return err
+		}
+		return d.copyDeleteExpunge(ctx, uids, folder)
+	})
+}
+
+// CopyEmails copies the messages in set to folder with one command per chunk.
+// As with CopyEmail, a chunk is retried only if it never reached the server.
+// Partial failures are reported as for MoveEmails.
+func (d *Dialer) CopyEmails(set UIDSet, folder string) (*BulkResult, error) {
+	return d.CopyEmailsContext(context.Background(), set, folder)
+}
+
+// CopyEmailsContext is like CopyEmails but honors ctx
+func (d *Dialer) CopyEmailsContext(ctx context.Context, set UIDSet, folder string) (*BulkResult, error) {
+	mailbox := " " + d.quoteMailbox(folder)
+	return d.bulk(ctx, set, len("UID COPY ")+len(mailbox), func(uids string) error {
+		_, err := d.exec(ctx, "UID COPY "+uids+mailbox, false, false, nil)
+		return err
+	})
+}
+
+// StoreFlags changes the flags of the messages in set with one UID STORE per
+// chunk, e.g. StoreFlags(set, FlagsAdd, []string{`\Seen`}, StoreOptions{})
+// marks them all read. Flags are sent as given, so system flags need their
+// backslash. The result holds the flags the server reported for each message;
+// partial failures are reported as for MoveEmails. UnchangedSince without
+// CONDSTORE returns an *UnsupportedError and sends nothing.
+func (d *Dialer) StoreFlags(set UIDSet, op FlagOp, flags []string, opts StoreOptions) (*StoreResult, error) {
+	return d.StoreFlagsContext(context.Background(), set, op, flags, opts)
+}
+
+// StoreFlagsContext is like StoreFlags but honors ctx
+func (d *Dialer) StoreFlagsContext(ctx context.Context, set UIDSet, op FlagOp, flags []string, opts StoreOptions) (*StoreResult, error) {
+	res := &StoreResult{Flags: make(map[int][]string)}
+	item, err := op.item()
+	if err != nil {
+		return res, err
+	}
+	if opts.Silent {
+		item += ".SILENT"
+	}
+	data := " " + item + " (" + strings.Join(flags, " ") + ")"
+	if opts.UnchangedSince > 0 {
+		condstore, err := d.HasCapabilityContext(ctx, "CONDSTORE")
+		if err != nil {
+			return res, err
+		}
+		if !condstore {
+			return res, &UnsupportedError{Extension: "CONDSTORE"}
+		}
+		data = " (UNCHANGEDSINCE " + strconv.FormatUint(opts.UnchangedSince, 10) + ")" + data
+	}
+
+	processLine := func(line []byte) error {
+		if uid, got, ok := storeResponse(line); ok {
+			res.Flags[uid] = got
+		}
+		return nil
+	}
+	bulk, err := d.bulk(ctx, set, len("UID STORE ")+len(data), func(uids string) error {
+		d.lock()
+		defer d.unlock()
+		_, completion, err := d.execCompletionLocked(ctx, "UID STORE "+uids+data, false, false, -1, processLine)
+		if err != nil {
+			return err
+		}
+		if status := parseStatusError(completion); status.Code == "MODIFIED" {
+			modified, err := ParseUIDSet(status.Args)
+			if err != nil {
+				return err
+			}
+			res.Modified.AddSet(modified)
+		}
+		return nil
+	})
+	res.BulkResult = *bulk
+	return res, err
+}
+
+// storeResponse returns the UID and flags reported by an untagged FETCH
+// response to STORE. ok is false for other responses and for FETCH responses
+// without both.
+func storeResponse(line []byte) (uid int, flags []string, ok bool) {
+	_, rest, ok := fetchResponse(line)
+	if !ok {
+		return 0, nil, false
+	}
+	items, err := fetchItems(bytes.TrimSuffix(dropNl(rest), []byte(")")))
+	if err != nil {
+		return 0, nil, false
+	}
+	hasFlags := false
+	for _, item := range items {
+		switch {
+		case item.Value == nil:
+		case item.Name == "UID" && item.Value.Type == TNumber:
+			uid = item.Value.Num
+		case item.Name == "FLAGS" && item.Value.Type == TContainer:
+			hasFlags = true
+			flags = make([]string, 0, len(item.Value.Tokens))
+			for _, t := range item.Value.Tokens {
+				flags = append(flags, t.Str)
+			}
+		}
+	}
+	return uid, flags, uid != 0 && hasFlags
+}
+
+// bulk splits set into chunks that fit in a command of overhead bytes plus the
+// chunk and calls run with each. The folder is selected read-write around the
+// whole operation if it is read-only, and made read-only again however the
+// operation ends. Once a chunk fails because the
+// connection was lost or ctx ended, the remaining chunks fail with the same
+// error without being sent.
+func (d *Dialer) bulk(ctx context.Context, set UIDSet, overhead int, run func(uids string) error) (*BulkResult, error) {
+	res := &BulkResult{}
+	if set.IsEmpty() {
+		return res, nil
+	}
+
+	readOnlyState := d.ReadOnly
+	if readOnlyState {
+		if err := d.SelectFolderContext(ctx, d.Folder); err != nil {
+			return res, err
+		}
+	}
+	var fatal error
+	for _, chunk := range d.splitUIDs(set, overhead) {
+		c := ChunkResult{UIDs: chunk, Err: fatal}
+		if fatal == nil {
+			c.Err = run(chunk.String())
+			if c.Err != nil {
+				switch ClassifyError(c.Err) {
+				case ErrorTransport, ErrorCanceled:
+					fatal = c.Err
+				}
+			}
+		}
+		res.Chunks = append(res.Chunks, c)
+	}
+	err := res.err()
+	if readOnlyState {
+		if e := d.restoreReadOnly(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	return res, err
+}
+
+// restoreReadOnly reopens the selected folder read-only after bulk selected
+// it read-write. If the connection was lost, the folder is only marked
+// read-only, so that the reconnect reopens it with EXAMINE.
+func (d *Dialer) restoreReadOnly(ctx context.Context) error {
+	d.lock()
+	defer d.unlock()
+	if !d.Connected {
+		d.ReadOnly = true
+		return nil
+	}
+	err := d.selectLocked(ctx, d.Folder, true, -1)
+	if err != nil && !d.Connected {
+		d.ReadOnly = true
+	}
+	return err
+}
@@ -0,0 +1,181 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// uidCommands returns the UID commands server received
+func uidCommands(server *mockIMAPServer) []string {
+	var got []string
+	for _, c := range server.Commands() {
+		if strings.HasPrefix(c, "UID ") {
+			got = append(got, c)
+		}
+	}
+	return got
+}
+
+func TestMoveEmails_PartialFailure(t *testing.T) {
+	d, server := dialWithCapabilities(t, "IMAP4rev1 MOVE")
+	d.config.MaxLineLength = 50
+	server.failLines[`UID MOVE 9,11,13 "Archive"`] = true
+
+	var set UIDSet
+	for uid := 1; uid <= 15; uid += 2 {
+		set.AddNum(uid)
+	}
+	res, err := d.MoveEmails(set, "Archive")
+	var imapErr *Error
+	if !errors.As(err, &imapErr) || imapErr.Status != "NO" {
+		t.Fatalf("MoveEmails() error = %v, want the failed chunk's NO", err)
+	}
+
+	want := []string{`UID MOVE 1,3,5,7 "Archive"`, `UID MOVE 9,11,13 "Archive"`, `UID MOVE 15 "Archive"`}
+	if got := uidCommands(server); !slices.Equal(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+	if len(res.Chunks) != 3 || res.Chunks[0].Err != nil || res.Chunks[1].Err == nil || res.Chunks[2].Err != nil {
+		t.Fatalf("Chunks = %v, want only the second to fail", res.Chunks)
+	}
+	if got := res.Succeeded().String(); got != "1,3,5,7,15" {
+		t.Errorf("Succeeded() = %s", got)
+	}
+	if got := res.Failed().String(); got != "9,11,13" {
+		t.Errorf("Failed() = %s", got)
+	}
+}
+
+func TestMoveEmails_Emulated(t *testing.T) {
+	d, server := dialWithCapabilities(t, "IMAP4rev1 UIDPLUS")
+	if _, err := d.MoveEmails(UIDSetNum(1, 2, 3), "Archive"); err != nil {
+		t.Fatalf("MoveEmails() error = %v", err)
+	}
+	want := []string{`UID COPY 1:3 "Archive"`, `UID STORE 1:3 +FLAGS.SILENT (\Deleted)`, "UID EXPUNGE 1:3"}
+	if got := uidCommands(server); !slices.Equal(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+
+	d, server = dialWithCapabilities(t, "IMAP4rev1")
+	if _, err := d.MoveEmails(UIDSetNum(1), "Archive"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("MoveEmails() error = %v, want ErrUnsupported", err)
+	}
+	if got := uidCommands(server); len(got) != 0 {
+		t.Errorf("sent %q without MOVE or UIDPLUS", got)
+	}
+}
+
+func TestStoreFlags_ReadOnly(t *testing.T) {
+	d, server := setupTestDialer(t)
+	if err := d.ExamineFolder("INBOX"); err != nil {
+		t.Fatalf("ExamineFolder() error = %v", err)
+	}
+	set := UIDSetNum(1, 2, 3, 5)
+	if _, err := d.StoreFlags(set, FlagsReplace, []string{`\Seen`, "$Work"}, StoreOptions{Silent: true}); err != nil {
+		t.Fatalf("StoreFlags() error = %v", err)
+	}
+	if _, err := d.StoreFlags(set, FlagsRemove, []string{`\Flagged`}, StoreOptions{}); err != nil {
+		t.Fatalf("StoreFlags() error = %v", err)
+	}
+	if _, err := d.CopyEmails(set, "Archive"); err != nil {
+		t.Fatalf("CopyEmails() error = %v", err)
+	}
+
+	cmds := server.Commands()
+	want := []string{
+		`SELECT "INBOX"`, `UID STORE 1:3,5 FLAGS.SILENT (\Seen $Work)`, `EXAMINE "INBOX"`,
+		`SELECT "INBOX"`, `UID STORE 1:3,5 -FLAGS (\Flagged)`, `EXAMINE "INBOX"`,
+		`SELECT "INBOX"`, `UID COPY 1:3,5 "Archive"`, `EXAMINE "INBOX"`,
+	}
+	if got := cmds[len(cmds)-len(want):]; !slices.Equal(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+	if !d.ReadOnly {
+		t.Error("the folder should be read-only again")
+	}
+}
+
+func TestStoreFlags_ReturnsFlags(t *testing.T) {
+	d, server := setupTestDialer(t)
+	server.responses[`UID STORE 1:2 FLAGS (\Seen $Work)`] = "" +
+		"* 1 FETCH (UID 1 FLAGS (\\Seen $Work))\r\n" +
+		"* 2 FETCH (FLAGS (\\Seen $Work \\Recent) UID 2)\r\n" +
+		"* 3 EXISTS\r\n"
+
+	res, err := d.StoreFlags(UIDSetNum(1, 2), FlagsReplace, []string{`\Seen`, "$Work"}, StoreOptions{})
+	if err != nil {
+		t.Fatalf("StoreFlags() error = %v", err)
+	}
+	want := map[int][]string{1: {`\Seen`, "$Work"}, 2: {`\Seen`, "$Work", `\Recent`}}
+	if fmt.Sprint(res.Flags) != fmt.Sprint(want) {
+		t.Errorf("Flags = %v, want %v", res.Flags, want)
+	}
+	if !res.Modified.IsEmpty() {
+		t.Errorf("Modified = %s, want none", res.Modified)
+	}
+}
+
+func TestStoreFlags_UnchangedSince(t *testing.T) {
+	d, server := dialWithCapabilities(t, "IMAP4rev1 CONDSTORE")
+	server.okCodes["UID"] = "MODIFIED 2"
+	server.responses[`UID STORE 1:3 (UNCHANGEDSINCE 320) +FLAGS.SILENT (\Seen)`] = "" +
+		"* 1 FETCH (UID 1 MODSEQ (321) FLAGS (\\Seen))\r\n" +
+		"* 3 FETCH (UID 3 MODSEQ (322) FLAGS (\\Seen \\Answered))\r\n"
+
+	opts := StoreOptions{Silent: true, UnchangedSince: 320}
+	res, err := d.StoreFlags(UIDSetNum(1, 2, 3), FlagsAdd, []string{`\Seen`}, opts)
+	if err != nil {
+		t.Fatalf("StoreFlags() error = %v", err)
+	}
+	if got := res.Modified.String(); got != "2" {
+		t.Errorf("Modified = %q, want 2", got)
+	}
+	if len(res.Flags) != 2 || len(res.Flags[3]) != 2 {
+		t.Errorf("Flags = %v", res.Flags)
+	}
+
+	d, server = dialWithCapabilities(t, "IMAP4rev1")
+	if _, err := d.StoreFlags(UIDSetNum(1), FlagsAdd, []string{`\Seen`}, opts); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("StoreFlags() error = %v, want ErrUnsupported", err)
+	}
+	if got := uidCommands(server); len(got) != 0 {
+		t.Errorf("sent %q without CONDSTORE", got)
+	}
+}
+
+func TestSetFlags_SeparateCommands(t *testing.T) {
+	d, server := setupTestDialer(t)
+	err := d.SetFlags(7, Flags{Seen: FlagAdd, Flagged: FlagAdd, Answered: FlagRemove})
+	if err != nil {
+		t.Fatalf("SetFlags() error = %v", err)
+	}
+	want := []string{`UID STORE 7 +FLAGS (\Seen \Flagged)`, `UID STORE 7 -FLAGS (\Answered)`}
+	if got := uidCommands(server); !slices.Equal(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestStoreFlags_ReadOnlyAfterLostConnection(t *testing.T) {
+	d, server := dialWithCapabilities(t, "", withPolicy(&recordingPolicy{max: 0}))
+	if err := d.ExamineFolder("INBOX"); err != nil {
+		t.Fatalf("ExamineFolder() error = %v", err)
+	}
+	server.hangCommands["UID"] = true
+	if _, err := d.StoreFlags(UIDSetNum(1), FlagsAdd, []string{`\Seen`}, StoreOptions{}); ClassifyError(err) != ErrorTransport {
+		t.Fatalf("StoreFlags() error = %v, want a transport error", err)
+	}
+	if !d.ReadOnly {
+		t.Error("the folder should be read-only again")
+	}
+
+	if err := d.Reconnect(); err != nil {
+		t.Fatalf("Reconnect() error = %v", err)
+	}
+	cmds := server.Commands()
+	if got := cmds[len(cmds)-1]; got != `EXAMINE "INBOX"` {
+		t.Errorf("reconnect reopened the folder with %q, want EXAMINE", got)
+	}
+}
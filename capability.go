@@ -0,0 +1,187 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// UnsupportedError is returned when an operation needs an IMAP extension the
+// server does not advertise and no fallback is available. It matches
+// errors.ErrUnsupported with errors.Is.
+type UnsupportedError struct {
+	// Extension is the capability name that is missing, e.g. "MOVE"
+	Extension string
+}
+
+func (e *UnsupportedError) Error() string {
+	return "imap: server does not support " + e.Extension
+}
+
+// Is reports whether target is errors.ErrUnsupported
+func (e *UnsupportedError) Is(target error) bool {
+	return target == errors.ErrUnsupported
+}
+
+// Capabilities returns the capabilities advertised by the server, such as
+// "IMAP4rev1", "IDLE" or "AUTH=XOAUTH2".
+//
+// The list is cached from the greeting, the response codes of STARTTLS and
+// authentication, and any CAPABILITY response. The cache is discarded when
+// STARTTLS or authentication completes and on Reconnect; if nothing is
+// cached a CAPABILITY command is sent.
+func (d *Dialer) Capabilities() ([]string, error) {
+	return d.CapabilitiesContext(context.Background())
+}
+
+// CapabilitiesContext is like Capabilities but honors ctx
+func (d *Dialer) CapabilitiesContext(ctx context.Context) ([]string, error) {
+	d.lock()
+	defer d.unlock()
+	return d.capabilitiesLocked(ctx)
+}
+
+// capabilitiesLocked is CapabilitiesContext for callers holding the
+// connection lock
+func (d *Dialer) capabilitiesLocked(ctx context.Context) ([]string, error) {
+	if d.caps == nil {
+		if _, err := d.execLocked(ctx, "CAPABILITY", false, true, -1, nil); err != nil {
+			return nil, err
+		}
+		if d.caps == nil {
+			d.caps = []string{}
+		}
+	}
+	return slices.Clone(d.caps), nil
+}
+
+// HasCapability reports whether the server advertises the named capability.
+// Names are compared case-insensitively.
+func (d *Dialer) HasCapability(name string) (bool, error) {
+	return d.HasCapabilityContext(context.Background(), name)
+}
+
+// HasCapabilityContext is like HasCapability but honors ctx
+func (d *Dialer) HasCapabilityContext(ctx context.Context, name string) (bool, error) {
+	d.lock()
+	defer d.unlock()
+	return d.hasCapabilityLocked(ctx, name)
+}
+
+// hasCapabilityLocked is HasCapabilityContext for callers holding the
+// connection lock
+func (d *Dialer) hasCapabilityLocked(ctx context.Context, name string) (bool, error) {
+	caps, err := d.capabilitiesLocked(ctx)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(caps, func(c string) bool {
+		return strings.EqualFold(c, name)
+	}), nil
+}
+
+// requireCapability returns an *UnsupportedError if the server does not
+// advertise name.
+func (d *Dialer) requireCapability(ctx context.Context, name string) error {
+	ok, err := d.HasCapabilityContext(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &UnsupportedError{Extension: name}
+	}
+	return nil
+}
+
+// captureCapabilities updates the cached capability list if line carries
+// one, and the enabled extensions if it is an ENABLED response
+func (d *Dialer) captureCapabilities(line []byte) {
+	if caps, ok := parseCapabilityLine(line); ok {
+		d.caps = caps
+	}
+	if exts, ok := parseEnabledLine(line); ok {
+		for _, ext := range exts {
+			if !slices.ContainsFunc(d.enabled, func(e string) bool { return strings.EqualFold(e, ext) }) {
+				d.enabled = append(d.enabled, ext)
+			}
+			if strings.EqualFold(ext, "UTF8=ACCEPT") || strings.EqualFold(ext, "IMAP4rev2") {
+				d.utf8Mailboxes.Store(true)
+			}
+		}
+	}
+}
+
+// Enable turns on extensions with ENABLE (RFC 5161), e.g. "UTF8=ACCEPT" or
+// "CONDSTORE", and returns those the server enabled. The server must
+// advertise ENABLE, and Enable must be called before a folder is selected.
+// Enabled extensions are enabled again by Reconnect and Clone.
+//
+// Once UTF8=ACCEPT (RFC 6855) is enabled, folder names are sent and returned
+// as UTF-8 instead of modified UTF-7.
+func (d *Dialer) Enable(extensions ...string) ([]string, error) {
+	return d.EnableContext(context.Background(), extensions...)
+}
+
+// EnableContext is like Enable but honors ctx
+func (d *Dialer) EnableContext(ctx context.Context, extensions ...string) ([]string, error) {
+	if len(extensions) == 0 {
+		return nil, nil
+	}
+	if err := d.requireCapability(ctx, "ENABLE"); err != nil {
+		return nil, err
+	}
+	var enabled []string
+	_, err := d.exec(ctx, "ENABLE "+strings.Join(extensions, " "), false, true, func(line []byte) error {
+		if exts, ok := parseEnabledLine(line); ok {
+			enabled = append(enabled, exts...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("imap enable: %w", err)
+	}
+	return enabled, nil
+}
+
+// parseCapabilityLine extracts the capability list from an untagged
+// CAPABILITY response or from a status response (greeting, tagged OK) with a
+// [CAPABILITY ...] response code.
+func parseCapabilityLine(line []byte) ([]string, bool) {
+	_, rest, ok := bytes.Cut(line, []byte(" "))
+	if !ok {
+		return nil, false
+	}
+
+	switch {
+	case hasPrefixFold(rest, "CAPABILITY "):
+		return strings.Fields(string(rest[len("CAPABILITY "):])), true
+	case hasPrefixFold(rest, "OK [CAPABILITY "), hasPrefixFold(rest, "PREAUTH [CAPABILITY "):
+		rest = rest[bytes.IndexByte(rest, '[')+len("[CAPABILITY "):]
+		end := bytes.IndexByte(rest, ']')
+		if end < 0 {
+			return nil, false
+		}
+		return strings.Fields(string(rest[:end])), true
+	}
+	return nil, false
+}
+
+// parseEnabledLine extracts the extensions from an untagged ENABLED response
+func parseEnabledLine(line []byte) ([]string, bool) {
+	rest, ok := bytes.CutPrefix(line, []byte("* "))
+	if !ok || !hasPrefixFold(rest, "ENABLED") {
+		return nil, false
+	}
+	rest = rest[len("ENABLED"):]
+	if len(rest) > 0 && rest[0] != ' ' && rest[0] != '\r' && rest[0] != '\n' {
+		return nil, false
+	}
+	return strings.Fields(string(rest)), true
+}
+
+func hasPrefixFold(b []byte, prefix string) bool {
+	return len(b) >= len(prefix) && strings.EqualFold(string(b[:len(prefix)]), prefix)
+}
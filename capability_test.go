@@ -0,0 +1,259 @@
+package imap
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseCapabilityLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want []string
+		ok   bool
+	}{
+		{"untagged", "* CAPABILITY IMAP4rev1 IDLE MOVE\r\n", []string{"IMAP4rev1", "IDLE", "MOVE"}, true},
+		{"lowercase", "* capability imap4rev1 idle\r\n", []string{"imap4rev1", "idle"}, true},
+		{"greeting code", "* OK [CAPABILITY IMAP4rev1 STARTTLS] Ready\r\n", []string{"IMAP4rev1", "STARTTLS"}, true},
+		{"preauth code", "* PREAUTH [CAPABILITY IMAP4rev1] Logged in\r\n", []string{"IMAP4rev1"}, true},
+		{"tagged code", "A001 OK [CAPABILITY IMAP4rev1 UIDPLUS] Logged in\r\n", []string{"IMAP4rev1", "UIDPLUS"}, true},
+		{"other code", "* OK [UIDVALIDITY 3] UIDs valid\r\n", nil, false},
+		{"unterminated code", "* OK [CAPABILITY IMAP4rev1\r\n", nil, false},
+		{"search", "* SEARCH 1 2 3\r\n", nil, false},
+		{"no space", "*\r\n", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseCapabilityLine([]byte(tt.line))
+			if ok != tt.ok || !slices.Equal(got, tt.want) {
+				t.Errorf("parseCapabilityLine(%q) = %v, %v; want %v, %v", tt.line, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestUnsupportedError(t *testing.T) {
+	err := error(&UnsupportedError{Extension: "MOVE"})
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Error("UnsupportedError should match errors.ErrUnsupported")
+	}
+	if err.Error() != "imap: server does not support MOVE" {
+		t.Errorf("Error() = %q", err.Error())
+	}
+}
+
+// dialWithCapabilities dials a mock server advertising caps, or its default
+// capabilities if caps is empty, with the test config changed by opts
+func dialWithCapabilities(t *testing.T, caps string, opts ...func(*Config)) (*Dialer, *mockIMAPServer) {
+	t.Helper()
+	server, err := newMockIMAPServer("user", "pass")
+	if err != nil {
+		t.Fatalf("failed to create mock server: %v", err)
+	}
+	t.Cleanup(server.Close)
+	server.capabilities = caps
+
+	cfg := newTestConfig(server)
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	d, err := Dial(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d, server
+}
+
+func countCommands(server *mockIMAPServer, prefix string) int {
+	n := 0
+	for _, c := range server.Commands() {
+		if strings.HasPrefix(c, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestCapabilities_Cached(t *testing.T) {
+	d, server := dialWithCapabilities(t, "IMAP4rev1 IDLE MOVE")
+
+	caps, err := d.Capabilities()
+	if err != nil {
+		t.Fatalf("Capabilities() error = %v", err)
+	}
+	if !slices.Equal(caps, []string{"IMAP4rev1", "IDLE", "MOVE"}) {
+		t.Errorf("Capabilities() = %v", caps)
+	}
+	for _, name := range []string{"idle", "MOVE"} {
+		if ok, err := d.HasCapability(name); err != nil || !ok {
+			t.Errorf("HasCapability(%q) = %v, %v; want true", name, ok, err)
+		}
+	}
+	if ok, _ := d.HasCapability("ESEARCH"); ok {
+		t.Error("HasCapability(ESEARCH) = true, want false")
+	}
+	if n := countCommands(server, "CAPABILITY"); n != 1 {
+		t.Errorf("CAPABILITY sent %d times, want 1", n)
+	}
+
+	if err := d.Reconnect(); err != nil {
+		t.Fatalf("Reconnect() error = %v", err)
+	}
+	if _, err := d.Capabilities(); err != nil {
+		t.Fatalf("Capabilities() after Reconnect error = %v", err)
+	}
+	if n := countCommands(server, "CAPABILITY"); n != 2 {
+		t.Errorf("CAPABILITY sent %d times after Reconnect, want 2", n)
+	}
+}
+
+func TestCapabilities_RefreshedAfterSTARTTLS(t *testing.T) {
+	t.Parallel()
+	server, err := newPlaintextMockIMAPServer("user", "pass", true)
+	if err != nil {
+		t.Fatalf("failed to create mock server: %v", err)
+	}
+	defer server.Close()
+
+	cfg := newTestConfig(server)
+	cfg.Transport = TransportSTARTTLS
+	d, err := Dial(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer d.Close()
+
+	if ok, _ := d.HasCapability("STARTTLS"); ok {
+		t.Error("capabilities from before STARTTLS were not discarded")
+	}
+	if ok, _ := d.HasCapability("AUTHENTICATE"); !ok {
+		t.Error("capabilities were not refreshed after STARTTLS")
+	}
+}
+
+func TestMoveEmail_CapabilityGating(t *testing.T) {
+	t.Run("MOVE", func(t *testing.T) {
+		d, server := dialWithCapabilities(t, "IMAP4rev1 MOVE")
+		if err := d.MoveEmail(42, "Archive"); err != nil {
+			t.Fatalf("MoveEmail() error = %v", err)
+		}
+		if n := countCommands(server, `UID MOVE 42 "Archive"`); n != 1 {
+			t.Errorf("UID MOVE sent %d times, want 1", n)
+		}
+	})
+
+	t.Run("UIDPLUS fallback", func(t *testing.T) {
+		d, server := dialWithCapabilities(t, "IMAP4rev1 UIDPLUS")
+		if err := d.MoveEmail(42, "Archive"); err != nil {
+			t.Fatalf("MoveEmail() error = %v", err)
+		}
+		var got []string
+		for _, c := range server.Commands() {
+			if strings.HasPrefix(c, "UID ") {
+				got = append(got, c)
+			}
+		}
+		want := []string{`UID COPY 42 "Archive"`, `UID STORE 42 +FLAGS.SILENT (\Deleted)`, "UID EXPUNGE 42"}
+		if !slices.Equal(got, want) {
+			t.Errorf("commands = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		d, server := dialWithCapabilities(t, "IMAP4rev1")
+		err := d.MoveEmail(42, "Archive")
+		if !errors.Is(err, errors.ErrUnsupported) {
+			t.Fatalf("MoveEmail() error = %v, want ErrUnsupported", err)
+		}
+		if n := countCommands(server, "UID "); n != 0 {
+			t.Errorf("sent %d UID commands, want 0", n)
+		}
+	})
+}
+
+func TestGetMaxUID_WithoutESEARCH(t *testing.T) {
+	d, server := dialWithCapabilities(t, "IMAP4rev1")
+	server.responses["UID SEARCH ALL"] = "* SEARCH 3 17 9\r\n"
+
+	uid, err := d.GetMaxUID()
+	if err != nil {
+		t.Fatalf("GetMaxUID() error = %v", err)
+	}
+	if uid != 17 {
+		t.Errorf("GetMaxUID() = %d, want 17", uid)
+	}
+	if n := countCommands(server, "UID SEARCH RETURN"); n != 0 {
+		t.Errorf("ESEARCH sent %d times, want 0", n)
+	}
+}
+
+func TestStartIdle_Unsupported(t *testing.T) {
+	d, _ := dialWithCapabilities(t, "IMAP4rev1")
+	err := d.StartIdle(&IdleHandler{})
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("StartIdle() error = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestParseEnabledLine(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want []string
+		ok   bool
+	}{
+		{"* ENABLED UTF8=ACCEPT CONDSTORE\r\n", []string{"UTF8=ACCEPT", "CONDSTORE"}, true},
+		{"* enabled utf8=accept\r\n", []string{"utf8=accept"}, true},
+		{"* ENABLED\r\n", nil, true},
+		{"* ENABLEDX\r\n", nil, false},
+		{"A001 OK ENABLED\r\n", nil, false},
+		{"* CAPABILITY ENABLE\r\n", nil, false},
+	} {
+		got, ok := parseEnabledLine([]byte(tt.line))
+		if ok != tt.ok || !slices.Equal(got, tt.want) {
+			t.Errorf("parseEnabledLine(%q) = %v, %v; want %v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestEnable_UTF8Accept(t *testing.T) {
+	d, server := dialWithCapabilities(t, "IMAP4rev1 ENABLE UTF8=ACCEPT")
+	server.responses["ENABLE UTF8=ACCEPT"] = "* ENABLED UTF8=ACCEPT\r\n"
+
+	if err := d.CreateFolder("Корзина"); err != nil {
+		t.Fatalf("CreateFolder() error = %v", err)
+	}
+	enabled, err := d.Enable("UTF8=ACCEPT")
+	if err != nil || !slices.Equal(enabled, []string{"UTF8=ACCEPT"}) {
+		t.Fatalf("Enable() = %v, %v", enabled, err)
+	}
+	if err := d.SelectFolder("Корзина"); err != nil {
+		t.Fatalf("SelectFolder() error = %v", err)
+	}
+	if err := d.Reconnect(); err != nil {
+		t.Fatalf("Reconnect() error = %v", err)
+	}
+
+	if n := countCommands(server, `CREATE "&BBoEPgRABDcEOAQ9BDA-"`); n != 1 {
+		t.Errorf("CREATE before ENABLE not in modified UTF-7: %q", server.Commands())
+	}
+	if n := countCommands(server, "ENABLE UTF8=ACCEPT"); n != 2 {
+		t.Errorf("ENABLE sent %d times, want 2 (once more on Reconnect)", n)
+	}
+	if n := countCommands(server, `SELECT "Корзина"`); n != 2 {
+		t.Errorf("SELECT after ENABLE not in UTF-8: %q", server.Commands())
+	}
+}
+
+func TestEnable_Unsupported(t *testing.T) {
+	d, server := dialWithCapabilities(t, "IMAP4rev1")
+	if _, err := d.Enable("UTF8=ACCEPT"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("Enable() error = %v, want ErrUnsupported", err)
+	}
+	if n := countCommands(server, "ENABLE"); n != 0 {
+		t.Errorf("ENABLE sent %d times, want 0", n)
+	}
+}
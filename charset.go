@@ -0,0 +1,177 @@
+package imap
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// CharsetDecoder returns a reader that converts input from some charset to
+// UTF-8
+type CharsetDecoder func(input io.Reader) io.Reader
+
+var (
+	charsetMu sync.RWMutex
+	// charsets holds the decoders added with RegisterCharset
+	charsets = map[string]CharsetDecoder{}
+)
+
+// charsetAliases maps charset names found in mail that the WHATWG and IANA
+// tables lack, or map to a narrower charset, to the encoding to use
+var charsetAliases = map[string]encoding.Encoding{
+	"ks_c_5601-1987": mustLookup("euc-kr"),
+	"ks_c_5601":      mustLookup("euc-kr"),
+	"ksc5601":        mustLookup("euc-kr"),
+	"gb2312":         mustLookup("gb18030"),
+	"gbk":            mustLookup("gb18030"),
+	"x-gbk":          mustLookup("gb18030"),
+	"cp936":          mustLookup("gb18030"),
+	"x-mac-roman":    charmap.Macintosh,
+	"mac":            charmap.Macintosh,
+	"x-mac-cyrillic": charmap.MacintoshCyrillic,
+	"cp437":          charmap.CodePage437,
+	"ibm437":         charmap.CodePage437,
+	"cp850":          charmap.CodePage850,
+	"ibm850":         charmap.CodePage850,
+	"cp852":          charmap.CodePage852,
+	"cp858":          charmap.CodePage858,
+	"latin-1":        charmap.Windows1252,
+}
+
+// mustLookup returns the WHATWG encoding called label
+func mustLookup(label string) encoding.Encoding {
+	e, _ := charset.Lookup(label)
+	if e == nil {
+		panic("imap: unknown charset " + label)
+	}
+	return e
+}
+
+// RegisterCharset makes CharsetReader use dec for the charset called label,
+// compared without regard to case. Registered charsets take precedence over
+// the built-in ones, so a decoder may also replace one. It is safe to call
+// concurrently with decoding.
+//
+// Example:
+//
+//	imap.RegisterCharset("x-mac-greek", func(r io.Reader) io.Reader {
+//	    return macGreek.NewDecoder().Reader(r)
+//	})
+func RegisterCharset(label string, dec CharsetDecoder) {
+	charsetMu.Lock()
+	defer charsetMu.Unlock()
+	charsets[normalizeCharset(label)] = dec
+}
+
+// normalizeCharset returns label in lower case, without quotes, white space,
+// an RFC 2231 language suffix ("utf-8*en") or a stray "charset=" prefix
+func normalizeCharset(label string) string {
+	label = strings.ToLower(strings.Trim(label, " \t\"'"))
+	label, _, _ = strings.Cut(label, "*")
+	return strings.TrimPrefix(label, "charset=")
+}
+
+// CharsetReader returns a reader that converts input from the charset called
+// label to UTF-8. It is used for encoded words in headers and envelopes, file
+// names and text parts, and fits mime.WordDecoder.CharsetReader.
+//
+// label is looked up among the charsets added with RegisterCharset, then
+// common aliases such as "ks_c_5601-1987", "x-mac-roman" and "utf-7", then
+// the WHATWG and IANA names. Text in an unknown charset is kept if it is
+// valid UTF-8 and read as Latin-1 (Windows-1252) otherwise, so it never
+// returns an error. Bytes that are invalid in a known charset, including
+// UTF-8, are replaced with U+FFFD.
+func CharsetReader(label string, input io.Reader) (io.Reader, error) {
+	return lookupCharset(label)(input), nil
+}
+
+// lookupCharset returns the decoder for label, or the fallback decoder
+func lookupCharset(label string) CharsetDecoder {
+	label = normalizeCharset(label)
+	charsetMu.RLock()
+	dec := charsets[label]
+	charsetMu.RUnlock()
+	if dec != nil {
+		return dec
+	}
+
+	switch label {
+	case "utf-7", "utf7", "unicode-1-1-utf-7", "csunicode11utf7":
+		return decodeUTF7Reader
+	case "utf-8", "utf8":
+		return decodeUTF8Reader
+	case "", "us-ascii", "ascii":
+		// Undeclared 8-bit text is common
+		return fallbackDecoder
+	}
+	e := charsetAliases[label]
+	if e == nil {
+		e, _ = charset.Lookup(label)
+	}
+	if e == nil {
+		e, _ = ianaindex.IANA.Encoding(label)
+	}
+	if e == nil {
+		return fallbackDecoder
+	}
+	return func(input io.Reader) io.Reader {
+		return e.NewDecoder().Reader(input)
+	}
+}
+
+// decodeUTF7Reader decodes UTF-7, falling back on the input as is
+func decodeUTF7Reader(input io.Reader) io.Reader {
+	b, err := io.ReadAll(input)
+	if err != nil {
+		return io.MultiReader(bytes.NewReader(b), errReader{err})
+	}
+	s, err := decodeUTF7(string(b))
+	if err != nil {
+		return fallbackDecoder(bytes.NewReader(b))
+	}
+	return strings.NewReader(s)
+}
+
+// decodeUTF8Reader passes UTF-8 through with invalid bytes replaced
+func decodeUTF8Reader(input io.Reader) io.Reader {
+	b, err := io.ReadAll(input)
+	if err != nil {
+		return io.MultiReader(bytes.NewReader(b), errReader{err})
+	}
+	return strings.NewReader(strings.ToValidUTF8(string(b), "\uFFFD"))
+}
+
+// fallbackDecoder passes valid UTF-8 through and reads anything else as
+// Windows-1252, the superset of Latin-1 that mail in an undeclared or
+// unknown charset is most often in
+func fallbackDecoder(input io.Reader) io.Reader {
+	b, err := io.ReadAll(input)
+	switch {
+	case err != nil:
+		return io.MultiReader(bytes.NewReader(b), errReader{err})
+	case utf8.Valid(b):
+		return bytes.NewReader(b)
+	}
+	return charmap.Windows1252.NewDecoder().Reader(bytes.NewReader(b))
+}
+
+// errReader is an io.Reader that fails with err
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+// decodeCharset converts b from the charset called label to UTF-8
+func decodeCharset(b []byte, label string) string {
+	utf, err := io.ReadAll(lookupCharset(label)(bytes.NewReader(b)))
+	if err != nil {
+		return string(b)
+	}
+	return string(utf)
+}
@@ -0,0 +1,96 @@
+package imap
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestCharsetReader(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		label string
+		in    string
+		want  string
+	}{
+		{"ks_c_5601-1987", "\xc7\xd1\xb1\xdb", "한글"},
+		{"GB2312", "\xd6\xd0\xce\xc4", "中文"},
+		{"x-mac-roman", "caf\x8e", "café"},
+		{`"ISO-8859-1"`, "caf\xe9", "café"},
+		{"windows-1251", "\xcf\xf0\xe8", "При"},
+		{"utf-8*en", "café", "café"},
+		{"UTF-8", "caf\xe9", "caf�"},
+		{"utf-7", "Hi +AGEAYgBj-!", "Hi abc!"},
+		{"UTF-7", "1 +- 1 = 2", "1 + 1 = 2"},
+		{"utf-7", "+2D3eAA-", "😀"},
+		{"x-unknown", "café", "café"},
+		{"x-unknown", "caf\xe9", "café"},
+		{"", "caf\xe9", "café"},
+	} {
+		r, err := CharsetReader(tt.label, strings.NewReader(tt.in))
+		if err != nil {
+			t.Errorf("CharsetReader(%q) error = %v", tt.label, err)
+			continue
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("CharsetReader(%q, %q) = %q, %v, want %q", tt.label, tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestRegisterCharset(t *testing.T) {
+	t.Parallel()
+	RegisterCharset("X-Test-Greek", func(r io.Reader) io.Reader {
+		return charmap.ISO8859_7.NewDecoder().Reader(r)
+	})
+	if got := decodeCharset([]byte("\xe1\xe2\xe3"), "x-test-greek"); got != "αβγ" {
+		t.Errorf("registered charset = %q", got)
+	}
+
+	h := ParseHeader([]byte("Subject: =?x-unknown?Q?caf=E9?=\r\nX-Greek: =?X-TEST-GREEK?Q?=E1=E2=E3?=\r\n\r\n"))
+	if got := h.Get("Subject"); got != "café" {
+		t.Errorf("Subject = %q, want %q", got, "café")
+	}
+	if got := h.Get("X-Greek"); got != "αβγ" {
+		t.Errorf("X-Greek = %q, want %q", got, "αβγ")
+	}
+}
+
+func TestParseEmailBody_UnknownCharset(t *testing.T) {
+	t.Parallel()
+	RegisterCharset("x-test-cyrillic", func(r io.Reader) io.Reader {
+		return charmap.KOI8R.NewDecoder().Reader(r)
+	})
+	body := "From: a@example.com\r\n" +
+		"Subject: =?x-test-cyrillic?B?8NLJ18XU?=\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain; charset=x-test-cyrillic\r\n" +
+		"\r\n" +
+		"\xf0\xd2\xc9\xd7\xc5\xd4\r\n" +
+		"--b\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"Content-Disposition: attachment; filename=\"=?x-unknown?Q?caf=E9.txt?=\"\r\n" +
+		"\r\n" +
+		"data\r\n" +
+		"--b--\r\n"
+	d := &Dialer{}
+	e := &Email{}
+	if !d.parseEmailBody(e, body) {
+		t.Fatal("parseEmailBody() = false")
+	}
+	if e.Subject != "Привет" {
+		t.Errorf("Subject = %q, want %q", e.Subject, "Привет")
+	}
+	if strings.TrimSpace(e.Text) != "Привет" {
+		t.Errorf("Text = %q, want %q", e.Text, "Привет")
+	}
+	if len(e.Attachments) != 1 || e.Attachments[0].Name != "café.txt" {
+		t.Errorf("Attachments = %+v", e.Attachments)
+	}
+}
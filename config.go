@@ -0,0 +1,169 @@
+package imap
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+)
+
+// TransportMode selects how the connection to the server is secured.
+type TransportMode int
+
+const (
+	// TransportTLS uses implicit TLS from the first byte (usually port 993).
+	// It is the default.
+	TransportTLS TransportMode = iota
+	// TransportSTARTTLS connects in plaintext (usually port 143) and upgrades
+	// to TLS with the STARTTLS command before authenticating. The connection
+	// is refused if the server does not advertise STARTTLS.
+	TransportSTARTTLS
+	// TransportInsecure uses an unencrypted connection for the whole
+	// session, including credentials. It is intended for local test servers
+	// only.
+	TransportInsecure
+)
+
+// String returns the name of the transport mode
+func (m TransportMode) String() string {
+	switch m {
+	case TransportTLS:
+		return "tls"
+	case TransportSTARTTLS:
+		return "starttls"
+	case TransportInsecure:
+		return "insecure"
+	}
+	return fmt.Sprintf("TransportMode(%d)", int(m))
+}
+
+// Config holds the settings for a single connection. Unlike the
+// package-level variables, a Config only affects the Dialer it is passed to
+// (and its clones and reconnects), so connections to different providers can
+// use different timeouts, retry budgets and TLS policies.
+//
+// Fields left at their zero value mean exactly that: no retries, no timeout,
+// verification enabled. Start from DefaultConfig to inherit the package-level
+// defaults instead.
+//
+// Example:
+//
+//	cfg := imap.DefaultConfig()
+//	cfg.Host, cfg.Port = "imap.example.com", 993
+//	cfg.Username, cfg.Password = "user", "pass"
+//	cfg.CommandTimeout = 30 * time.Second
+//	m, err := imap.Dial(ctx, cfg)
+type Config struct {
+	Host string
+	Port int
+
+	// Username and Password are used for LOGIN authentication.
+	Username string
+	Password string
+
+	// AccessToken switches authentication to XOAUTH2 (OAuth 2.0). When it is
+	// set, Password is ignored.
+	AccessToken string
+
+	// OAuth2 selects XOAUTH2 even if AccessToken is empty, so that a missing
+	// token fails authentication instead of falling back to LOGIN.
+	OAuth2 bool
+
+	// Verbose logs every command and its response at debug level.
+	Verbose bool
+
+	// SkipResponses suppresses server responses in verbose mode.
+	SkipResponses bool
+
+	// RetryCount is the number of times a failed connection attempt or
+	// retryable command is retried. It is ignored when RetryPolicy is set.
+	RetryCount int
+
+	// RetryPolicy decides which failures are retried and how long to wait
+	// between attempts. Nil means DefaultRetryPolicy(RetryCount).
+	RetryPolicy RetryPolicy
+
+	// DialTimeout bounds establishing a new connection. Zero means no timeout.
+	DialTimeout time.Duration
+
+	// CommandTimeout bounds each command. Zero means no timeout.
+	CommandTimeout time.Duration
+
+	// MaxLineLength limits the length of a command line that carries a
+	// message set, such as UID FETCH for a UIDSet. Sets that would exceed it
+	// are split over several commands. Zero means no limit.
+	MaxLineLength int
+
+	// Transport selects implicit TLS (the default), STARTTLS or an
+	// unencrypted connection. It is preserved across Reconnect.
+	Transport TransportMode
+
+	// TLSSkipVerify disables certificate verification. Use with caution;
+	// skipping verification exposes the connection to man-in-the-middle
+	// attacks.
+	TLSSkipVerify bool
+
+	// TLSConfig customizes the TLS handshake, e.g. to pin a CA bundle with
+	// RootCAs or present client Certificates. It is cloned before use, so
+	// the caller may keep modifying it. An empty ServerName defaults to
+	// Host. TLSSkipVerify, when set, overrides InsecureSkipVerify.
+	TLSConfig *tls.Config
+
+	// DialContext opens the underlying network connection, e.g. through a
+	// SOCKS5 or HTTP CONNECT proxy or an in-memory pipe. TLS and STARTTLS
+	// are layered on top of the returned connection. Nil means a net.Dialer.
+	// DialTimeout still applies through the context.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Logger receives this connection's log output. Nil means the package
+	// logger configured with SetLogger.
+	Logger Logger
+
+	// UnsolicitedHandler is notified of changes the server reports while
+	// other commands run: new messages (EXISTS, RECENT), expunges, flag
+	// updates (FETCH FLAGS), [ALERT] texts and BYE. Responses received
+	// during IDLE go to the handler passed to StartIdle instead. Responses
+	// that arrive while no command runs are held until the next command
+	// starts; issue a NOOP or use IDLE to receive them sooner. Nil disables
+	// notifications.
+	UnsolicitedHandler *IdleHandler
+}
+
+// DefaultConfig returns a Config populated from the package-level defaults
+// (Verbose, SkipResponses, RetryCount, DialTimeout, CommandTimeout,
+// MaxLineLength, TLSSkipVerify, TLSConfig and DialContext). The values are
+// copied, so later changes to the package variables do not affect the
+// returned Config.
+func DefaultConfig() Config {
+	return Config{
+		Verbose:        Verbose,
+		SkipResponses:  SkipResponses,
+		RetryCount:     RetryCount,
+		DialTimeout:    DialTimeout,
+		CommandTimeout: CommandTimeout,
+		MaxLineLength:  MaxLineLength,
+		TLSSkipVerify:  TLSSkipVerify,
+		TLSConfig:      TLSConfig,
+		DialContext:    DialContext,
+	}
+}
+
+// Config returns the settings the Dialer was created with. Credentials and
+// address reflect the Dialer's current Username, Password, Host and Port
+// fields.
+func (d *Dialer) Config() Config {
+	cfg := d.config
+	cfg.Host = d.Host
+	cfg.Port = d.Port
+	cfg.Username = d.Username
+	cfg.OAuth2 = d.useXOAUTH2
+	if d.useXOAUTH2 {
+		cfg.Password = ""
+		cfg.AccessToken = d.Password
+	} else {
+		cfg.Password = d.Password
+		cfg.AccessToken = ""
+	}
+	return cfg
+}
@@ -0,0 +1,248 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent writes from log handlers.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func newTestConfig(server *mockIMAPServer) Config {
+	return Config{
+		Host:          server.GetHost(),
+		Port:          server.GetPort(),
+		Username:      "user",
+		Password:      "pass",
+		TLSSkipVerify: true,
+	}
+}
+
+func TestDefaultConfig_SnapshotsGlobals(t *testing.T) {
+	origRetry, origTimeout := RetryCount, CommandTimeout
+	RetryCount = 7
+	CommandTimeout = 3 * time.Second
+	t.Cleanup(func() { RetryCount, CommandTimeout = origRetry, origTimeout })
+
+	cfg := DefaultConfig()
+	RetryCount = 1
+
+	if cfg.RetryCount != 7 {
+		t.Errorf("RetryCount = %d, want 7", cfg.RetryCount)
+	}
+	if cfg.CommandTimeout != 3*time.Second {
+		t.Errorf("CommandTimeout = %v, want 3s", cfg.CommandTimeout)
+	}
+}
+
+func TestDial_UsesPerConnectionSettings(t *testing.T) {
+	t.Parallel()
+	server, err := newMockIMAPServer("user", "pass")
+	if err != nil {
+		t.Fatalf("failed to create mock server: %v", err)
+	}
+	t.Cleanup(server.Close)
+
+	// The package default verifies certificates, so this only succeeds if
+	// the per-connection TLSSkipVerify is honored.
+	cfg := newTestConfig(server)
+	cfg.CommandTimeout = 2 * time.Second
+	d, err := Dial(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { _ = d.Close() })
+
+	if err := d.Reconnect(); err != nil {
+		t.Fatalf("Reconnect should reuse the per-connection TLS policy: %v", err)
+	}
+
+	got := d.Config()
+	if !got.TLSSkipVerify || got.CommandTimeout != 2*time.Second {
+		t.Errorf("Config() = %+v, want TLSSkipVerify and 2s CommandTimeout", got)
+	}
+	if got.Username != "user" || got.Password != "pass" || got.AccessToken != "" {
+		t.Errorf("Config() credentials = %q/%q/%q", got.Username, got.Password, got.AccessToken)
+	}
+}
+
+func TestDial_AccessTokenUsesXOAUTH2(t *testing.T) {
+	t.Parallel()
+	server, err := newMockIMAPServer("user", "pass")
+	if err != nil {
+		t.Fatalf("failed to create mock server: %v", err)
+	}
+	t.Cleanup(server.Close)
+
+	cfg := newTestConfig(server)
+	cfg.Password = ""
+	cfg.AccessToken = "token"
+	d, err := Dial(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { _ = d.Close() })
+
+	if !d.useXOAUTH2 {
+		t.Error("expected XOAUTH2 authentication")
+	}
+	if got := d.Config(); got.AccessToken != "token" || got.Password != "" {
+		t.Errorf("Config() = %q/%q, want access token only", got.Password, got.AccessToken)
+	}
+}
+
+func TestDial_OAuth2WithoutToken(t *testing.T) {
+	t.Parallel()
+	server, err := newMockIMAPServer("user", "pass")
+	if err != nil {
+		t.Fatalf("failed to create mock server: %v", err)
+	}
+	t.Cleanup(server.Close)
+
+	// The password must not be sent with LOGIN when the token is missing
+	cfg := newTestConfig(server)
+	cfg.OAuth2 = true
+	d, err := Dial(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { _ = d.Close() })
+
+	for _, c := range server.Commands() {
+		if strings.HasPrefix(c, "LOGIN") {
+			t.Errorf("sent %q, want XOAUTH2 only", c)
+		}
+	}
+	if got := d.Config(); !got.OAuth2 || got.AccessToken != "" || got.Password != "" {
+		t.Errorf("Config() = %v/%q/%q, want OAuth2 without credentials", got.OAuth2, got.Password, got.AccessToken)
+	}
+}
+
+func TestClone_PreservesConfig(t *testing.T) {
+	t.Parallel()
+	server, err := newMockIMAPServer("user", "pass")
+	if err != nil {
+		t.Fatalf("failed to create mock server: %v", err)
+	}
+	t.Cleanup(server.Close)
+
+	cfg := newTestConfig(server)
+	cfg.RetryCount = 4
+	cfg.DialTimeout = 3 * time.Second
+	d, err := Dial(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { _ = d.Close() })
+
+	d2, err := d.Clone()
+	if err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+	t.Cleanup(func() { _ = d2.Close() })
+
+	if d2.ConnNum == d.ConnNum {
+		t.Error("clone should get its own connection number")
+	}
+	if got := d2.Config(); got.RetryCount != 4 || got.DialTimeout != 3*time.Second || !got.TLSSkipVerify {
+		t.Errorf("clone config = %+v, want original settings", got)
+	}
+}
+
+func TestClone_ConcurrentWithSelect(t *testing.T) {
+	t.Parallel()
+	d, _ := dialWithCapabilities(t, "")
+	if err := d.SelectFolder("INBOX"); err != nil {
+		t.Fatalf("SelectFolder() error = %v", err)
+	}
+
+	// Run with -race: Clone must read the selected folder under the lock
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for range 5 {
+			if err := d.ExamineFolder("INBOX"); err != nil {
+				t.Errorf("ExamineFolder() error = %v", err)
+			}
+		}
+	})
+	for range 3 {
+		d2, err := d.Clone()
+		if err != nil {
+			t.Fatalf("Clone failed: %v", err)
+		}
+		if d2.Folder != "INBOX" {
+			t.Errorf("clone selected %q, want INBOX", d2.Folder)
+		}
+		_ = d2.Close()
+	}
+	wg.Wait()
+}
+
+func TestDial_PerConnectionLogger(t *testing.T) {
+	t.Parallel()
+	server, err := newMockIMAPServer("user", "pass")
+	if err != nil {
+		t.Fatalf("failed to create mock server: %v", err)
+	}
+	t.Cleanup(server.Close)
+
+	var verboseOut, quietOut syncBuffer
+	newLogger := func(buf *syncBuffer) Logger {
+		return SlogLogger(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	}
+
+	verboseCfg := newTestConfig(server)
+	verboseCfg.Verbose = true
+	verboseCfg.Logger = newLogger(&verboseOut)
+	verbose, err := Dial(context.Background(), verboseCfg)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { _ = verbose.Close() })
+
+	quietCfg := newTestConfig(server)
+	quietCfg.Logger = newLogger(&quietOut)
+	quiet, err := Dial(context.Background(), quietCfg)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { _ = quiet.Close() })
+
+	if _, err := verbose.Exec("NOOP", false, 0, nil); err != nil {
+		t.Fatalf("NOOP failed: %v", err)
+	}
+	if _, err := quiet.Exec("NOOP", false, 0, nil); err != nil {
+		t.Fatalf("NOOP failed: %v", err)
+	}
+
+	out := verboseOut.String()
+	if !strings.Contains(out, "sending command") || !strings.Contains(out, "component=imap/agent") {
+		t.Errorf("verbose logger missing command log: %q", out)
+	}
+	if strings.Contains(out, "pass\"") {
+		t.Errorf("password leaked into log: %q", out)
+	}
+	if quietOut.String() != "" {
+		t.Errorf("quiet connection should not log, got %q", quietOut.String())
+	}
+}
@@ -1,13 +1,17 @@
 package imap
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
-
-	retry "github.com/StirlingMarketingGroup/go-retry"
+	"sync/atomic"
 )
 
 var (
@@ -15,9 +19,21 @@ var (
 	nextConnNumMutex = sync.RWMutex{}
 )
 
-// Dialer represents an IMAP connection
+// ErrSTARTTLSNotSupported is returned when TransportSTARTTLS is requested but
+// the server does not advertise the STARTTLS capability.
+var ErrSTARTTLSNotSupported error = &UnsupportedError{Extension: "STARTTLS"}
+
+// Dialer represents an IMAP connection.
+//
+// A Dialer is safe for concurrent use: commands from different goroutines
+// are serialized on the connection, and a running IDLE monitor is paused
+// while they execute. Operations made of several commands, such as MoveEmail
+// on a read-only folder, are not atomic with respect to other goroutines.
+// The exported fields are updated under the connection lock; read them only
+// when no other goroutine is changing folders.
 type Dialer struct {
-	conn      *tls.Conn
+	conn      net.Conn
+	reader    *responseReader // reads and routes everything received on conn
 	Folder    string
 	ReadOnly  bool
 	Username  string
@@ -28,92 +44,187 @@ type Dialer struct {
 	ConnNum   int
 	state     int
 	stateMu   sync.Mutex
-	idleStop  chan struct{}
-	idleDone  chan struct{}
+	// mu serializes use of conn; waiting counts goroutines blocked in lock
+	mu      sync.Mutex
+	waiting atomic.Int32
+	idle    *idleMonitor // guarded by stateMu
 	// useXOAUTH2 indicates whether XOAUTH2 authentication should be used
 	// on (re)connection instead of LOGIN. It is set by NewWithOAuth2.
 	useXOAUTH2 bool
+	caps       []string // capabilities advertised by the server; nil if unknown
+	enabled    []string // extensions turned on with ENABLE, for Reconnect
+	// utf8Mailboxes is set once UTF8=ACCEPT is enabled, after which folder
+	// names are sent as UTF-8 instead of modified UTF-7
+	utf8Mailboxes atomic.Bool
+	// rawMailboxes holds the names in the last GetFolders listing that are
+	// not valid modified UTF-7, which are sent back as they are
+	rawMailboxes sync.Map
+	// config holds the per-connection settings. Credentials and address
+	// are tracked by the exported fields above instead.
+	config Config
+}
+
+// lock acquires exclusive use of the connection. If an IDLE monitor holds
+// it, the active IDLE command is ended so that the caller can proceed; the
+// monitor resumes once no more goroutines are waiting.
+func (d *Dialer) lock() {
+	d.waiting.Add(1)
+	if m := d.idleMonitor(); m != nil {
+		signal(m.interrupt)
+	}
+	d.mu.Lock()
+	d.waiting.Add(-1)
+}
+
+// unlock releases the connection and lets a paused IDLE monitor resume
+func (d *Dialer) unlock() {
+	d.mu.Unlock()
+	if m := d.idleMonitor(); m != nil && d.waiting.Load() == 0 {
+		signal(m.resume)
+	}
 }
 
-// dialHost establishes a TLS connection to the IMAP server
-func dialHost(host string, port int) (*tls.Conn, error) {
-	dialer := &net.Dialer{Timeout: DialTimeout}
+// tlsConfig returns the TLS configuration for the connection
+func (d *Dialer) tlsConfig() *tls.Config {
 	var cfg *tls.Config
-	if TLSSkipVerify {
-		cfg = &tls.Config{InsecureSkipVerify: true}
+	if d.config.TLSConfig != nil {
+		cfg = d.config.TLSConfig.Clone()
+	} else {
+		cfg = &tls.Config{}
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = d.Host
 	}
-	return tls.DialWithDialer(dialer, "tcp", host+":"+strconv.Itoa(port), cfg)
+	if d.config.TLSSkipVerify {
+		cfg.InsecureSkipVerify = true
+	}
+	return cfg
 }
 
-// NewWithOAuth2 creates a new IMAP connection using OAuth2 authentication
-func NewWithOAuth2(username string, accessToken string, host string, port int) (d *Dialer, err error) {
-	nextConnNumMutex.RLock()
-	connNum := nextConnNum
-	nextConnNumMutex.RUnlock()
+// dialNet opens the underlying network connection with Config.DialContext,
+// falling back to a net.Dialer.
+func (d *Dialer) dialNet(ctx context.Context, addr string) (net.Conn, error) {
+	if d.config.DialContext != nil {
+		return d.config.DialContext(ctx, "tcp", addr)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", addr)
+}
 
-	nextConnNumMutex.Lock()
-	nextConnNum++
-	nextConnNumMutex.Unlock()
+// connect dials the server using the configured transport, reads the
+// greeting and, for STARTTLS, upgrades the connection. On success the
+// connection is ready for authentication.
+func (d *Dialer) connect(ctx context.Context) error {
+	addr := net.JoinHostPort(d.Host, strconv.Itoa(d.Port))
+	d.caps = nil
+	d.enabled = nil
+	d.utf8Mailboxes.Store(false)
 
-	// Retry only the connection establishment, not authentication
-	err = retry.Retry(func() error {
-		if Verbose {
-			debugLog(connNum, "", "establishing connection", "host", host, "port", port, "auth", "xoauth2")
+	dialCtx := ctx
+	if d.config.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, d.config.DialTimeout)
+		defer cancel()
+	}
+
+	conn, err := d.dialNet(dialCtx, addr)
+	if err != nil {
+		return err
+	}
+	if d.config.Transport == TransportTLS {
+		tlsConn := tls.Client(conn, d.tlsConfig())
+		if err := tlsConn.HandshakeContext(dialCtx); err != nil {
+			_ = conn.Close()
+			return fmt.Errorf("imap tls handshake: %w", err)
 		}
-		var conn *tls.Conn
-		conn, err = dialHost(host, port)
-		if err != nil {
-			if Verbose {
-				debugLog(connNum, "", "connection attempt failed", "error", err)
-			}
+		conn = tlsConn
+	}
+	d.conn = conn
+	d.reader = newResponseReader(bufio.NewReader(conn))
+	d.Connected = true
+
+	if err := d.readGreeting(ctx); err != nil {
+		_ = d.closeLocked()
+		return err
+	}
+	if d.config.Transport == TransportSTARTTLS {
+		if err := d.startTLS(ctx); err != nil {
+			_ = d.closeLocked()
 			return err
 		}
-		d = &Dialer{
-			conn:       conn,
-			Username:   username,
-			Password:   accessToken,
-			Host:       host,
-			Port:       port,
-			Connected:  true,
-			ConnNum:    connNum,
-			useXOAUTH2: true,
-		}
-		return nil
-	}, RetryCount, func(err error) error {
-		if Verbose {
-			debugLog(connNum, "", "connection retry scheduled")
-			if d != nil && d.conn != nil {
-				_ = d.conn.Close()
-			}
-		}
-		return nil
-	}, func() error {
-		if Verbose {
-			debugLog(connNum, "", "retrying connection")
-		}
-		return nil
-	})
+	}
+	return nil
+}
+
+// readGreeting reads the server greeting that opens every IMAP session
+func (d *Dialer) readGreeting(ctx context.Context) error {
+	// The greeting is untagged, so a command without a tag receives it
+	cmd, err := d.startCommand(nil, modeBuffered)
 	if err != nil {
-		warnLog(connNum, "", "failed to establish connection", "error", err)
-		if d != nil && d.conn != nil {
-			_ = d.conn.Close()
-		}
-		return nil, err
+		return fmt.Errorf("imap greeting: %w", err)
 	}
+	defer d.reader.release(cmd)
 
-	// Authenticate after connection is established - no retry for auth failures
-	err = d.Authenticate(username, accessToken)
+	expired, stopTimer := deadlineTimer(d.commandDeadline(ctx))
+	defer stopTimer()
+	line, err := d.reader.next(ctx, cmd, expired)
 	if err != nil {
-		errorLog(connNum, "", "authentication failed", "error", err)
-		_ = d.Close()
-		return nil, err
+		return fmt.Errorf("imap greeting: %w", d.contextError(ctx, err))
 	}
+	d.responseLog(line)
+	d.captureCapabilities(line)
 
-	return d, nil
+	upper := bytes.ToUpper(line)
+	switch {
+	case bytes.HasPrefix(upper, []byte("* OK")), bytes.HasPrefix(upper, []byte("* PREAUTH")):
+		return nil
+	case bytes.HasPrefix(upper, []byte("* BYE")):
+		return fmt.Errorf("imap greeting: %w", parseStatusError(line[2:]))
+	}
+	return fmt.Errorf("imap greeting: unexpected response: %s", dropNl(line))
 }
 
-// New creates a new IMAP connection using username/password authentication
-func New(username string, password string, host string, port int) (d *Dialer, err error) {
+// startTLS upgrades a plaintext connection with the STARTTLS command
+func (d *Dialer) startTLS(ctx context.Context) error {
+	ok, err := d.hasCapabilityLocked(ctx, "STARTTLS")
+	if err != nil {
+		return fmt.Errorf("imap starttls capability: %w", err)
+	}
+	if !ok {
+		return ErrSTARTTLSNotSupported
+	}
+	if _, err := d.execLocked(ctx, "STARTTLS", false, true, 0, nil); err != nil {
+		return fmt.Errorf("imap starttls: %w", err)
+	}
+
+	// The reader stops after the tagged OK. Anything already buffered beyond
+	// it was sent in the clear but would be treated as if it came over TLS
+	// (STARTTLS command injection), so refuse it.
+	<-d.reader.done
+	if d.reader.r.Buffered() > 0 {
+		return fmt.Errorf("imap starttls: unexpected data after response")
+	}
+
+	tlsConn := tls.Client(d.conn, d.tlsConfig())
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return fmt.Errorf("imap starttls handshake: %w", err)
+	}
+	d.conn = tlsConn
+	d.reader = newResponseReader(bufio.NewReader(tlsConn))
+	// Capabilities seen before the upgrade may have been tampered with
+	d.caps = nil
+	return nil
+}
+
+// Dial connects to cfg.Host:cfg.Port using cfg.Transport and authenticates
+// with LOGIN, or with XOAUTH2 when cfg.AccessToken is set. All settings in
+// cfg apply to the returned Dialer only and are preserved by Clone and
+// Reconnect.
+//
+// Connection establishment is retried according to cfg.RetryPolicy, or up
+// to cfg.RetryCount times when it is nil; authentication failures are never
+// retried.
+func Dial(ctx context.Context, cfg Config) (d *Dialer, err error) {
 	nextConnNumMutex.RLock()
 	connNum := nextConnNum
 	nextConnNumMutex.RUnlock()
@@ -122,94 +233,140 @@ func New(username string, password string, host string, port int) (d *Dialer, er
 	nextConnNum++
 	nextConnNumMutex.Unlock()
 
+	d = &Dialer{
+		Username: cfg.Username,
+		Password: cfg.Password,
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		ConnNum:  connNum,
+		config:   cfg,
+	}
+	auth := "login"
+	if cfg.OAuth2 || cfg.AccessToken != "" {
+		d.Password = cfg.AccessToken
+		d.useXOAUTH2 = true
+		auth = "xoauth2"
+	}
+	d.config.Username, d.config.Password, d.config.AccessToken = "", "", ""
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	// Retry only the connection establishment, not authentication
-	err = retry.Retry(func() error {
-		if Verbose {
-			debugLog(connNum, "", "establishing connection", "host", host, "port", port, "auth", "login")
-		}
-		var conn *tls.Conn
-		conn, err = dialHost(host, port)
-		if err != nil {
-			if Verbose {
-				debugLog(connNum, "", "connection attempt failed", "error", err)
-			}
-			return err
-		}
-		d = &Dialer{
-			conn:       conn,
-			Username:   username,
-			Password:   password,
-			Host:       host,
-			Port:       port,
-			Connected:  true,
-			ConnNum:    connNum,
-			useXOAUTH2: false,
-		}
-		return nil
-	}, RetryCount, func(err error) error {
-		if Verbose {
-			debugLog(connNum, "", "connection retry scheduled")
-			if d != nil && d.conn != nil {
-				_ = d.conn.Close()
-			}
+	err = d.retry(ctx, true, -1, func(n int) (bool, error) {
+		d.debugLog("establishing connection", "host", d.Host, "port", d.Port, "auth", auth, "transport", d.config.Transport, "attempt", n)
+		if err := d.connect(ctx); err != nil {
+			d.debugLog("connection attempt failed", "error", err)
+			return false, err
 		}
-		return nil
-	}, func() error {
-		if Verbose {
-			debugLog(connNum, "", "retrying connection")
-		}
-		return nil
+		return false, nil
 	})
 	if err != nil {
-		warnLog(connNum, "", "failed to establish connection", "error", err)
-		if d != nil && d.conn != nil {
-			_ = d.conn.Close()
-		}
+		d.warnLog("failed to establish connection", "error", err)
 		return nil, err
 	}
 
 	// Authenticate after connection is established - no retry for auth failures
-	err = d.Login(username, password)
+	if d.useXOAUTH2 {
+		err = d.authenticateLocked(ctx, d.Username, d.Password)
+	} else {
+		err = d.loginLocked(ctx, d.Username, d.Password)
+	}
 	if err != nil {
-		errorLog(connNum, "", "authentication failed", "error", err)
-		_ = d.Close()
+		d.errorLog("authentication failed", "error", err)
+		_ = d.closeLocked()
 		return nil, err
 	}
 
 	return d, nil
 }
 
+// NewWithOAuth2 creates a new IMAP connection using OAuth2 authentication
+func NewWithOAuth2(username string, accessToken string, host string, port int) (d *Dialer, err error) {
+	return NewWithOAuth2Context(context.Background(), username, accessToken, host, port)
+}
+
+// NewWithOAuth2Context is like NewWithOAuth2 but honors ctx while dialing,
+// between connection retries, and during authentication.
+func NewWithOAuth2Context(ctx context.Context, username string, accessToken string, host string, port int) (d *Dialer, err error) {
+	cfg := DefaultConfig()
+	cfg.Username = username
+	cfg.AccessToken = accessToken
+	cfg.OAuth2 = true
+	cfg.Host = host
+	cfg.Port = port
+	return Dial(ctx, cfg)
+}
+
+// New creates a new IMAP connection using username/password authentication
+func New(username string, password string, host string, port int) (d *Dialer, err error) {
+	return NewContext(context.Background(), username, password, host, port)
+}
+
+// NewContext is like New but honors ctx while dialing, between connection
+// retries, and during authentication.
+func NewContext(ctx context.Context, username string, password string, host string, port int) (d *Dialer, err error) {
+	cfg := DefaultConfig()
+	cfg.Username = username
+	cfg.Password = password
+	cfg.Host = host
+	cfg.Port = port
+	return Dial(ctx, cfg)
+}
+
 // Clone creates a copy of the dialer with the same configuration
 func (d *Dialer) Clone() (d2 *Dialer, err error) {
-	if d.useXOAUTH2 {
-		d2, err = NewWithOAuth2(d.Username, d.Password, d.Host, d.Port)
-	} else {
-		d2, err = New(d.Username, d.Password, d.Host, d.Port)
+	return d.CloneContext(context.Background())
+}
+
+// CloneContext is like Clone but honors ctx while connecting and restoring
+// the selected folder.
+func (d *Dialer) CloneContext(ctx context.Context) (d2 *Dialer, err error) {
+	d.lock()
+	cfg, folder, readOnly, enabled := d.Config(), d.Folder, d.ReadOnly, slices.Clone(d.enabled)
+	d.unlock()
+
+	d2, err = Dial(ctx, cfg)
+	if err != nil {
+		return nil, err
 	}
-	// d2.Verbose = d1.Verbose
-	if d.Folder != "" {
-		if d.ReadOnly {
-			err = d2.ExamineFolder(d.Folder)
+	if len(enabled) > 0 {
+		if _, err := d2.EnableContext(ctx, enabled...); err != nil {
+			return nil, fmt.Errorf("imap clone: %w", err)
+		}
+	}
+	if folder != "" {
+		if readOnly {
+			err = d2.ExamineFolderContext(ctx, folder)
 		} else {
-			err = d2.SelectFolder(d.Folder)
+			err = d2.SelectFolderContext(ctx, folder)
 		}
 		if err != nil {
-			return nil, fmt.Errorf("imap clone: %s", err)
+			return nil, fmt.Errorf("imap clone: %w", err)
 		}
 	}
 	return d2, err
 }
 
-// Close closes the IMAP connection
+// Close closes the IMAP connection. A running IDLE monitor is stopped first.
 func (d *Dialer) Close() (err error) {
+	_ = d.StopIdle()
+	d.lock()
+	defer d.unlock()
+	d.rawMailboxes.Clear()
+	return d.closeLocked()
+}
+
+// closeLocked closes the connection; the caller holds the connection lock
+func (d *Dialer) closeLocked() (err error) {
 	if d.Connected {
-		if Verbose {
-			debugLog(d.ConnNum, d.Folder, "closing connection")
-		}
+		d.debugLog("closing connection")
+		d.reader.close()
 		err = d.conn.Close()
 		if err != nil {
-			return fmt.Errorf("imap close: %s", err)
+			return fmt.Errorf("imap close: %w", err)
 		}
+		<-d.reader.done
 		d.Connected = false
 	}
 	return err
@@ -217,43 +374,61 @@ func (d *Dialer) Close() (err error) {
 
 // Reconnect closes and reopens the IMAP connection with re-authentication
 func (d *Dialer) Reconnect() (err error) {
-	_ = d.Close()
-	if Verbose {
-		debugLog(d.ConnNum, d.Folder, "reopening connection")
-	}
+	return d.ReconnectContext(context.Background())
+}
 
-	conn, err := dialHost(d.Host, d.Port)
-	if err != nil {
-		return fmt.Errorf("imap reconnect dial: %s", err)
+// ReconnectContext is like Reconnect but honors ctx while dialing,
+// authenticating and restoring the selected folder.
+func (d *Dialer) ReconnectContext(ctx context.Context) (err error) {
+	d.lock()
+	defer d.unlock()
+	return d.reconnectLocked(ctx)
+}
+
+// reconnectLocked reopens the connection; the caller holds the connection
+// lock
+func (d *Dialer) reconnectLocked(ctx context.Context) (err error) {
+	_ = d.closeLocked()
+	d.debugLog("reopening connection")
+	enabled := d.enabled
+
+	if err := d.connect(ctx); err != nil {
+		return fmt.Errorf("imap reconnect dial: %w", err)
 	}
-	d.conn = conn
-	d.Connected = true
 
 	// Re-authenticate using the original method
 	if d.useXOAUTH2 {
-		if err := d.Authenticate(d.Username, d.Password); err != nil {
+		if err := d.authenticateLocked(ctx, d.Username, d.Password); err != nil {
 			// Best effort cleanup on failure
 			_ = d.conn.Close()
 			d.Connected = false
-			return fmt.Errorf("imap reconnect auth xoauth2: %s", err)
+			return fmt.Errorf("imap reconnect auth xoauth2: %w", err)
 		}
 	} else {
-		if err := d.Login(d.Username, d.Password); err != nil {
+		if err := d.loginLocked(ctx, d.Username, d.Password); err != nil {
 			_ = d.conn.Close()
 			d.Connected = false
-			return fmt.Errorf("imap reconnect login: %s", err)
+			return fmt.Errorf("imap reconnect login: %w", err)
+		}
+	}
+
+	// ENABLE must come before SELECT, as UTF8=ACCEPT changes how the folder
+	// name is sent
+	if len(enabled) > 0 {
+		if _, err := d.execLocked(ctx, "ENABLE "+strings.Join(enabled, " "), false, true, 0, nil); err != nil {
+			return fmt.Errorf("imap reconnect enable: %w", err)
 		}
 	}
 
 	// Restore selected folder state if any
 	if d.Folder != "" {
 		if d.ReadOnly {
-			if err := d.ExamineFolder(d.Folder); err != nil {
-				return fmt.Errorf("imap reconnect examine: %s", err)
+			if err := d.selectLocked(ctx, d.Folder, true, 0); err != nil {
+				return fmt.Errorf("imap reconnect examine: %w", err)
 			}
 		} else {
-			if err := d.SelectFolder(d.Folder); err != nil {
-				return fmt.Errorf("imap reconnect select: %s", err)
+			if err := d.selectLocked(ctx, d.Folder, false, 0); err != nil {
+				return fmt.Errorf("imap reconnect select: %w", err)
 			}
 		}
 	}
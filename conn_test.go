@@ -0,0 +1,221 @@
+package imap
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDial_STARTTLS(t *testing.T) {
+	t.Parallel()
+	server, err := newPlaintextMockIMAPServer("user", "pass", true)
+	if err != nil {
+		t.Fatalf("failed to create mock server: %v", err)
+	}
+	defer server.Close()
+
+	cfg := newTestConfig(server)
+	cfg.Transport = TransportSTARTTLS
+	d, err := Dial(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer d.Close()
+
+	if _, err := d.Exec("NOOP", false, 0, nil); err != nil {
+		t.Fatalf("NOOP after STARTTLS: %v", err)
+	}
+	if n := server.GetPlaintextAuthAttempts(); n != 0 {
+		t.Errorf("credentials sent over plaintext %d times, want 0", n)
+	}
+
+	if err := d.Reconnect(); err != nil {
+		t.Fatalf("Reconnect() error = %v", err)
+	}
+	if d.Config().Transport != TransportSTARTTLS {
+		t.Errorf("Transport after Reconnect = %v, want starttls", d.Config().Transport)
+	}
+	if n := server.GetPlaintextAuthAttempts(); n != 0 {
+		t.Errorf("credentials sent over plaintext after Reconnect %d times, want 0", n)
+	}
+}
+
+func TestDial_STARTTLSNotAdvertised(t *testing.T) {
+	t.Parallel()
+	server, err := newPlaintextMockIMAPServer("user", "pass", false)
+	if err != nil {
+		t.Fatalf("failed to create mock server: %v", err)
+	}
+	defer server.Close()
+
+	cfg := newTestConfig(server)
+	cfg.Transport = TransportSTARTTLS
+	cfg.RetryCount = 3
+	_, err = Dial(context.Background(), cfg)
+	if !errors.Is(err, ErrSTARTTLSNotSupported) {
+		t.Fatalf("Dial() error = %v, want ErrSTARTTLSNotSupported", err)
+	}
+	if n := server.GetAuthAttempts(); n != 0 {
+		t.Errorf("auth attempts = %d, want 0", n)
+	}
+}
+
+func TestDial_Insecure(t *testing.T) {
+	t.Parallel()
+	server, err := newPlaintextMockIMAPServer("user", "pass", false)
+	if err != nil {
+		t.Fatalf("failed to create mock server: %v", err)
+	}
+	defer server.Close()
+
+	cfg := newTestConfig(server)
+	cfg.Transport = TransportInsecure
+	d, err := Dial(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer d.Close()
+
+	if n := server.GetPlaintextAuthAttempts(); n != 1 {
+		t.Errorf("plaintext auth attempts = %d, want 1", n)
+	}
+}
+
+func TestDial_GreetingBye(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("* BYE Too many connections\r\n"))
+			conn.Close()
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	_, err = Dial(context.Background(), Config{
+		Host:      "127.0.0.1",
+		Port:      addr.Port,
+		Username:  "user",
+		Password:  "pass",
+		Transport: TransportInsecure,
+	})
+	if err == nil || !strings.Contains(err.Error(), "Too many connections") {
+		t.Fatalf("Dial() error = %v, want greeting refusal", err)
+	}
+}
+
+func TestDial_TLSConfigRootCAs(t *testing.T) {
+	t.Parallel()
+	server, err := newMockIMAPServer("user", "pass")
+	if err != nil {
+		t.Fatalf("failed to create mock server: %v", err)
+	}
+	defer server.Close()
+
+	cfg := newTestConfig(server)
+	cfg.TLSSkipVerify = false
+	if _, err := Dial(context.Background(), cfg); err == nil {
+		t.Fatal("expected verification failure without a pinned CA")
+	}
+
+	cert, err := x509.ParseCertificate(server.tlsConfig.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	cfg.TLSConfig = &tls.Config{RootCAs: pool}
+
+	d, err := Dial(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Dial() with pinned CA error = %v", err)
+	}
+	defer d.Close()
+
+	if cfg.TLSConfig.ServerName != "" {
+		t.Error("caller's TLSConfig was modified")
+	}
+}
+
+func TestDial_CustomDialContext(t *testing.T) {
+	t.Parallel()
+	server, err := newMockIMAPServer("user", "pass")
+	if err != nil {
+		t.Fatalf("failed to create mock server: %v", err)
+	}
+	defer server.Close()
+
+	var dials atomic.Int32
+	var gotAddr atomic.Value
+	cfg := Config{
+		Host:          "imap.example.test",
+		Port:          993,
+		Username:      "user",
+		Password:      "pass",
+		TLSSkipVerify: true,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dials.Add(1)
+			gotAddr.Store(addr)
+			client, srv := net.Pipe()
+			go server.handleConnection(tls.Server(srv, server.tlsConfig))
+			return client, nil
+		},
+	}
+
+	d, err := Dial(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer d.Close()
+
+	if got := gotAddr.Load(); got != "imap.example.test:993" {
+		t.Errorf("dial addr = %v, want imap.example.test:993", got)
+	}
+	if err := d.Reconnect(); err != nil {
+		t.Fatalf("Reconnect() error = %v", err)
+	}
+	if n := dials.Load(); n != 2 {
+		t.Errorf("DialContext called %d times, want 2", n)
+	}
+}
+
+func TestAdvertisesCapability(t *testing.T) {
+	d := &Dialer{}
+	d.captureCapabilities([]byte("* OK STARTTLS\r\n"))
+	if d.caps != nil {
+		t.Errorf("non-CAPABILITY line cached as %q", d.caps)
+	}
+	d.captureCapabilities([]byte("* CAPABILITY IMAP4rev1 starttls LOGINDISABLED\r\n"))
+	if ok, err := d.hasCapabilityLocked(context.Background(), "STARTTLS"); err != nil || !ok {
+		t.Errorf("expected STARTTLS to be advertised, got %v, %v", ok, err)
+	}
+	if ok, err := d.hasCapabilityLocked(context.Background(), "IDLE"); err != nil || ok {
+		t.Errorf("did not expect IDLE to be advertised, got %v, %v", ok, err)
+	}
+}
+
+func TestTransportMode_String(t *testing.T) {
+	for mode, want := range map[TransportMode]string{
+		TransportTLS:      "tls",
+		TransportSTARTTLS: "starttls",
+		TransportInsecure: "insecure",
+		TransportMode(9):  "TransportMode(9)",
+	} {
+		if got := mode.String(); got != want {
+			t.Errorf("%d.String() = %q, want %q", int(mode), got, want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package imap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateZones are the offsets in hours of the zone names in use in Date
+// headers: those of RFC 5322 §4.3 and a few common ones. Other names are
+// taken as UTC, as RFC 5322 advises.
+var dateZones = map[string]int{
+	"UT": 0, "UTC": 0, "GMT": 0, "Z": 0,
+	"EST": -5, "EDT": -4, "CST": -6, "CDT": -5,
+	"MST": -7, "MDT": -6, "PST": -8, "PDT": -7,
+	"WET": 0, "BST": 1, "CET": 1, "CEST": 2, "EET": 2, "EEST": 3, "JST": 9,
+}
+
+// ParseDate parses the date of a Date header or ENVELOPE, e.g.
+// "Thu, 9 Apr 2026 21:06:17 +0000". Beyond RFC 5322 it accepts the obsolete
+// and malformed forms found in real mail: no weekday or a full one, two- and
+// three-digit years, named zones such as "GMT" or "EST", no zone (UTC), no
+// seconds, comments such as "(UTC)", asctime ("Thu Apr  9 21:06:17 2026"),
+// IMAP's "9-Apr-2026" and ISO 8601. The result is in UTC.
+func ParseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+
+	var (
+		day, month, year       = -1, 0, -1
+		hour, minute, sec      = -1, 0, 0
+		offset, zoned, yearLen = 0, false, 0
+		fields                 = dateFields(s)
+		bad                    string
+	)
+	for _, f := range fields {
+		switch {
+		case f[0] == '+' || f[0] == '-':
+			if zoned || !parseZoneOffset(f, &offset) {
+				bad = f
+			}
+			zoned = true
+		case strings.Contains(f, ":"):
+			if hour >= 0 || !parseClock(f, &hour, &minute, &sec) {
+				bad = f
+			}
+		case isDigits(f):
+			n, _ := strconv.Atoi(f)
+			switch {
+			case len(f) <= 2 && day < 0 && year < 0:
+				day = n
+			case year < 0:
+				year, yearLen = n, len(f)
+			case len(f) > 2:
+				bad = f
+			case month == 0 && day < 0:
+				// ISO order: year, month, day
+				month = n
+			case day < 0:
+				day = n
+			default:
+				bad = f
+			}
+		case month == 0 && monthNumber(f) > 0:
+			month = monthNumber(f)
+		case isWeekday(f) && hour < 0:
+		case hour >= 0 && !zoned:
+			// Named zones after the time; unknown ones are UTC
+			offset, zoned = dateZones[strings.ToUpper(f)]*3600, true
+		case hour >= 0:
+			// e.g. "-0700 PDT"
+		default:
+			bad = f
+		}
+		if bad != "" {
+			break
+		}
+	}
+	switch {
+	case bad != "":
+		return time.Time{}, fmt.Errorf("imap: cannot parse date %q: unexpected %q", s, bad)
+	case day < 0 || month == 0 || year < 0:
+		return time.Time{}, fmt.Errorf("imap: cannot parse date %q: missing day, month or year", s)
+	}
+
+	// RFC 5322 §4.3: two-digit years from 50 are 19xx, others 20xx, and
+	// three-digit years are counted from 1900
+	switch {
+	case yearLen <= 2 && year < 50:
+		year += 2000
+	case yearLen <= 3:
+		year += 1900
+	}
+	if d := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC); d.Day() != day || int(d.Month()) != month {
+		return time.Time{}, fmt.Errorf("imap: cannot parse date %q: no such day", s)
+	}
+	t := time.Date(year, time.Month(month), day, max(hour, 0), minute, sec, 0, time.FixedZone("", offset))
+	return t.UTC(), nil
+}
+
+// dateFields splits a date into its fields, dropping comments and commas and
+// splitting "9-Apr-2026" and "2026-04-09"
+func dateFields(s string) []string {
+	var b strings.Builder
+	depth := 0
+	for _, c := range s {
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth = max(depth-1, 0)
+		case depth > 0:
+		case c == ',':
+			b.WriteByte(' ')
+		default:
+			b.WriteRune(c)
+		}
+	}
+	var fields []string
+	for _, f := range strings.Fields(b.String()) {
+		if parts := strings.Split(f, "-"); len(parts) == 3 && parts[0] != "" {
+			fields = append(fields, parts...)
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// parseZoneOffset parses a numeric zone such as "-0700", "+05:30" or "+01"
+func parseZoneOffset(f string, offset *int) bool {
+	digits := strings.ReplaceAll(f[1:], ":", "")
+	if !isDigits(digits) || (len(digits) != 4 && len(digits) != 2) {
+		return false
+	}
+	h, _ := strconv.Atoi(digits[:2])
+	m := 0
+	if len(digits) == 4 {
+		m, _ = strconv.Atoi(digits[2:])
+	}
+	if m >= 60 {
+		return false
+	}
+	*offset = (h*60 + m) * 60
+	if f[0] == '-' {
+		*offset = -*offset
+	}
+	return true
+}
+
+// parseClock parses "15:04", "15:04:05" or "15:04:05.000"
+func parseClock(f string, hour, minute, sec *int) bool {
+	f, _, _ = strings.Cut(f, ".")
+	parts := strings.Split(f, ":")
+	if len(parts) > 3 {
+		return false
+	}
+	values := []*int{hour, minute, sec}
+	for i, p := range parts {
+		if !isDigits(p) || len(p) > 2 {
+			return false
+		}
+		*values[i], _ = strconv.Atoi(p)
+	}
+	// A leap second is kept as :60 and normalized by time.Date
+	return len(parts) >= 2 && *hour < 24 && *minute < 60 && *sec <= 60
+}
+
+// monthNumber returns the number of the month named or abbreviated f, e.g.
+// "Apr", "Sept" or "april", or 0
+func monthNumber(f string) int {
+	if len(f) < 3 {
+		return 0
+	}
+	f = strings.ToLower(strings.TrimSuffix(f, "."))
+	for m := time.January; m <= time.December; m++ {
+		if strings.HasPrefix(strings.ToLower(m.String()), f) {
+			return int(m)
+		}
+	}
+	return 0
+}
+
+// isWeekday reports whether f is a day of the week, e.g. "Thu" or "Thursday"
+func isWeekday(f string) bool {
+	if len(f) < 3 {
+		return false
+	}
+	f = strings.ToLower(strings.TrimSuffix(f, "."))
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.HasPrefix(strings.ToLower(d.String()), f) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDigits reports whether f is a non-empty string of ASCII digits
+func isDigits(f string) bool {
+	if f == "" {
+		return false
+	}
+	for i := 0; i < len(f); i++ {
+		if f[i] < '0' || f[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseInternalDate parses an INTERNALDATE, which should be in TimeFormat but
+// is not always
+func parseInternalDate(s string) (time.Time, error) {
+	if t, err := time.Parse(TimeFormat, s); err == nil {
+		return t, nil
+	}
+	return ParseDate(s)
+}
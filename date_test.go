@@ -0,0 +1,85 @@
+package imap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	t.Parallel()
+	want := time.Date(2026, time.April, 9, 21, 6, 17, 0, time.UTC)
+	for _, s := range []string{
+		"Thu, 9 Apr 2026 21:06:17 +0000",
+		"Thu, 09 Apr 2026 21:06:17 +0000 (UTC)",
+		"9 Apr 2026 21:06:17 GMT",
+		"Thursday, 9 April 2026 21:06:17 UT",
+		"Thu,9 Apr 2026 21:06:17 Z",
+		"Thu, 9 Apr 26 21:06:17 +0000",
+		"Thu, 9 Apr 126 21:06:17 +0000",
+		"Thu, 9 Apr 2026 17:06:17 EDT",
+		"Thu, 9 Apr 2026 14:06:17 -0700 (PDT)",
+		"Thu, 9 Apr 2026 14:06:17 -0700 PDT",
+		"Thu, 9 Apr 2026 23:06:17 +02:00",
+		"Thu, 9 Apr 2026 21:06:17",
+		"Thu, 9 Apr 2026 21:06:17 XYZ",
+		"Thu, 9 Apr 2026 21:06:17.250 +0000",
+		"Thu Apr  9 21:06:17 2026",
+		"9-Apr-2026 21:06:17 +0000",
+		"2026-04-09 21:06:17 +0000",
+		"2026-04-09T23:06:17+02:00",
+	} {
+		got, err := ParseDate(s)
+		if err != nil {
+			t.Errorf("ParseDate(%q) error = %v", s, err)
+			continue
+		}
+		if !got.Truncate(time.Second).Equal(want) || got.Location() != time.UTC {
+			t.Errorf("ParseDate(%q) = %v, want %v", s, got, want)
+		}
+	}
+
+	if got, err := ParseDate("Sat, 1 Jan 72 00:00 -0000"); err != nil || !got.Equal(time.Date(1972, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("obsolete two-digit year = %v, %v", got, err)
+	}
+
+	for _, s := range []string{"", "yesterday", "Thu, 9 2026 21:06:17", "31 Feb 2026 10:00:00 +0000", "9 Apr 2026 25:00:00", "9 Apr 2026 21:06 +07000"} {
+		if got, err := ParseDate(s); err == nil {
+			t.Errorf("ParseDate(%q) = %v, want an error", s, got)
+		}
+	}
+}
+
+func TestParseEnvelope_BadDate(t *testing.T) {
+	t.Parallel()
+	d := &Dialer{}
+	for _, tt := range []struct {
+		date    string
+		wantErr bool
+	}{
+		{`"9 Apr 26 21:06:17 GMT"`, false},
+		{`"sometime last week"`, true},
+		{`NIL`, false},
+	} {
+		tks, err := parseFetchTokens(`ENVELOPE (` + tt.date + ` "x" NIL NIL NIL NIL NIL NIL NIL NIL)`)
+		if err != nil {
+			t.Fatalf("parseFetchTokens() error = %v", err)
+		}
+		e := &Email{}
+		if err := d.parseEnvelope(e, tks[1], tks); err != nil {
+			t.Fatalf("parseEnvelope(%s) error = %v", tt.date, err)
+		}
+		if (e.SentErr != nil) != tt.wantErr || (tt.date[0] == '"' && !tt.wantErr && e.Sent.Year() != 2026) {
+			t.Errorf("date %s: Sent = %v, SentErr = %v", tt.date, e.Sent, e.SentErr)
+		}
+	}
+}
+
+func TestParseInternalDate(t *testing.T) {
+	t.Parallel()
+	for _, s := range []string{" 9-Apr-2026 17:06:19 -0400", "09-Apr-2026 17:06:19 -0400", "Thu, 9 Apr 2026 21:06:19 +0000"} {
+		got, err := parseInternalDate(s)
+		if err != nil || got.Unix() != 1775768779 {
+			t.Errorf("parseInternalDate(%q) = %v, %v", s, got, err)
+		}
+	}
+}
@@ -2,15 +2,24 @@
 //
 // It focuses on the handful of operations most applications need:
 //
-//   - Connecting over TLS (STARTTLS not required)
+//   - Connecting over implicit TLS, STARTTLS, or plaintext for local testing
 //   - Authenticating with LOGIN or XOAUTH2 (OAuth 2.0)
 //   - Selecting/Examining folders, searching (UID SEARCH), and fetching messages
+//   - Streaming fetches (FetchStream) that read message bodies straight from the connection
 //   - Moving, copying, and appending messages
-//   - Creating, deleting, and renaming folders
+//   - Creating, deleting, and renaming folders, with non-ASCII names in modified UTF-7
 //   - Setting flags, deleting + expunging
 //   - Type-safe search builder (Search().From("x").Unseen().Since(date))
+//   - Fetch builder for arbitrary FETCH items (Fetch().Flags().HeaderFields("Subject"))
+//   - Lazy attachment download (GetEmailsLazy, FetchPart) guided by BODYSTRUCTURE
+//   - Header-only fetches (FetchHeaders) parsed into an ordered, decoded Header
+//   - Tolerant charset decoding with aliases, UTF-7 and RegisterCharset for others
 //   - IMAP IDLE with callbacks for EXISTS/EXPUNGE/FETCH
+//   - Safe for concurrent use; commands are serialized and IDLE is paused around them
+//   - Command pipelining (Pipeline) that honors the RFC 3501 ambiguity rules
 //   - Automatic reconnect with re-authentication and folder restore
+//   - CAPABILITY discovery, with fallbacks when MOVE or ESEARCH are missing
+//   - context.Context variants (the ...Context methods) for cancellation and deadlines
 //
 // The API is intentionally small and easy to adopt without pulling in a full
 // IMAP stack. See the README for end-to-end examples and guidance.
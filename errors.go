@@ -0,0 +1,95 @@
+package imap
+
+import (
+	"errors"
+	"strings"
+)
+
+// Sentinels for common response codes (RFC 3501, RFC 5530). Use errors.Is
+// against any error returned by the client to test for them:
+//
+//	if errors.Is(err, imap.ErrTryCreate) {
+//		_ = conn.CreateFolder(folder)
+//	}
+var (
+	ErrTryCreate            = errors.New("imap: TRYCREATE")
+	ErrAuthenticationFailed = errors.New("imap: AUTHENTICATIONFAILED")
+	ErrOverQuota            = errors.New("imap: OVERQUOTA")
+	ErrAlreadyExists        = errors.New("imap: ALREADYEXISTS")
+	ErrNonExistent          = errors.New("imap: NONEXISTENT")
+	ErrLimit                = errors.New("imap: LIMIT")
+	ErrUnavailable          = errors.New("imap: UNAVAILABLE")
+)
+
+var responseCodeErrors = map[string]error{
+	"TRYCREATE":            ErrTryCreate,
+	"AUTHENTICATIONFAILED": ErrAuthenticationFailed,
+	"OVERQUOTA":            ErrOverQuota,
+	"ALREADYEXISTS":        ErrAlreadyExists,
+	"NONEXISTENT":          ErrNonExistent,
+	"LIMIT":                ErrLimit,
+	"UNAVAILABLE":          ErrUnavailable,
+}
+
+// Error is a NO, BAD or BYE response from the server. Use errors.As to
+// inspect it:
+//
+//	var imapErr *imap.Error
+//	if errors.As(err, &imapErr) && imapErr.Status == "BAD" {
+//		// the server rejected the command syntax
+//	}
+type Error struct {
+	// Status is "NO", "BAD" or "BYE"
+	Status string
+	// Code is the bracketed response code in upper case, e.g. "TRYCREATE",
+	// or empty if the response had none
+	Code string
+	// Args holds the response code arguments verbatim, e.g. "1 100" for
+	// [APPENDUID 1 100]
+	Args string
+	// Text is the human-readable text that follows the response code
+	Text string
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString("imap command failed: ")
+	b.WriteString(e.Status)
+	if e.Code != "" {
+		b.WriteString(" [")
+		b.WriteString(e.Code)
+		if e.Args != "" {
+			b.WriteByte(' ')
+			b.WriteString(e.Args)
+		}
+		b.WriteByte(']')
+	}
+	if e.Text != "" {
+		b.WriteByte(' ')
+		b.WriteString(e.Text)
+	}
+	return b.String()
+}
+
+// Is reports whether target is the sentinel for e's response code
+func (e *Error) Is(target error) bool {
+	sentinel, ok := responseCodeErrors[e.Code]
+	return ok && sentinel == target
+}
+
+// parseStatusError builds an *Error from a status response with the tag or
+// "*" already removed, e.g. "NO [TRYCREATE] Mailbox doesn't exist\r\n".
+func parseStatusError(resp []byte) *Error {
+	status, rest, _ := strings.Cut(strings.TrimRight(string(resp), "\r\n"), " ")
+	e := &Error{Status: strings.ToUpper(status)}
+	if strings.HasPrefix(rest, "[") {
+		if end := strings.IndexByte(rest, ']'); end > 0 {
+			code, args, _ := strings.Cut(rest[1:end], " ")
+			e.Code = strings.ToUpper(code)
+			e.Args = args
+			rest = strings.TrimLeft(rest[end+1:], " ")
+		}
+	}
+	e.Text = rest
+	return e
+}
@@ -0,0 +1,101 @@
+package imap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseStatusError(t *testing.T) {
+	tests := []struct {
+		resp string
+		want Error
+	}{
+		{"NO [TRYCREATE] Mailbox doesn't exist\r\n", Error{Status: "NO", Code: "TRYCREATE", Text: "Mailbox doesn't exist"}},
+		{"BAD Command unknown\r\n", Error{Status: "BAD", Text: "Command unknown"}},
+		{"no [appenduid 1 100] odd\r\n", Error{Status: "NO", Code: "APPENDUID", Args: "1 100", Text: "odd"}},
+		{"BYE [UNAVAILABLE]\r\n", Error{Status: "BYE", Code: "UNAVAILABLE"}},
+		{"NO [broken code\r\n", Error{Status: "NO", Text: "[broken code"}},
+		{"NO", Error{Status: "NO"}},
+	}
+	for _, tt := range tests {
+		got := parseStatusError([]byte(tt.resp))
+		if *got != tt.want {
+			t.Errorf("parseStatusError(%q) = %+v, want %+v", tt.resp, *got, tt.want)
+		}
+	}
+}
+
+func TestError_Error(t *testing.T) {
+	e := &Error{Status: "NO", Code: "OVERQUOTA", Args: "x", Text: "Quota exceeded"}
+	if got, want := e.Error(), "imap command failed: NO [OVERQUOTA x] Quota exceeded"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	e = &Error{Status: "BAD", Text: "Syntax"}
+	if got, want := e.Error(), "imap command failed: BAD Syntax"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func TestError_Is(t *testing.T) {
+	for code, sentinel := range responseCodeErrors {
+		err := error(&Error{Status: "NO", Code: code})
+		if !errors.Is(err, sentinel) {
+			t.Errorf("errors.Is(%s, sentinel) = false", code)
+		}
+		if errors.Is(&Error{Status: "NO"}, sentinel) {
+			t.Errorf("error without code matched %s", code)
+		}
+	}
+	if errors.Is(&Error{Status: "NO", Code: "TRYCREATE"}, ErrOverQuota) {
+		t.Error("TRYCREATE matched ErrOverQuota")
+	}
+}
+
+func TestError_FromCommands(t *testing.T) {
+	t.Run("create folder", func(t *testing.T) {
+		d, server := setupTestDialer(t)
+		server.failCommands["CREATE"] = true
+		server.failCodes["CREATE"] = "ALREADYEXISTS"
+
+		err := d.CreateFolder("Archive")
+		if !errors.Is(err, ErrAlreadyExists) {
+			t.Fatalf("CreateFolder() error = %v, want ErrAlreadyExists", err)
+		}
+		var imapErr *Error
+		if !errors.As(err, &imapErr) || imapErr.Status != "NO" || imapErr.Text != "CREATE failed" {
+			t.Errorf("CreateFolder() error = %#v", imapErr)
+		}
+	})
+
+	t.Run("append", func(t *testing.T) {
+		d, server := setupTestDialer(t)
+		server.failCommands["APPEND"] = true
+		server.failCodes["APPEND"] = "TRYCREATE"
+
+		err := d.Append("Missing", nil, time.Time{}, []byte("Subject: x\r\n\r\nbody"))
+		if !errors.Is(err, ErrTryCreate) {
+			t.Fatalf("Append() error = %v, want ErrTryCreate", err)
+		}
+		// The connection is still in sync after the refusal
+		if _, err := d.Exec("NOOP", false, 0, nil); err != nil {
+			t.Errorf("NOOP after refused APPEND: %v", err)
+		}
+	})
+
+	t.Run("login", func(t *testing.T) {
+		server, err := newMockIMAPServer("user", "pass")
+		if err != nil {
+			t.Fatalf("failed to create mock server: %v", err)
+		}
+		defer server.Close()
+
+		cfg := newTestConfig(server)
+		cfg.Password = "wrong"
+		_, err = Dial(context.Background(), cfg)
+		if !errors.Is(err, ErrAuthenticationFailed) {
+			t.Fatalf("Dial() error = %v, want ErrAuthenticationFailed", err)
+		}
+	})
+}
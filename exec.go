@@ -3,13 +3,13 @@ package imap
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
 	"time"
 
-	retry "github.com/StirlingMarketingGroup/go-retry"
 	"github.com/rs/xid"
 )
 
@@ -45,94 +45,194 @@ func readLiterals(r *bufio.Reader, line []byte) ([]byte, error) {
 	}
 }
 
-// execOnce runs a single attempt of an IMAP command
-func (d *Dialer) execOnce(command string, buildResponse bool, processLine func(line []byte) error) (strings.Builder, error) {
+// aLongTimeAgo is a non-zero time in the past. Setting it as a connection
+// deadline unblocks any goroutine currently reading from or writing to it.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// commandDeadline returns the deadline for a single command: the earlier of
+// the connection's CommandTimeout (if set) and the context deadline (if any).
+// A zero time means no deadline.
+func (d *Dialer) commandDeadline(ctx context.Context) time.Time {
+	var deadline time.Time
+	if d.config.CommandTimeout != 0 {
+		deadline = time.Now().Add(d.config.CommandTimeout)
+	}
+	if ctxDeadline, ok := ctx.Deadline(); ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	}
+	return deadline
+}
+
+// watchContext applies the command deadline to writes on the connection and
+// arranges for an in-flight write to be interrupted when ctx is done. Reads
+// happen on the connection's reader goroutine and are bounded separately (see
+// responseReader.next). The returned function restores the connection and
+// must be called once the command has finished.
+func (d *Dialer) watchContext(ctx context.Context) (stop func()) {
+	deadline := d.commandDeadline(ctx)
+	if !deadline.IsZero() {
+		_ = d.conn.SetWriteDeadline(deadline)
+	}
+	conn := d.conn
+	stopAfter := context.AfterFunc(ctx, func() {
+		_ = conn.SetWriteDeadline(aLongTimeAgo)
+	})
+	return func() {
+		stopAfter()
+		if !deadline.IsZero() || ctx.Err() != nil {
+			_ = conn.SetWriteDeadline(time.Time{})
+		}
+	}
+}
+
+// contextError converts an I/O error caused by ctx being done into the
+// context's error. Because the command was interrupted part-way through, the
+// server may still be acting on it, so the connection is closed; the next
+// retried command (or an explicit Reconnect) reopens it.
+func (d *Dialer) contextError(ctx context.Context, err error) error {
+	ctxErr := ctx.Err()
+	if ctxErr == nil {
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			ctxErr = context.DeadlineExceeded
+		}
+	}
+	if ctxErr == nil {
+		return err
+	}
+	d.debugLog("command interrupted, closing connection", "error", ctxErr)
+	_ = d.closeLocked()
+	return fmt.Errorf("imap command interrupted: %w", ctxErr)
+}
+
+// execOnce runs a single attempt of an IMAP command. sent reports whether
+// any of the command may have reached the server, and completion is the
+// tagged OK without the tag, e.g. "OK [MODIFIED 7] done\r\n".
+func (d *Dialer) execOnce(ctx context.Context, command string, buildResponse bool, processLine func(line []byte) error) (resp strings.Builder, completion []byte, sent bool, err error) {
 	tag := []byte(strings.ToUpper(xid.New().String()))
-	var resp strings.Builder
 
-	if CommandTimeout != 0 {
-		_ = d.conn.SetDeadline(time.Now().Add(CommandTimeout))
-		defer func() { _ = d.conn.SetDeadline(time.Time{}) }()
+	if err := ctx.Err(); err != nil {
+		return resp, nil, false, err
 	}
 
+	// After STARTTLS the TLS handshake takes over the connection, so the
+	// reader must not consume anything beyond the tagged OK.
+	mode := modeBuffered
+	if strings.EqualFold(command, "STARTTLS") {
+		mode = modeStopAfterOK
+	}
+	cmd, err := d.startCommand(tag, mode)
+	if err != nil {
+		return resp, nil, false, err
+	}
+	defer d.reader.release(cmd)
+
+	stop := d.watchContext(ctx)
+	defer stop()
+	expired, stopTimer := deadlineTimer(d.commandDeadline(ctx))
+	defer stopTimer()
+
 	c := fmt.Sprintf("%s %s\r\n", tag, command)
 
-	if Verbose {
+	if d.config.Verbose {
 		sanitized := strings.ReplaceAll(strings.TrimSpace(c), fmt.Sprintf(`"%s"`, d.Password), `"****"`)
-		debugLog(d.ConnNum, d.Folder, "sending command", "command", sanitized)
-	}
-
-	if _, err := d.conn.Write([]byte(c)); err != nil {
-		return resp, err
+		d.debugLog("sending command", "command", sanitized)
 	}
 
-	r := bufio.NewReader(d.conn)
-	if buildResponse {
-		resp = strings.Builder{}
+	if n, err := d.conn.Write([]byte(c)); err != nil {
+		return resp, nil, n > 0, d.contextError(ctx, err)
 	}
 
-	var readErr error
-	var line []byte
-	for readErr == nil {
-		line, readErr = r.ReadBytes('\n')
-		var litErr error
-		line, litErr = readLiterals(r, line)
-		if litErr != nil {
-			return resp, litErr
+	for {
+		line, err := d.reader.next(ctx, cmd, expired)
+		if err != nil {
+			return resp, nil, true, d.contextError(ctx, err)
 		}
 
-		if Verbose && !SkipResponses {
-			debugLog(d.ConnNum, d.Folder, "server response", "response", string(dropNl(line)))
-		}
+		d.responseLog(line)
+		d.captureCapabilities(line)
+		d.handleUnsolicited(d.config.UnsolicitedHandler, command, line)
 
 		// XID tags are 20 uppercase base32hex characters (0-9, A-V).
 		taglen := len(tag)
 		oklen := 3
 		if len(line) >= taglen+oklen && bytes.Equal(line[:taglen], tag) {
 			if !bytes.Equal(line[taglen+1:taglen+oklen], []byte("OK")) {
-				return resp, fmt.Errorf("imap command failed: %s", line[taglen+oklen+1:])
+				return resp, nil, true, parseStatusError(line[taglen+1:])
 			}
-			break
+			return resp, line[taglen+1:], true, nil
 		}
 
 		if processLine != nil {
 			if err := processLine(line); err != nil {
-				return resp, err
+				return resp, nil, true, err
 			}
 		}
 		if buildResponse {
 			resp.Write(line)
 		}
 	}
-	return resp, readErr
 }
 
-// Exec executes an IMAP command with retry logic and response building
+// Exec executes an IMAP command with retry logic and response building.
+// The command is treated as idempotent: it is retried up to retryCount
+// times under the connection's retry policy.
 func (d *Dialer) Exec(command string, buildResponse bool, retryCount int, processLine func(line []byte) error) (response string, err error) {
+	return d.ExecContext(context.Background(), command, buildResponse, retryCount, processLine)
+}
+
+// ExecContext is like Exec but honors ctx. Cancellation or an expired
+// deadline interrupts the command even while it is blocked on the network,
+// and no further retries are attempted. An interrupted command leaves the
+// connection closed (Connected is false); call Reconnect, or issue another
+// command with retries enabled, to reopen it.
+func (d *Dialer) ExecContext(ctx context.Context, command string, buildResponse bool, retryCount int, processLine func(line []byte) error) (response string, err error) {
+	return d.execRetry(ctx, command, buildResponse, true, retryCount, processLine)
+}
+
+// exec runs command with as many retries as the connection's retry policy
+// allows. Commands that are not idempotent are retried only if they never
+// reached the server.
+func (d *Dialer) exec(ctx context.Context, command string, buildResponse bool, idempotent bool, processLine func(line []byte) error) (response string, err error) {
+	return d.execRetry(ctx, command, buildResponse, idempotent, -1, processLine)
+}
+
+// execRetry runs command under the retry policy, with at most limit retries
+// when limit is zero or more.
+func (d *Dialer) execRetry(ctx context.Context, command string, buildResponse bool, idempotent bool, limit int, processLine func(line []byte) error) (response string, err error) {
+	d.lock()
+	defer d.unlock()
+	return d.execLocked(ctx, command, buildResponse, idempotent, limit, processLine)
+}
+
+// execLocked is execRetry for callers that already hold the connection lock
+func (d *Dialer) execLocked(ctx context.Context, command string, buildResponse bool, idempotent bool, limit int, processLine func(line []byte) error) (response string, err error) {
+	response, _, err = d.execCompletionLocked(ctx, command, buildResponse, idempotent, limit, processLine)
+	return response, err
+}
+
+// execCompletionLocked is execLocked that also returns the tagged OK, for
+// commands whose result is carried in its response code
+func (d *Dialer) execCompletionLocked(ctx context.Context, command string, buildResponse bool, idempotent bool, limit int, processLine func(line []byte) error) (response string, completion []byte, err error) {
 	var resp strings.Builder
-	err = retry.Retry(func() (err error) {
-		resp, err = d.execOnce(command, buildResponse, processLine)
-		return err
-	}, retryCount, func(err error) error {
-		if Verbose {
-			warnLog(d.ConnNum, d.Folder, "command failed, closing connection", "error", err)
+	err = d.retry(ctx, idempotent, limit, func(n int) (bool, error) {
+		if n > 1 {
+			if err := d.ensureConnectedLocked(ctx); err != nil {
+				return false, err
+			}
 		}
-		_ = d.Close()
-		return nil
-	}, func() error {
-		return d.Reconnect()
+		var sent bool
+		var err error
+		resp, completion, sent, err = d.execOnce(ctx, command, buildResponse, processLine)
+		if err != nil && ClassifyError(err) == ErrorTransport && d.Connected {
+			if d.config.Verbose {
+				d.warnLog("command failed, closing connection", "error", err)
+			}
+			_ = d.closeLocked()
+		}
+		return sent, err
 	})
 	if err != nil {
-		errorLog(d.ConnNum, d.Folder, "command retries exhausted", "error", err)
-		return "", err
+		return "", nil, err
 	}
-
-	if buildResponse {
-		if resp.Len() != 0 {
-			lastResp = resp.String()
-			return lastResp, nil
-		}
-		return "", nil
-	}
-	return response, err
+	return resp.String(), completion, nil
 }
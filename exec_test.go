@@ -2,8 +2,11 @@ package imap
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestReadLiterals_NoLiteral(t *testing.T) {
@@ -80,3 +83,88 @@ func TestReadLiterals_ReadError(t *testing.T) {
 		t.Fatal("expected error for short read")
 	}
 }
+
+func TestExecContext_CanceledBeforeSend(t *testing.T) {
+	d, _ := setupTestDialer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := d.ExecContext(ctx, "NOOP", false, 3, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if !d.Connected {
+		t.Error("connection should stay open when nothing was sent")
+	}
+	if _, err := d.Exec("NOOP", false, 0, nil); err != nil {
+		t.Errorf("connection should remain usable, got %v", err)
+	}
+}
+
+func TestExecContext_DeadlineInterruptsRead(t *testing.T) {
+	d, server := setupTestDialer(t)
+	server.hangCommands["SLOW"] = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := d.ExecContext(ctx, "SLOW", true, 3, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("command was not interrupted promptly (took %v), retries may have run", elapsed)
+	}
+	if d.Connected {
+		t.Error("interrupted command should leave the connection closed")
+	}
+
+	if err := d.Reconnect(); err != nil {
+		t.Fatalf("Reconnect after interruption failed: %v", err)
+	}
+	if _, err := d.Exec("NOOP", false, 0, nil); err != nil {
+		t.Errorf("NOOP after reconnect failed: %v", err)
+	}
+}
+
+func TestExecContext_CancelInterruptsRead(t *testing.T) {
+	d, server := setupTestDialer(t)
+	server.hangCommands["SLOW"] = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	_, err := d.ExecContext(ctx, "SLOW", true, 3, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if d.Connected {
+		t.Error("interrupted command should leave the connection closed")
+	}
+}
+
+func TestNewContext_Canceled(t *testing.T) {
+	origTLS := TLSSkipVerify
+	TLSSkipVerify = true
+	t.Cleanup(func() { TLSSkipVerify = origTLS })
+
+	server, err := newMockIMAPServer("user", "pass")
+	if err != nil {
+		t.Fatalf("failed to create mock server: %v", err)
+	}
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	_, err = NewContext(ctx, "user", "pass", server.GetHost(), server.GetPort())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("canceled dial should not retry (took %v)", elapsed)
+	}
+}
@@ -0,0 +1,315 @@
+package imap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FetchBuilder constructs the data items of a FETCH command using a fluent
+// builder pattern, so that only what is needed is downloaded. UID is always
+// requested.
+//
+// Example:
+//
+//	results, err := conn.Fetch(set,
+//	    imap.Fetch().Flags().Size().HeaderFields("Subject", "From").Partial("1", 0, 1024),
+//	)
+type FetchBuilder struct {
+	items []string
+}
+
+// Fetch returns a new FetchBuilder.
+func Fetch() *FetchBuilder {
+	return &FetchBuilder{}
+}
+
+// Build returns the assembled FETCH data items, e.g. "(UID FLAGS RFC822.SIZE)".
+func (f *FetchBuilder) Build() string {
+	return "(" + strings.Join(append([]string{"UID"}, f.items...), " ") + ")"
+}
+
+// Item adds a raw data item, for items the builder has no method for.
+func (f *FetchBuilder) Item(item string) *FetchBuilder {
+	f.items = append(f.items, item)
+	return f
+}
+
+// Flags requests the message flags (FLAGS).
+func (f *FetchBuilder) Flags() *FetchBuilder { return f.Item("FLAGS") }
+
+// InternalDate requests the date the message arrived (INTERNALDATE).
+func (f *FetchBuilder) InternalDate() *FetchBuilder { return f.Item("INTERNALDATE") }
+
+// Size requests the message size in bytes (RFC822.SIZE).
+func (f *FetchBuilder) Size() *FetchBuilder { return f.Item("RFC822.SIZE") }
+
+// Envelope requests the parsed header fields (ENVELOPE).
+func (f *FetchBuilder) Envelope() *FetchBuilder { return f.Item("ENVELOPE") }
+
+// BodyStructure requests the MIME structure of the message (BODYSTRUCTURE).
+func (f *FetchBuilder) BodyStructure() *FetchBuilder { return f.Item("BODYSTRUCTURE") }
+
+// ModSeq requests the message's mod-sequence (MODSEQ, RFC 7162). It requires
+// CONDSTORE.
+func (f *FetchBuilder) ModSeq() *FetchBuilder { return f.Item("MODSEQ") }
+
+// GmailLabels requests the Gmail labels of the message (X-GM-LABELS).
+func (f *FetchBuilder) GmailLabels() *FetchBuilder { return f.Item("X-GM-LABELS") }
+
+// GmailMsgID requests Gmail's message ID (X-GM-MSGID).
+func (f *FetchBuilder) GmailMsgID() *FetchBuilder { return f.Item("X-GM-MSGID") }
+
+// GmailThreadID requests Gmail's thread ID (X-GM-THRID).
+func (f *FetchBuilder) GmailThreadID() *FetchBuilder { return f.Item("X-GM-THRID") }
+
+// Section requests a body section without setting \Seen, e.g. "" for the
+// whole message, "TEXT" or "1.2" (BODY.PEEK[section]).
+func (f *FetchBuilder) Section(section string) *FetchBuilder {
+	return f.Item("BODY.PEEK[" + section + "]")
+}
+
+// Partial requests count bytes of a body section starting at offset
+// (BODY.PEEK[section]<offset.count>). The result is keyed by the offset only,
+// e.g. "BODY[1.2]<0>".
+func (f *FetchBuilder) Partial(section string, offset, count int) *FetchBuilder {
+	return f.Item("BODY.PEEK[" + section + "]<" + strconv.Itoa(offset) + "." + strconv.Itoa(count) + ">")
+}
+
+// Header requests the whole message header (BODY.PEEK[HEADER]).
+func (f *FetchBuilder) Header() *FetchBuilder { return f.Section("HEADER") }
+
+// HeaderFields requests only the named header fields
+// (BODY.PEEK[HEADER.FIELDS (...)]).
+func (f *FetchBuilder) HeaderFields(fields ...string) *FetchBuilder {
+	return f.Section("HEADER.FIELDS (" + strings.Join(fields, " ") + ")")
+}
+
+// HeaderFieldsNot requests the header without the named fields
+// (BODY.PEEK[HEADER.FIELDS.NOT (...)]).
+func (f *FetchBuilder) HeaderFieldsNot(fields ...string) *FetchBuilder {
+	return f.Section("HEADER.FIELDS.NOT (" + strings.Join(fields, " ") + ")")
+}
+
+// Binary requests a body section with its content transfer encoding removed
+// (BINARY.PEEK[section], RFC 3516). It requires BINARY.
+func (f *FetchBuilder) Binary(section string) *FetchBuilder {
+	return f.Item("BINARY.PEEK[" + section + "]")
+}
+
+// BinarySize requests the decoded size of a body section (BINARY.SIZE[section],
+// RFC 3516). It requires BINARY.
+func (f *FetchBuilder) BinarySize(section string) *FetchBuilder {
+	return f.Item("BINARY.SIZE[" + section + "]")
+}
+
+// FetchResult holds the data items returned for one message by Fetch. Fields
+// for items that were not requested are left at their zero value.
+type FetchResult struct {
+	SeqNum       int
+	UID          int
+	Flags        []string
+	InternalDate time.Time
+	Size         uint64
+	// Envelope holds the ENVELOPE fields: Sent, Subject, the addresses and
+	// MessageID
+	Envelope *Email
+	// BodyStructure is the parsed BODYSTRUCTURE
+	BodyStructure *BodyStructure
+	ModSeq        uint64
+	// GmailLabels, GmailMsgID and GmailThreadID are Gmail's X-GM-LABELS,
+	// X-GM-MSGID and X-GM-THRID
+	GmailLabels   []string
+	GmailMsgID    uint64
+	GmailThreadID uint64
+	// BinarySizes holds BINARY.SIZE results keyed by section
+	BinarySizes map[string]uint64
+	// Sections holds body sections keyed by the name the server returned
+	// them under, in upper case, e.g. "BODY[HEADER]", "BODY[1.2]<0>" or
+	// "BINARY[1]". A NIL section is present with a nil value.
+	Sections map[string][]byte
+	// Items holds the other data items, such as those added with
+	// FetchBuilder.Item, keyed by name in upper case
+	Items map[string]*Token
+}
+
+// Section returns the body section requested with FetchBuilder.Section,
+// Header, HeaderFields or Binary, e.g. Section("HEADER") or Section("1.2").
+// Partial sections are in Sections under their offset.
+func (r *FetchResult) Section(section string) []byte {
+	section = strings.ToUpper(section)
+	if b, ok := r.Sections["BODY["+section+"]"]; ok {
+		return b
+	}
+	return r.Sections["BINARY["+section+"]"]
+}
+
+// Fetch retrieves the data items built by items for the messages in set,
+// keyed by UID. A set too long for the connection's MaxLineLength is fetched
+// with several commands, and literal values are read as they arrive.
+//
+// Example:
+//
+//	results, err := conn.Fetch(set, imap.Fetch().Size().HeaderFields("Subject"))
+//	for uid, r := range results {
+//	    fmt.Println(uid, r.Size, string(r.Section("HEADER.FIELDS (SUBJECT)")))
+//	}
+func (d *Dialer) Fetch(set UIDSet, items *FetchBuilder) (map[int]*FetchResult, error) {
+	return d.FetchContext(context.Background(), set, items)
+}
+
+// FetchContext is like Fetch but honors ctx
+func (d *Dialer) FetchContext(ctx context.Context, set UIDSet, items *FetchBuilder) (map[int]*FetchResult, error) {
+	results := make(map[int]*FetchResult)
+	if set.IsEmpty() {
+		return results, nil
+	}
+	query := items.Build()
+	collect := func(m *FetchMessage) error {
+		r, err := d.parseFetchResult(m)
+		if err != nil {
+			return err
+		}
+		if r.UID != 0 {
+			results[r.UID] = r
+		}
+		return nil
+	}
+
+	d.lock()
+	defer d.unlock()
+	for _, chunk := range d.splitUIDs(set, len("UID FETCH  ")+len(query)) {
+		if err := d.fetchStreamLocked(ctx, "UID FETCH "+chunk.String()+" "+query, true, collect); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// parseFetchResult reads the items of m into a FetchResult
+func (d *Dialer) parseFetchResult(m *FetchMessage) (*FetchResult, error) {
+	r := &FetchResult{SeqNum: m.SeqNum}
+	for {
+		item, err := m.Next()
+		if err == io.EOF {
+			return r, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if item.Body != nil {
+			b, err := io.ReadAll(item.Body)
+			if err != nil {
+				return nil, err
+			}
+			r.setSection(item.Name, b)
+			continue
+		}
+		if item.Value == nil {
+			continue
+		}
+		if err := d.parseFetchItem(r, item); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseFetchItem stores a data item that is not a literal in r
+func (d *Dialer) parseFetchItem(r *FetchResult, item *FetchItem) (err error) {
+	v := item.Value
+	switch name := item.Name; {
+	case name == "UID":
+		if err = d.CheckType(v, []TType{TNumber}, nil, "after UID"); err != nil {
+			return err
+		}
+		r.UID = v.Num
+	case name == "FLAGS":
+		r.Flags, err = d.fetchStrings(v, name)
+	case name == "INTERNALDATE":
+		if err = d.CheckType(v, []TType{TQuoted}, nil, "after INTERNALDATE"); err != nil {
+			return err
+		}
+		r.InternalDate, err = parseInternalDate(v.Str)
+	case name == "RFC822.SIZE":
+		if err = d.CheckType(v, []TType{TNumber}, nil, "after RFC822.SIZE"); err != nil {
+			return err
+		}
+		r.Size = uint64(v.Num)
+	case name == "ENVELOPE":
+		r.Envelope = &Email{}
+		err = d.parseEnvelope(r.Envelope, v, nil)
+	case name == "BODYSTRUCTURE", name == "BODY":
+		r.BodyStructure, err = d.ParseBodyStructure(v)
+	case name == "MODSEQ":
+		// MODSEQ (12345)
+		if err = d.CheckType(v, []TType{TContainer}, nil, "after MODSEQ"); err != nil {
+			return err
+		}
+		if len(v.Tokens) == 0 {
+			return fmt.Errorf("IMAP%d:%s: empty MODSEQ", d.ConnNum, d.Folder)
+		}
+		if err = d.CheckType(v.Tokens[0], []TType{TNumber}, nil, "in MODSEQ"); err != nil {
+			return err
+		}
+		r.ModSeq = uint64(v.Tokens[0].Num)
+	case name == "X-GM-LABELS":
+		r.GmailLabels, err = d.fetchStrings(v, name)
+	case name == "X-GM-MSGID", name == "X-GM-THRID":
+		if err = d.CheckType(v, []TType{TNumber}, nil, "after %s", name); err != nil {
+			return err
+		}
+		if name == "X-GM-MSGID" {
+			r.GmailMsgID = uint64(v.Num)
+		} else {
+			r.GmailThreadID = uint64(v.Num)
+		}
+	case strings.HasPrefix(name, "BINARY.SIZE["):
+		if err = d.CheckType(v, []TType{TNumber}, nil, "after %s", name); err != nil {
+			return err
+		}
+		if r.BinarySizes == nil {
+			r.BinarySizes = make(map[string]uint64)
+		}
+		r.BinarySizes[strings.TrimSuffix(strings.TrimPrefix(name, "BINARY.SIZE["), "]")] = uint64(v.Num)
+	case strings.HasPrefix(name, "BODY["), strings.HasPrefix(name, "BINARY["):
+		switch v.Type {
+		case TNil:
+			r.setSection(name, nil)
+		case TQuoted, TAtom:
+			r.setSection(name, []byte(v.Str))
+		default:
+			return d.CheckType(v, []TType{TQuoted, TAtom, TNil}, nil, "after %s", name)
+		}
+	default:
+		if r.Items == nil {
+			r.Items = make(map[string]*Token)
+		}
+		r.Items[name] = v
+	}
+	return err
+}
+
+// setSection stores the body section name
+func (r *FetchResult) setSection(name string, b []byte) {
+	if r.Sections == nil {
+		r.Sections = make(map[string][]byte)
+	}
+	r.Sections[name] = b
+}
+
+// fetchStrings returns the strings in a list such as FLAGS or X-GM-LABELS
+func (d *Dialer) fetchStrings(v *Token, name string) ([]string, error) {
+	if err := d.CheckType(v, []TType{TContainer}, nil, "after %s", name); err != nil {
+		return nil, err
+	}
+	s := make([]string, len(v.Tokens))
+	for i, t := range v.Tokens {
+		if err := d.CheckType(t, []TType{TLiteral, TQuoted, TAtom}, nil, "for %s[%d]", name, i); err != nil {
+			return nil, err
+		}
+		s[i] = t.Str
+	}
+	return s, nil
+}
@@ -0,0 +1,465 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+var (
+	// errLiteralClosed is returned when a FetchItem's Body is read after the
+	// message has moved on
+	errLiteralClosed = errors.New("imap fetch: body read after the next item was requested")
+	// errMessageClosed is returned when a FetchMessage is used after the
+	// callback it was passed to returned
+	errMessageClosed = errors.New("imap fetch: message used after its callback returned")
+)
+
+// FetchItem is one data item of a streamed FETCH response
+type FetchItem struct {
+	// Name is the upper-case item name, e.g. "UID", "FLAGS" or
+	// "BODY[HEADER.FIELDS (SUBJECT)]"
+	Name string
+	// Value is the parsed value. It is nil when the server sent the value as
+	// a literal, which is then available from Body.
+	Value *Token
+	// Body reads a value sent as a literal, typically BODY[...], BINARY[...]
+	// or RFC822 data, straight from the connection. It is valid until the
+	// next call to FetchMessage.Next; whatever was not read is discarded.
+	Body io.Reader
+	// Size is the length of Body in bytes
+	Size int64
+}
+
+// FetchMessage is the FETCH response for one message, read item by item as
+// it arrives from the server
+type FetchMessage struct {
+	// SeqNum is the message sequence number the response is for
+	SeqNum int
+
+	s     *fetchStream
+	items []*FetchItem // parsed items not yet returned
+	text  []byte       // response text not yet parsed into items
+	depth int          // parenthesis depth at the end of text
+	lit   *literal     // literal following text, nil at the end of the response
+	body  *literal     // literal being read through a FetchItem's Body
+	done  bool
+	err   error
+}
+
+// fetchStream is the state of one streamed FETCH command
+type fetchStream struct {
+	d        *Dialer
+	ctx      context.Context
+	cmd      *pendingCommand
+	expired  <-chan time.Time
+	deadline time.Time
+	ioErr    error // set when the connection can no longer be used
+	unwatch  func() bool
+}
+
+// FetchStream runs "UID FETCH set items" and calls fn for each message as its
+// response arrives, without holding more than the item being parsed in
+// memory. Literal values such as message bodies are read straight from the
+// connection through FetchItem.Body:
+//
+//	all, _ := imap.ParseUIDSet("1:*")
+//	err := conn.FetchStream(all, "(UID BODY.PEEK[])", func(m *imap.FetchMessage) error {
+//		for {
+//			item, err := m.Next()
+//			if err == io.EOF {
+//				return nil
+//			} else if err != nil {
+//				return err
+//			}
+//			if item.Body != nil {
+//				// e.g. io.Copy(file, item.Body)
+//			}
+//		}
+//	})
+//
+// Items fn does not read are skipped. If fn returns an error, FetchStream
+// returns it and the rest of the response is discarded. Untagged responses
+// other than FETCH are handled as for any other command. A set too long for
+// the connection's MaxLineLength is fetched with several commands.
+func (d *Dialer) FetchStream(set UIDSet, items string, fn func(m *FetchMessage) error) error {
+	return d.FetchStreamContext(context.Background(), set, items, fn)
+}
+
+// FetchStreamContext is like FetchStream but honors ctx. Because fn may have
+// acted on some messages, a failed fetch is retried only if it never reached
+// the server.
+func (d *Dialer) FetchStreamContext(ctx context.Context, set UIDSet, items string, fn func(m *FetchMessage) error) error {
+	d.lock()
+	defer d.unlock()
+	for _, chunk := range d.splitUIDs(set, len("UID FETCH  ")+len(items)) {
+		if chunk.IsEmpty() {
+			continue
+		}
+		if err := d.fetchStreamLocked(ctx, "UID FETCH "+chunk.String()+" "+items, false, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchStreamLocked runs a streamed FETCH command under the retry policy; the
+// caller holds the connection lock
+func (d *Dialer) fetchStreamLocked(ctx context.Context, command string, idempotent bool, fn func(m *FetchMessage) error) error {
+	return d.retry(ctx, idempotent, -1, func(n int) (bool, error) {
+		if n > 1 {
+			if err := d.ensureConnectedLocked(ctx); err != nil {
+				return false, err
+			}
+		}
+		return d.fetchStreamOnce(ctx, command, fn)
+	})
+}
+
+// fetchStreamOnce runs a single attempt of a streamed FETCH command. Unlike
+// execOnce it closes a connection it found broken itself, since an error
+// returned by fn says nothing about the connection.
+func (d *Dialer) fetchStreamOnce(ctx context.Context, command string, fn func(m *FetchMessage) error) (sent bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	tag := []byte(strings.ToUpper(xid.New().String()))
+	cmd, err := d.startCommand(tag, modeStream)
+	if err != nil {
+		return false, err
+	}
+	defer d.reader.release(cmd)
+
+	stop := d.watchContext(ctx)
+	defer stop()
+	s := &fetchStream{d: d, ctx: ctx, cmd: cmd, deadline: d.commandDeadline(ctx)}
+	var stopTimer func()
+	s.expired, stopTimer = deadlineTimer(s.deadline)
+	defer stopTimer()
+
+	d.debugLog("sending command", "command", string(tag)+" "+command)
+	if n, err := fmt.Fprintf(d.conn, "%s %s\r\n", tag, command); err != nil {
+		return n > 0, s.fail(err)
+	}
+
+	for {
+		resp, err := s.next()
+		if err != nil {
+			return true, s.fail(err)
+		}
+		line := resp.line
+
+		if len(line) > len(tag) && bytes.HasPrefix(line, tag) && line[len(tag)] == ' ' {
+			if status := line[len(tag)+1:]; !hasPrefixFold(status, "OK") {
+				return true, parseStatusError(status)
+			}
+			return true, nil
+		}
+
+		seq, rest, ok := fetchResponse(line)
+		if !ok {
+			if resp.literal != nil {
+				// Some other response carrying a literal; read it in whole
+				if line, err = s.readAll(resp); err != nil {
+					return true, s.fail(err)
+				}
+			}
+			d.captureCapabilities(line)
+			d.handleUnsolicited(d.config.UnsolicitedHandler, command, line)
+			continue
+		}
+
+		m := &FetchMessage{SeqNum: seq, s: s, text: rest, depth: scanDepth(rest, 0), lit: resp.literal}
+		err = fn(m)
+		if err == nil {
+			err = m.skip()
+		}
+		m.close()
+		if s.ioErr != nil {
+			return true, s.fail(s.ioErr)
+		}
+		if err != nil {
+			return true, err
+		}
+	}
+}
+
+// next returns the next response piece routed to the command
+func (s *fetchStream) next() (response, error) {
+	resp, err := s.d.reader.nextResponse(s.ctx, s.cmd, s.expired)
+	if err != nil {
+		s.ioErr = err
+		return resp, err
+	}
+	s.d.responseLog(resp.line)
+	return resp, nil
+}
+
+// fail closes the connection after an I/O error and returns the error to
+// report for it
+func (s *fetchStream) fail(err error) error {
+	err = s.d.contextError(s.ctx, err)
+	if ClassifyError(err) == ErrorTransport && s.d.Connected {
+		if s.d.config.Verbose {
+			s.d.warnLog("fetch failed, closing connection", "error", err)
+		}
+		_ = s.d.closeLocked()
+	}
+	return err
+}
+
+// open prepares a literal to be read on the caller's goroutine: reads are
+// bounded by the command deadline and interrupted when ctx is done.
+func (s *fetchStream) open() {
+	conn := s.d.conn
+	if !s.deadline.IsZero() {
+		_ = conn.SetReadDeadline(s.deadline)
+	}
+	stopAfter := context.AfterFunc(s.ctx, func() {
+		_ = conn.SetReadDeadline(aLongTimeAgo)
+	})
+	s.unwatch = func() bool {
+		interrupted := !stopAfter()
+		if !s.deadline.IsZero() || interrupted {
+			_ = conn.SetReadDeadline(time.Time{})
+		}
+		return !interrupted
+	}
+}
+
+// done hands lit back to the reader once the caller has finished with it
+func (s *fetchStream) done(lit *literal) {
+	if s.unwatch != nil && !s.unwatch() && s.ioErr == nil {
+		s.ioErr = s.ctx.Err()
+	}
+	s.unwatch = nil
+	if lit.err != nil && s.ioErr == nil {
+		s.ioErr = lit.err
+	}
+	lit.close()
+}
+
+// readAll reads the rest of a response that started with resp, with its
+// literals inline
+func (s *fetchStream) readAll(resp response) ([]byte, error) {
+	line := resp.line
+	for resp.literal != nil {
+		s.open()
+		data, err := io.ReadAll(resp.literal)
+		s.done(resp.literal)
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, data...)
+		if resp, err = s.next(); err != nil {
+			return nil, err
+		}
+		line = append(line, resp.line...)
+	}
+	return line, nil
+}
+
+// Next returns the message's next data item, or io.EOF after the last one.
+// It invalidates the Body of the item returned before.
+func (m *FetchMessage) Next() (*FetchItem, error) {
+	for len(m.items) == 0 {
+		if m.err != nil {
+			return nil, m.err
+		}
+		if m.done {
+			return nil, io.EOF
+		}
+		m.err = m.advance()
+	}
+	item := m.items[0]
+	m.items = m.items[1:]
+	return item, nil
+}
+
+// advance parses the response up to the next item whose value is streamed,
+// or to its end
+func (m *FetchMessage) advance() error {
+	if m.body != nil {
+		m.s.done(m.body)
+		m.body = nil
+		if err := m.read(); err != nil {
+			return err
+		}
+	}
+	for {
+		if m.lit == nil {
+			// The response is complete; drop its closing parenthesis
+			text := bytes.TrimSuffix(bytes.TrimRight(m.text, "\r\n"), []byte(")"))
+			items, err := fetchItems(text)
+			if err != nil {
+				return err
+			}
+			if len(items) > 0 && items[len(items)-1].Value == nil {
+				return fmt.Errorf("imap fetch: %s without a value", items[len(items)-1].Name)
+			}
+			m.items, m.text, m.done = items, nil, true
+			return nil
+		}
+
+		if m.depth == 0 {
+			// An item's value: hand the literal over as the item's Body
+			// BINARY answers with a literal8, ~{n} (RFC 3516)
+			loc := atom.FindIndex(dropNl(m.text))
+			items, err := fetchItems(bytes.TrimSuffix(m.text[:loc[0]], []byte("~")))
+			if err != nil {
+				return err
+			}
+			if len(items) == 0 || items[len(items)-1].Value != nil {
+				return fmt.Errorf("imap fetch: literal without an item name in %q", m.text)
+			}
+			size, _ := strconv.ParseInt(strings.TrimSuffix(string(m.text[loc[0]+1:loc[1]-1]), "+"), 10, 64)
+			item := items[len(items)-1]
+			item.Body, item.Size = m.lit, size
+			m.s.open()
+			m.items, m.text, m.body, m.lit = items, nil, m.lit, nil
+			return nil
+		}
+
+		// Part of a structured value, e.g. a subject within ENVELOPE
+		m.s.open()
+		data, err := io.ReadAll(m.lit)
+		m.s.done(m.lit)
+		if err != nil {
+			return err
+		}
+		m.text = append(m.text, data...)
+		if err := m.read(); err != nil {
+			return err
+		}
+	}
+}
+
+// read appends the next piece of the response to the unparsed text
+func (m *FetchMessage) read() error {
+	resp, err := m.s.next()
+	if err != nil {
+		return err
+	}
+	m.text = append(m.text, resp.line...)
+	m.depth = scanDepth(resp.line, m.depth)
+	m.lit = resp.literal
+	return nil
+}
+
+// skip reads the items the callback did not read
+func (m *FetchMessage) skip() error {
+	for {
+		if _, err := m.Next(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// close hands a literal still open back to the reader
+func (m *FetchMessage) close() {
+	for _, lit := range []*literal{m.body, m.lit} {
+		if lit != nil {
+			m.s.done(lit)
+		}
+	}
+	m.body, m.lit = nil, nil
+	if m.err == nil && !m.done {
+		m.err = errMessageClosed
+	}
+}
+
+// fetchResponse parses the start of a "* n FETCH (" response, returning the
+// sequence number and the text after the opening parenthesis
+func fetchResponse(line []byte) (seq int, rest []byte, ok bool) {
+	data, found := bytes.CutPrefix(line, []byte("* "))
+	if !found {
+		return 0, nil, false
+	}
+	num, data, found := bytes.Cut(data, []byte(" "))
+	seq, err := strconv.Atoi(string(num))
+	if !found || err != nil || !hasPrefixFold(data, "FETCH (") {
+		return 0, nil, false
+	}
+	return seq, data[len("FETCH ("):], true
+}
+
+// scanDepth returns the parenthesis depth at the end of line, starting from
+// depth and skipping quoted strings
+func scanDepth(line []byte, depth int) int {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		}
+	}
+	return depth
+}
+
+// fetchItems pairs the tokens of FETCH response text into items. The last
+// item has no Value if text ends with its name.
+func fetchItems(text []byte) ([]*FetchItem, error) {
+	tks, err := parseFetchTokens(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("imap fetch: %w", err)
+	}
+	var items []*FetchItem
+	for i := 0; i < len(tks); i++ {
+		if tks[i].Type != TLiteral {
+			return nil, fmt.Errorf("imap fetch: expected an item name, got %s", tks[i])
+		}
+		name := tks[i].Str
+		// A section with a field list, e.g. BODY[HEADER.FIELDS (SUBJECT)],
+		// is split into several tokens
+		for strings.Contains(name, "[") && !strings.Contains(name, "]") && i+1 < len(tks) {
+			i++
+			part := tokenText(tks[i])
+			if !strings.HasPrefix(part, "]") {
+				name += " "
+			}
+			name += part
+		}
+		item := &FetchItem{Name: strings.ToUpper(name)}
+		if i+1 < len(tks) {
+			i++
+			item.Value = tks[i]
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// tokenText formats a token the way it appears in a response
+func tokenText(t *Token) string {
+	switch t.Type {
+	case TNumber:
+		return strconv.Itoa(t.Num)
+	case TQuoted:
+		return `"` + AddSlashes.Replace(t.Str) + `"`
+	case TNil:
+		return "NIL"
+	case TContainer:
+		parts := make([]string, len(t.Tokens))
+		for i, c := range t.Tokens {
+			parts[i] = tokenText(c)
+		}
+		return "(" + strings.Join(parts, " ") + ")"
+	}
+	return t.Str
+}
@@ -0,0 +1,249 @@
+package imap
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchStream_Items(t *testing.T) {
+	d, server := setupTestDialer(t)
+	server.responses["UID FETCH 1:* (UID FLAGS BODY.PEEK[HEADER.FIELDS (SUBJECT)] BODY.PEEK[] X-TEST)"] = "" +
+		"* 1 FETCH (UID 7 FLAGS (\\Seen) BODY[HEADER.FIELDS (SUBJECT)] {16}\r\nSubject: (hi\r\n\r\n" +
+		" X-TEST (\"a\" {4}\r\nb)(c \"d)\") BODY[] {11}\r\nhello world)\r\n" +
+		"* 5 EXISTS\r\n" +
+		"* 2 FETCH (BODY[] {6}\r\nsecond UID 8)\r\n"
+
+	type message struct {
+		seq   int
+		items []string
+	}
+	var got []message
+	all := UIDSet{}
+	all.AddRange(1, 0)
+	err := d.FetchStream(all, "(UID FLAGS BODY.PEEK[HEADER.FIELDS (SUBJECT)] BODY.PEEK[] X-TEST)", func(m *FetchMessage) error {
+		msg := message{seq: m.SeqNum}
+		for {
+			item, err := m.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+			switch {
+			case item.Body != nil && item.Name == "BODY[]" && m.SeqNum == 2:
+				// Read part of the body only; the rest is skipped
+				buf := make([]byte, 3)
+				if _, err := io.ReadFull(item.Body, buf); err != nil {
+					return err
+				}
+				msg.items = append(msg.items, fmt.Sprintf("%s %d %q", item.Name, item.Size, buf))
+				if _, err := m.Next(); err != nil {
+					return err
+				}
+				if _, err := item.Body.Read(buf); !errors.Is(err, errLiteralClosed) {
+					t.Errorf("Body.Read() after Next() error = %v", err)
+				}
+				msg.items = append(msg.items, "UID 8")
+			case item.Body != nil:
+				body, err := io.ReadAll(item.Body)
+				if err != nil {
+					return err
+				}
+				msg.items = append(msg.items, fmt.Sprintf("%s %d %q", item.Name, item.Size, body))
+			default:
+				msg.items = append(msg.items, item.Name+" "+tokenText(item.Value))
+			}
+		}
+		got = append(got, msg)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("FetchStream() error = %v", err)
+	}
+
+	want := []message{
+		{1, []string{
+			"UID 7",
+			`FLAGS (\Seen)`,
+			`BODY[HEADER.FIELDS (SUBJECT)] 16 "Subject: (hi\r\n\r\n"`,
+			`X-TEST ("a" b)(c "d)")`,
+			`BODY[] 11 "hello world"`,
+		}},
+		{2, []string{`BODY[] 6 "sec"`, "UID 8"}},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got  %v\nwant %v", got, want)
+	}
+	if !d.Connected {
+		t.Error("connection should stay open")
+	}
+}
+
+func TestFetchStream_CallbackError(t *testing.T) {
+	d, server := setupTestDialer(t)
+	server.responses["UID FETCH 1:2 (BODY.PEEK[])"] = "" +
+		"* 1 FETCH (BODY[] {5}\r\nfirst)\r\n" +
+		"* 2 FETCH (BODY[] {6}\r\nsecond)\r\n"
+
+	errStop := errors.New("stop")
+	calls := 0
+	err := d.FetchStream(UIDSetNum(1, 2), "(BODY.PEEK[])", func(m *FetchMessage) error {
+		calls++
+		if _, err := m.Next(); err != nil {
+			return err
+		}
+		return errStop
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("FetchStream() error = %v, want the callback's error", err)
+	}
+	if calls != 1 {
+		t.Errorf("callback called %d times after failing", calls)
+	}
+	if !d.Connected {
+		t.Fatal("a callback error should not close the connection")
+	}
+	if _, err := d.Exec("NOOP", false, 0, nil); err != nil {
+		t.Errorf("NOOP after the discarded response: %v", err)
+	}
+}
+
+// stallingServer starts a FETCH response with a literal and never finishes it
+func stallingServer(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		_, _ = io.WriteString(server, "* OK [CAPABILITY IMAP4rev1] ready\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			tag, command, _ := strings.Cut(strings.TrimSpace(line), " ")
+			if commandName(command) == "UID FETCH" {
+				_, _ = io.WriteString(server, "* 1 FETCH (UID 1 BODY[] {100}\r\nonly part")
+				continue
+			}
+			_, _ = fmt.Fprintf(server, "%s OK done\r\n", tag)
+		}
+	}()
+	return client, nil
+}
+
+func TestFetchStreamContext_CancelWhileReadingBody(t *testing.T) {
+	t.Parallel()
+	d, err := Dial(context.Background(), Config{
+		Host:        "imap.example.test",
+		Port:        143,
+		Username:    "user",
+		Password:    "pass",
+		Transport:   TransportInsecure,
+		DialContext: stallingServer,
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var read []byte
+	err = d.FetchStreamContext(ctx, UIDSetNum(1), "(BODY.PEEK[])", func(m *FetchMessage) error {
+		for {
+			item, err := m.Next()
+			if err != nil {
+				return err
+			}
+			if item.Body != nil {
+				read, err = io.ReadAll(item.Body)
+				return err
+			}
+		}
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("FetchStreamContext() error = %v, want context.DeadlineExceeded", err)
+	}
+	if string(read) != "only part" {
+		t.Errorf("read %q before the deadline", read)
+	}
+	if d.Connected {
+		t.Error("an interrupted fetch should close the connection")
+	}
+}
+
+func TestGetEmails_Streamed(t *testing.T) {
+	d, server := setupTestDialer(t)
+	server.responses["UID FETCH 7 ALL"] = "* 1 FETCH (UID 7 FLAGS (\\Seen) " +
+		"INTERNALDATE \" 9-Apr-2026 17:06:19 -0400\" RFC822.SIZE 60 " +
+		"ENVELOPE (\"Thu, 9 Apr 2026 21:06:17 +0000\" \"Hello\" " +
+		"((\"Ann\" NIL \"ann\" \"example.com\")) NIL NIL " +
+		"((NIL NIL \"bob\" \"example.com\")) NIL NIL NIL \"<1@example.com>\"))\r\n"
+	body := "From: Ann <ann@example.com>\r\nTo: bob@example.com\r\nSubject: Hello\r\n\r\nHi Bob\r\n"
+	server.responses["UID FETCH 7 BODY.PEEK[]"] = fmt.Sprintf("* 1 FETCH (UID 7 BODY[] {%d}\r\n%s)\r\n", len(body), body)
+
+	emails, err := d.GetEmails(7)
+	if err != nil {
+		t.Fatalf("GetEmails() error = %v", err)
+	}
+	e := emails[7]
+	if e == nil {
+		t.Fatalf("GetEmails() = %v, want UID 7", emails)
+	}
+	if e.Subject != "Hello" || strings.TrimSpace(e.Text) != "Hi Bob" {
+		t.Errorf("Subject = %q, Text = %q", e.Subject, e.Text)
+	}
+	if _, ok := e.From["ann@example.com"]; !ok {
+		t.Errorf("From = %v", e.From)
+	}
+	if len(e.Header) != 3 || e.Header.Get("To") != "bob@example.com" {
+		t.Errorf("Header = %q", e.Header)
+	}
+}
+
+func TestGetEmails_MalformedBodyNotRetried(t *testing.T) {
+	d, server := setupTestDialer(t)
+	server.responses["UID FETCH 7 ALL"] = overviewResponse(7)
+	server.responses["UID FETCH 7 BODY.PEEK[]"] = "* 1 FETCH (UID seven BODY[] {2}\r\nhi)\r\n"
+
+	emails, err := d.GetEmails(7)
+	if err == nil || ClassifyError(err) != ErrorProtocol {
+		t.Fatalf("GetEmails() error = %v, want a protocol error", err)
+	}
+	if emails[7] == nil || emails[7].Subject != "message 7" {
+		t.Errorf("GetEmails() = %v, want the overview of UID 7", emails)
+	}
+	if n := countCommands(server, "UID FETCH 7 BODY.PEEK[]"); n != 1 {
+		t.Errorf("body fetched %d times, want 1", n)
+	}
+	if !d.Connected || server.GetAuthAttempts() != 1 {
+		t.Errorf("Connected = %v, logins = %d; want the connection kept", d.Connected, server.GetAuthAttempts())
+	}
+}
+
+func TestFetchItems(t *testing.T) {
+	t.Parallel()
+	items, err := fetchItems([]byte(`UID 4 BODY[HEADER.FIELDS (SUBJECT "X-A")] NIL BODY[]<0> `))
+	if err != nil {
+		t.Fatalf("fetchItems() error = %v", err)
+	}
+	var got []string
+	for _, it := range items {
+		s := it.Name
+		if it.Value != nil {
+			s += "=" + tokenText(it.Value)
+		}
+		got = append(got, s)
+	}
+	want := `[UID=4 BODY[HEADER.FIELDS (SUBJECT "X-A")]=NIL BODY[]<0>]`
+	if fmt.Sprint(got) != want {
+		t.Errorf("fetchItems() = %v, want %s", got, want)
+	}
+}
@@ -0,0 +1,74 @@
+package imap
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestFetchBuilder_Build(t *testing.T) {
+	t.Parallel()
+	got := Fetch().Flags().Size().HeaderFields("Subject", "From").Partial("1.2", 0, 1024).
+		Binary("1").BinarySize("1").ModSeq().GmailLabels().Item("X-CUSTOM").Build()
+	want := "(UID FLAGS RFC822.SIZE BODY.PEEK[HEADER.FIELDS (Subject From)] BODY.PEEK[1.2]<0.1024> " +
+		"BINARY.PEEK[1] BINARY.SIZE[1] MODSEQ X-GM-LABELS X-CUSTOM)"
+	if got != want {
+		t.Errorf("Build() = %q\nwant       %q", got, want)
+	}
+	if got := Fetch().Build(); got != "(UID)" {
+		t.Errorf("empty Build() = %q", got)
+	}
+}
+
+func TestFetch_Results(t *testing.T) {
+	d, server := setupTestDialer(t)
+	items := Fetch().Flags().InternalDate().Size().Envelope().ModSeq().GmailLabels().GmailMsgID().
+		HeaderFields("SUBJECT").Partial("1", 0, 5).BinarySize("1").BodyStructure()
+	server.responses["UID FETCH 7:8 "+items.Build()] = "" +
+		"* 1 FETCH (UID 7 FLAGS (\\Seen $Work) INTERNALDATE \" 9-Apr-2026 17:06:19 -0400\" RFC822.SIZE 1234 " +
+		"ENVELOPE (\"Thu, 9 Apr 2026 21:06:17 +0000\" \"Hi\" ((\"Ann\" NIL \"ann\" \"example.com\")) NIL NIL NIL NIL NIL NIL \"<1@example.com>\") " +
+		"MODSEQ (624140003) X-GM-LABELS (\\Inbox \"My Label\") X-GM-MSGID 1278455344230334865 " +
+		"BODY[HEADER.FIELDS (SUBJECT)] {15}\r\nSubject: Hi\r\n\r\n" +
+		" BODY[1]<0> {5}\r\nHello BINARY.SIZE[1] 42 BODYSTRUCTURE (\"TEXT\" \"PLAIN\" NIL NIL NIL \"7BIT\" 5 1))\r\n" +
+		"* 2 FETCH (UID 8 FLAGS () BODY[HEADER.FIELDS (SUBJECT)] NIL)\r\n"
+
+	results, err := d.Fetch(UIDSetNum(7, 8), items)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	r := results[7]
+	if r == nil || results[8] == nil {
+		t.Fatalf("Fetch() = %v, want UIDs 7 and 8", results)
+	}
+	if r.SeqNum != 1 || !slices.Equal(r.Flags, []string{`\Seen`, "$Work"}) || r.Size != 1234 ||
+		r.InternalDate.Unix() != 1775768779 || r.ModSeq != 624140003 || r.GmailMsgID != 1278455344230334865 {
+		t.Errorf("result = %+v", r)
+	}
+	if !slices.Equal(r.GmailLabels, []string{`\Inbox`, "My Label"}) {
+		t.Errorf("GmailLabels = %q", r.GmailLabels)
+	}
+	if r.Envelope == nil || r.Envelope.Subject != "Hi" || r.Envelope.MessageID != "<1@example.com>" ||
+		r.Envelope.From["ann@example.com"] != "Ann" {
+		t.Errorf("Envelope = %+v", r.Envelope)
+	}
+	if got := string(r.Section("header.fields (subject)")); got != "Subject: Hi\r\n\r\n" {
+		t.Errorf("Section() = %q", got)
+	}
+	if got := string(r.Sections["BODY[1]<0>"]); got != "Hello" {
+		t.Errorf("partial section = %q", got)
+	}
+	if r.BinarySizes["1"] != 42 {
+		t.Errorf("BinarySizes = %v", r.BinarySizes)
+	}
+	if bs := r.BodyStructure; bs == nil || bs.MediaType() != "text/plain" || bs.Path != "1" || bs.Lines != 1 {
+		t.Errorf("BodyStructure = %+v", bs)
+	}
+
+	empty := results[8]
+	if b, ok := empty.Sections["BODY[HEADER.FIELDS (SUBJECT)]"]; !ok || b != nil {
+		t.Errorf("NIL section = %q, %v", b, ok)
+	}
+	if fmt.Sprint(empty.Flags) != "[]" {
+		t.Errorf("Flags = %q", empty.Flags)
+	}
+}
@@ -2,6 +2,7 @@ package imap
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -15,13 +16,23 @@ type FolderStats struct {
 	Error  error
 }
 
-// GetFolders retrieves the list of available folders
+// GetFolders retrieves the list of available folders. Names are decoded from
+// modified UTF-7, e.g. "Корзина" rather than "&BBoEPgRABDcEOAQ9BDA-", unless
+// UTF8=ACCEPT is enabled, in which case the server sends them as UTF-8. A
+// name that is not valid modified UTF-7 is returned as listed, and passing it
+// back to other methods sends it unchanged.
 func (d *Dialer) GetFolders() (folders []string, err error) {
+	return d.GetFoldersContext(context.Background())
+}
+
+// GetFoldersContext is like GetFolders but honors ctx
+func (d *Dialer) GetFoldersContext(ctx context.Context) (folders []string, err error) {
 	folders = make([]string, 0)
-	_, err = d.Exec(`LIST "" "*"`, false, RetryCount, func(line []byte) (err error) {
+	raw := make(map[string]bool)
+	_, err = d.exec(ctx, `LIST "" "*"`, false, true, func(line []byte) (err error) {
 		line = dropNl(line)
 		if b := bytes.IndexByte(line, '\n'); b != -1 {
-			folders = append(folders, string(line[b+1:]))
+			folders = append(folders, d.folderName(string(line[b+1:]), raw))
 		} else {
 			if len(line) == 0 {
 				return err
@@ -42,7 +53,7 @@ func (d *Dialer) GetFolders() (folders []string, err error) {
 				}
 				i--
 			}
-			folders = append(folders, RemoveSlashes.Replace(string(line[i+1:end+1])))
+			folders = append(folders, d.folderName(RemoveSlashes.Replace(string(line[i+1:end+1])), raw))
 		}
 		return err
 	})
@@ -50,34 +61,98 @@ func (d *Dialer) GetFolders() (folders []string, err error) {
 		return nil, err
 	}
 
+	// Replace the names remembered from the previous listing. A raw name
+	// that is also the decoded form of another listed name is sent encoded,
+	// as the standard form is the one the name refers to.
+	d.rawMailboxes.Clear()
+	for name, isRaw := range raw {
+		if isRaw {
+			d.rawMailboxes.Store(name, struct{}{})
+		}
+	}
 	return folders, nil
 }
 
+// quoteMailbox returns folder as a quoted mailbox argument, encoded in
+// modified UTF-7 unless UTF8=ACCEPT is enabled or the last GetFolders listed
+// it in another form
+func (d *Dialer) quoteMailbox(folder string) string {
+	if _, raw := d.rawMailboxes.Load(folder); !raw && !d.utf8Mailboxes.Load() {
+		folder = EncodeMailboxName(folder)
+	}
+	return `"` + AddSlashes.Replace(folder) + `"`
+}
+
+// quoteNewMailbox is quoteMailbox for the name of a mailbox being created,
+// which is always encoded: it cannot be one the server listed raw.
+func (d *Dialer) quoteNewMailbox(folder string) string {
+	if !d.utf8Mailboxes.Load() {
+		folder = EncodeMailboxName(folder)
+	}
+	return `"` + AddSlashes.Replace(folder) + `"`
+}
+
+// folderName decodes a mailbox name sent by the server. A name that is not
+// valid modified UTF-7, as some servers send raw UTF-8 or a bare "&", is kept
+// as is. raw records each returned name and whether it was kept raw, so that
+// GetFolders can have quoteMailbox send those back unchanged.
+func (d *Dialer) folderName(mailbox string, raw map[string]bool) string {
+	if d.utf8Mailboxes.Load() {
+		return mailbox
+	}
+	if name, err := DecodeMailboxName(mailbox); err == nil {
+		raw[name] = false
+		return name
+	}
+	if _, listed := raw[mailbox]; !listed {
+		raw[mailbox] = true
+	}
+	return mailbox
+}
+
 // ExamineFolder selects a folder in read-only mode
 func (d *Dialer) ExamineFolder(folder string) (err error) {
-	_, err = d.Exec(`EXAMINE "`+AddSlashes.Replace(folder)+`"`, true, RetryCount, nil)
-	if err != nil {
-		return err
-	}
-	d.Folder = folder
-	d.ReadOnly = true
-	return nil
+	return d.ExamineFolderContext(context.Background(), folder)
+}
+
+// ExamineFolderContext is like ExamineFolder but honors ctx
+func (d *Dialer) ExamineFolderContext(ctx context.Context, folder string) (err error) {
+	d.lock()
+	defer d.unlock()
+	return d.selectLocked(ctx, folder, true, -1)
 }
 
 // SelectFolder selects a folder in read-write mode
 func (d *Dialer) SelectFolder(folder string) (err error) {
-	_, err = d.Exec(`SELECT "`+AddSlashes.Replace(folder)+`"`, true, RetryCount, nil)
-	if err != nil {
+	return d.SelectFolderContext(context.Background(), folder)
+}
+
+// SelectFolderContext is like SelectFolder but honors ctx
+func (d *Dialer) SelectFolderContext(ctx context.Context, folder string) (err error) {
+	d.lock()
+	defer d.unlock()
+	return d.selectLocked(ctx, folder, false, -1)
+}
+
+// selectLocked runs SELECT, or EXAMINE when readOnly is set, and records the
+// folder. The caller holds the connection lock; limit caps retries as in
+// execLocked.
+func (d *Dialer) selectLocked(ctx context.Context, folder string, readOnly bool, limit int) error {
+	command := "SELECT"
+	if readOnly {
+		command = "EXAMINE"
+	}
+	if _, err := d.execLocked(ctx, command+" "+d.quoteMailbox(folder), true, true, limit, nil); err != nil {
 		return err
 	}
 	d.Folder = folder
-	d.ReadOnly = false
+	d.ReadOnly = readOnly
 	return nil
 }
 
 // selectAndGetCount executes SELECT command and extracts message count from EXISTS response
-func (d *Dialer) selectAndGetCount(folder string) (int, error) {
-	r, err := d.Exec("SELECT \""+AddSlashes.Replace(folder)+"\"", true, RetryCount, nil)
+func (d *Dialer) selectAndGetCount(ctx context.Context, folder string) (int, error) {
+	r, err := d.exec(ctx, "SELECT "+d.quoteMailbox(folder), true, true, nil)
 	if err != nil {
 		return 0, err
 	}
@@ -95,9 +170,15 @@ func (d *Dialer) selectAndGetCount(folder string) (int, error) {
 }
 
 // CreateFolder creates a new mailbox with the given name.
-// This command is not retried because CREATE is not idempotent.
+// CREATE is not idempotent, so it is retried only if it never reached the
+// server.
 func (d *Dialer) CreateFolder(name string) error {
-	_, err := d.Exec(`CREATE "`+AddSlashes.Replace(name)+`"`, false, 0, nil)
+	return d.CreateFolderContext(context.Background(), name)
+}
+
+// CreateFolderContext is like CreateFolder but honors ctx
+func (d *Dialer) CreateFolderContext(ctx context.Context, name string) error {
+	_, err := d.exec(ctx, "CREATE "+d.quoteNewMailbox(name), false, false, nil)
 	if err != nil {
 		return fmt.Errorf("imap create folder: %w", err)
 	}
@@ -106,12 +187,21 @@ func (d *Dialer) CreateFolder(name string) error {
 
 // DeleteFolder permanently removes a mailbox.
 // If the deleted folder is currently selected, the folder state is cleared.
-// This command is not retried because DELETE is not idempotent.
+// DELETE is not idempotent, so it is retried only if it never reached the
+// server.
 func (d *Dialer) DeleteFolder(name string) error {
-	_, err := d.Exec(`DELETE "`+AddSlashes.Replace(name)+`"`, false, 0, nil)
+	return d.DeleteFolderContext(context.Background(), name)
+}
+
+// DeleteFolderContext is like DeleteFolder but honors ctx
+func (d *Dialer) DeleteFolderContext(ctx context.Context, name string) error {
+	d.lock()
+	defer d.unlock()
+	_, err := d.execLocked(ctx, "DELETE "+d.quoteMailbox(name), false, false, -1, nil)
 	if err != nil {
 		return fmt.Errorf("imap delete folder: %w", err)
 	}
+	d.rawMailboxes.Delete(name)
 	if d.Folder == name {
 		d.Folder = ""
 		d.ReadOnly = false
@@ -121,12 +211,21 @@ func (d *Dialer) DeleteFolder(name string) error {
 
 // RenameFolder renames a mailbox from oldName to newName.
 // If the renamed folder is currently selected, the tracked folder name is updated.
-// This command is not retried because RENAME is not idempotent.
+// RENAME is not idempotent, so it is retried only if it never reached the
+// server.
 func (d *Dialer) RenameFolder(oldName, newName string) error {
-	_, err := d.Exec(`RENAME "`+AddSlashes.Replace(oldName)+`" "`+AddSlashes.Replace(newName)+`"`, false, 0, nil)
+	return d.RenameFolderContext(context.Background(), oldName, newName)
+}
+
+// RenameFolderContext is like RenameFolder but honors ctx
+func (d *Dialer) RenameFolderContext(ctx context.Context, oldName, newName string) error {
+	d.lock()
+	defer d.unlock()
+	_, err := d.execLocked(ctx, "RENAME "+d.quoteMailbox(oldName)+" "+d.quoteNewMailbox(newName), false, false, -1, nil)
 	if err != nil {
 		return fmt.Errorf("imap rename folder: %w", err)
 	}
+	d.rawMailboxes.Delete(oldName)
 	if d.Folder == oldName {
 		d.Folder = newName
 	}
@@ -135,52 +234,104 @@ func (d *Dialer) RenameFolder(oldName, newName string) error {
 
 // GetTotalEmailCount returns the total email count across all folders
 func (d *Dialer) GetTotalEmailCount() (count int, err error) {
-	return d.GetTotalEmailCountStartingFromExcluding("", nil)
+	return d.GetTotalEmailCountStartingFromExcludingContext(context.Background(), "", nil)
+}
+
+// GetTotalEmailCountContext is like GetTotalEmailCount but honors ctx
+func (d *Dialer) GetTotalEmailCountContext(ctx context.Context) (count int, err error) {
+	return d.GetTotalEmailCountStartingFromExcludingContext(ctx, "", nil)
 }
 
 // GetTotalEmailCountExcluding returns total email count excluding specified folders
 func (d *Dialer) GetTotalEmailCountExcluding(excludedFolders []string) (count int, err error) {
-	return d.GetTotalEmailCountStartingFromExcluding("", excludedFolders)
+	return d.GetTotalEmailCountStartingFromExcludingContext(context.Background(), "", excludedFolders)
+}
+
+// GetTotalEmailCountExcludingContext is like GetTotalEmailCountExcluding but honors ctx
+func (d *Dialer) GetTotalEmailCountExcludingContext(ctx context.Context, excludedFolders []string) (count int, err error) {
+	return d.GetTotalEmailCountStartingFromExcludingContext(ctx, "", excludedFolders)
 }
 
 // GetTotalEmailCountStartingFrom returns total email count starting from a specific folder
 func (d *Dialer) GetTotalEmailCountStartingFrom(startFolder string) (count int, err error) {
-	return d.GetTotalEmailCountStartingFromExcluding(startFolder, nil)
+	return d.GetTotalEmailCountStartingFromExcludingContext(context.Background(), startFolder, nil)
+}
+
+// GetTotalEmailCountStartingFromContext is like GetTotalEmailCountStartingFrom but honors ctx
+func (d *Dialer) GetTotalEmailCountStartingFromContext(ctx context.Context, startFolder string) (count int, err error) {
+	return d.GetTotalEmailCountStartingFromExcludingContext(ctx, startFolder, nil)
 }
 
 // GetTotalEmailCountSafe returns total email count with error handling per folder
 func (d *Dialer) GetTotalEmailCountSafe() (count int, folderErrors []error, err error) {
-	return d.GetTotalEmailCountSafeStartingFromExcluding("", nil)
+	return d.GetTotalEmailCountSafeStartingFromExcludingContext(context.Background(), "", nil)
+}
+
+// GetTotalEmailCountSafeContext is like GetTotalEmailCountSafe but honors ctx
+func (d *Dialer) GetTotalEmailCountSafeContext(ctx context.Context) (count int, folderErrors []error, err error) {
+	return d.GetTotalEmailCountSafeStartingFromExcludingContext(ctx, "", nil)
 }
 
 // GetTotalEmailCountSafeExcluding returns total email count excluding folders with error handling
 func (d *Dialer) GetTotalEmailCountSafeExcluding(excludedFolders []string) (count int, folderErrors []error, err error) {
-	return d.GetTotalEmailCountSafeStartingFromExcluding("", excludedFolders)
+	return d.GetTotalEmailCountSafeStartingFromExcludingContext(context.Background(), "", excludedFolders)
+}
+
+// GetTotalEmailCountSafeExcludingContext is like GetTotalEmailCountSafeExcluding but honors ctx
+func (d *Dialer) GetTotalEmailCountSafeExcludingContext(ctx context.Context, excludedFolders []string) (count int, folderErrors []error, err error) {
+	return d.GetTotalEmailCountSafeStartingFromExcludingContext(ctx, "", excludedFolders)
 }
 
 // GetTotalEmailCountSafeStartingFrom returns total email count starting from folder with error handling
 func (d *Dialer) GetTotalEmailCountSafeStartingFrom(startFolder string) (count int, folderErrors []error, err error) {
-	return d.GetTotalEmailCountSafeStartingFromExcluding(startFolder, nil)
+	return d.GetTotalEmailCountSafeStartingFromExcludingContext(context.Background(), startFolder, nil)
+}
+
+// GetTotalEmailCountSafeStartingFromContext is like GetTotalEmailCountSafeStartingFrom but honors ctx
+func (d *Dialer) GetTotalEmailCountSafeStartingFromContext(ctx context.Context, startFolder string) (count int, folderErrors []error, err error) {
+	return d.GetTotalEmailCountSafeStartingFromExcludingContext(ctx, startFolder, nil)
 }
 
 // GetFolderStats returns statistics for all folders
 func (d *Dialer) GetFolderStats() ([]FolderStats, error) {
-	return d.GetFolderStatsStartingFromExcluding("", nil)
+	return d.GetFolderStatsStartingFromExcludingContext(context.Background(), "", nil)
+}
+
+// GetFolderStatsContext is like GetFolderStats but honors ctx
+func (d *Dialer) GetFolderStatsContext(ctx context.Context) ([]FolderStats, error) {
+	return d.GetFolderStatsStartingFromExcludingContext(ctx, "", nil)
 }
 
 // GetFolderStatsExcluding returns statistics for folders excluding specified ones
 func (d *Dialer) GetFolderStatsExcluding(excludedFolders []string) ([]FolderStats, error) {
-	return d.GetFolderStatsStartingFromExcluding("", excludedFolders)
+	return d.GetFolderStatsStartingFromExcludingContext(context.Background(), "", excludedFolders)
+}
+
+// GetFolderStatsExcludingContext is like GetFolderStatsExcluding but honors ctx
+func (d *Dialer) GetFolderStatsExcludingContext(ctx context.Context, excludedFolders []string) ([]FolderStats, error) {
+	return d.GetFolderStatsStartingFromExcludingContext(ctx, "", excludedFolders)
 }
 
 // GetFolderStatsStartingFrom returns statistics for folders starting from a specific one
 func (d *Dialer) GetFolderStatsStartingFrom(startFolder string) ([]FolderStats, error) {
-	return d.GetFolderStatsStartingFromExcluding(startFolder, nil)
+	return d.GetFolderStatsStartingFromExcludingContext(context.Background(), startFolder, nil)
+}
+
+// GetFolderStatsStartingFromContext is like GetFolderStatsStartingFrom but honors ctx
+func (d *Dialer) GetFolderStatsStartingFromContext(ctx context.Context, startFolder string) ([]FolderStats, error) {
+	return d.GetFolderStatsStartingFromExcludingContext(ctx, startFolder, nil)
 }
 
 // GetTotalEmailCountStartingFromExcluding returns total email count with options for starting folder and exclusions
 func (d *Dialer) GetTotalEmailCountStartingFromExcluding(startFolder string, excludedFolders []string) (count int, err error) {
-	folders, err := d.GetFolders()
+	return d.GetTotalEmailCountStartingFromExcludingContext(context.Background(), startFolder, excludedFolders)
+}
+
+// GetTotalEmailCountStartingFromExcludingContext is like GetTotalEmailCountStartingFromExcluding but honors ctx.
+// If ctx is done before every folder has been visited, the partial result is
+// returned together with the context's error.
+func (d *Dialer) GetTotalEmailCountStartingFromExcludingContext(ctx context.Context, startFolder string, excludedFolders []string) (count int, err error) {
+	folders, err := d.GetFoldersContext(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -195,6 +346,9 @@ func (d *Dialer) GetTotalEmailCountStartingFromExcluding(startFolder string, exc
 	currentReadOnly := d.ReadOnly
 
 	for _, folder := range folders {
+		if ctx.Err() != nil {
+			break
+		}
 		if !startFound {
 			if folder == startFolder {
 				startFound = true
@@ -207,7 +361,7 @@ func (d *Dialer) GetTotalEmailCountStartingFromExcluding(startFolder string, exc
 			continue
 		}
 
-		folderCount, err := d.selectAndGetCount(folder)
+		folderCount, err := d.selectAndGetCount(ctx, folder)
 		if err == nil {
 			count += folderCount
 		}
@@ -216,18 +370,25 @@ func (d *Dialer) GetTotalEmailCountStartingFromExcluding(startFolder string, exc
 	// Restore original folder state
 	if currentFolder != "" {
 		if currentReadOnly {
-			_ = d.ExamineFolder(currentFolder)
+			_ = d.ExamineFolderContext(ctx, currentFolder)
 		} else {
-			_ = d.SelectFolder(currentFolder)
+			_ = d.SelectFolderContext(ctx, currentFolder)
 		}
 	}
 
-	return count, nil
+	return count, ctx.Err()
 }
 
 // GetTotalEmailCountSafeStartingFromExcluding returns total email count with per-folder error handling
 func (d *Dialer) GetTotalEmailCountSafeStartingFromExcluding(startFolder string, excludedFolders []string) (count int, folderErrors []error, err error) {
-	folders, err := d.GetFolders()
+	return d.GetTotalEmailCountSafeStartingFromExcludingContext(context.Background(), startFolder, excludedFolders)
+}
+
+// GetTotalEmailCountSafeStartingFromExcludingContext is like GetTotalEmailCountSafeStartingFromExcluding but honors ctx.
+// If ctx is done before every folder has been visited, the partial result is
+// returned together with the context's error.
+func (d *Dialer) GetTotalEmailCountSafeStartingFromExcludingContext(ctx context.Context, startFolder string, excludedFolders []string) (count int, folderErrors []error, err error) {
+	folders, err := d.GetFoldersContext(ctx)
 	if err != nil {
 		return 0, nil, err
 	}
@@ -242,6 +403,9 @@ func (d *Dialer) GetTotalEmailCountSafeStartingFromExcluding(startFolder string,
 	currentReadOnly := d.ReadOnly
 
 	for _, folder := range folders {
+		if ctx.Err() != nil {
+			break
+		}
 		if !startFound {
 			if folder == startFolder {
 				startFound = true
@@ -254,7 +418,7 @@ func (d *Dialer) GetTotalEmailCountSafeStartingFromExcluding(startFolder string,
 			continue
 		}
 
-		folderCount, folderErr := d.selectAndGetCount(folder)
+		folderCount, folderErr := d.selectAndGetCount(ctx, folder)
 		if folderErr != nil {
 			folderErrors = append(folderErrors, fmt.Errorf("folder %s: %w", folder, folderErr))
 			continue
@@ -265,18 +429,25 @@ func (d *Dialer) GetTotalEmailCountSafeStartingFromExcluding(startFolder string,
 	// Restore original folder state
 	if currentFolder != "" {
 		if currentReadOnly {
-			_ = d.ExamineFolder(currentFolder)
+			_ = d.ExamineFolderContext(ctx, currentFolder)
 		} else {
-			_ = d.SelectFolder(currentFolder)
+			_ = d.SelectFolderContext(ctx, currentFolder)
 		}
 	}
 
-	return count, folderErrors, nil
+	return count, folderErrors, ctx.Err()
 }
 
 // GetFolderStatsStartingFromExcluding returns detailed statistics for folders with options
 func (d *Dialer) GetFolderStatsStartingFromExcluding(startFolder string, excludedFolders []string) ([]FolderStats, error) {
-	folders, err := d.GetFolders()
+	return d.GetFolderStatsStartingFromExcludingContext(context.Background(), startFolder, excludedFolders)
+}
+
+// GetFolderStatsStartingFromExcludingContext is like GetFolderStatsStartingFromExcluding but honors ctx.
+// If ctx is done before every folder has been visited, the partial result is
+// returned together with the context's error.
+func (d *Dialer) GetFolderStatsStartingFromExcludingContext(ctx context.Context, startFolder string, excludedFolders []string) ([]FolderStats, error) {
+	folders, err := d.GetFoldersContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -293,6 +464,9 @@ func (d *Dialer) GetFolderStatsStartingFromExcluding(startFolder string, exclude
 	var stats []FolderStats
 
 	for _, folder := range folders {
+		if ctx.Err() != nil {
+			break
+		}
 		if !startFound {
 			if folder == startFolder {
 				startFound = true
@@ -308,7 +482,7 @@ func (d *Dialer) GetFolderStatsStartingFromExcluding(startFolder string, exclude
 		stat := FolderStats{Name: folder}
 
 		// Get message count using helper function
-		count, err := d.selectAndGetCount(folder)
+		count, err := d.selectAndGetCount(ctx, folder)
 		if err != nil {
 			stat.Error = err
 			stats = append(stats, stat)
@@ -318,7 +492,7 @@ func (d *Dialer) GetFolderStatsStartingFromExcluding(startFolder string, exclude
 
 		// Get highest UID
 		if stat.Count > 0 {
-			uidResponse, err := d.Exec("UID SEARCH ALL", true, RetryCount, nil)
+			uidResponse, err := d.exec(ctx, "UID SEARCH ALL", true, true, nil)
 			if err == nil {
 				uids, err := parseUIDSearchResponse(uidResponse)
 				if err == nil && len(uids) > 0 {
@@ -333,11 +507,11 @@ func (d *Dialer) GetFolderStatsStartingFromExcluding(startFolder string, exclude
 	// Restore original folder state
 	if currentFolder != "" {
 		if currentReadOnly {
-			_ = d.ExamineFolder(currentFolder)
+			_ = d.ExamineFolderContext(ctx, currentFolder)
 		} else {
-			_ = d.SelectFolder(currentFolder)
+			_ = d.SelectFolderContext(ctx, currentFolder)
 		}
 	}
 
-	return stats, nil
+	return stats, ctx.Err()
 }
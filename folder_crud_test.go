@@ -1,6 +1,7 @@
 package imap
 
 import (
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -293,3 +294,113 @@ func TestAppend(t *testing.T) {
 		}
 	})
 }
+
+func TestFolderNames_ModifiedUTF7(t *testing.T) {
+	d, server := setupTestDialer(t)
+	server.responses[`LIST "" "*"`] = "* LIST (\\HasNoChildren) \"/\" INBOX\r\n" +
+		"* LIST (\\HasNoChildren) \"/\" \"&BBoEPgRABDcEOAQ9BDA-\"\r\n" +
+		"* LIST () \"/\" \"R&-D\"\r\n" +
+		"* LIST () \"/\" \"Entwürfe\"\r\n"
+
+	folders, err := d.GetFolders()
+	if err != nil {
+		t.Fatalf("GetFolders() error = %v", err)
+	}
+	if want := []string{"INBOX", "Корзина", "R&D", "Entwürfe"}; strings.Join(folders, "|") != strings.Join(want, "|") {
+		t.Errorf("GetFolders() = %q, want %q", folders, want)
+	}
+
+	if err := d.SelectFolder("Корзина"); err != nil {
+		t.Fatalf("SelectFolder() error = %v", err)
+	}
+	if d.Folder != "Корзина" {
+		t.Errorf("Folder = %q, want the decoded name", d.Folder)
+	}
+	if err := d.RenameFolder("R&D", "Bücher"); err != nil {
+		t.Fatalf("RenameFolder() error = %v", err)
+	}
+	if err := d.CopyEmail(1, "Корзина"); err != nil {
+		t.Fatalf("CopyEmail() error = %v", err)
+	}
+	if err := d.Append("Корзина", nil, time.Time{}, []byte("Subject: x\r\n\r\n")); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	for _, want := range []string{
+		`SELECT "&BBoEPgRABDcEOAQ9BDA-"`,
+		`RENAME "R&-D" "B&APw-cher"`,
+		`UID COPY 1 "&BBoEPgRABDcEOAQ9BDA-"`,
+		`APPEND "&BBoEPgRABDcEOAQ9BDA-" {14}`,
+	} {
+		if countCommands(server, want) != 1 {
+			t.Errorf("command %q not sent; got %q", want, server.Commands())
+		}
+	}
+}
+
+func TestFolderNames_RawRoundTrip(t *testing.T) {
+	d, server := setupTestDialer(t)
+	server.responses[`LIST "" "*"`] = "* LIST () \"/\" \"A&B\"\r\n" +
+		"* LIST () \"/\" \"Entwürfe\"\r\n"
+
+	folders, err := d.GetFolders()
+	if err != nil {
+		t.Fatalf("GetFolders() error = %v", err)
+	}
+	for _, folder := range folders {
+		if err := d.SelectFolder(folder); err != nil {
+			t.Fatalf("SelectFolder(%q) error = %v", folder, err)
+		}
+	}
+	if err := d.CreateFolder("Entwürfe/2026"); err != nil {
+		t.Fatalf("CreateFolder() error = %v", err)
+	}
+
+	for _, want := range []string{`SELECT "A&B"`, `SELECT "Entwürfe"`, `CREATE "Entw&APw-rfe/2026"`} {
+		if countCommands(server, want) != 1 {
+			t.Errorf("command %q not sent; got %q", want, server.Commands())
+		}
+	}
+}
+
+func TestFolderNames_RawNamesForgotten(t *testing.T) {
+	d, server := setupTestDialer(t)
+	server.responses[`LIST "" "*"`] = "* LIST () \"/\" \"Entwürfe\"\r\n" +
+		"* LIST () \"/\" \"Café\"\r\n" +
+		"* LIST () \"/\" \"Caf&AOk-\"\r\n" +
+		"* LIST () \"/\" \"Résumé\"\r\n"
+
+	d.rawMailboxes.Store("Ünlisted", struct{}{})
+	if _, err := d.GetFolders(); err != nil {
+		t.Fatalf("GetFolders() error = %v", err)
+	}
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"select unlisted", func() error { return d.SelectFolder("Ünlisted") }},
+		{"select listed twice", func() error { return d.SelectFolder("Café") }},
+		{"rename", func() error { return d.RenameFolder("Entwürfe", "Entwürfe") }},
+		{"select renamed", func() error { return d.SelectFolder("Entwürfe") }},
+		{"delete", func() error { return d.DeleteFolder("Résumé") }},
+		{"select deleted", func() error { return d.SelectFolder("Résumé") }},
+	}
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+	}
+
+	want := []string{
+		`SELECT "&ANw-nlisted"`,
+		`SELECT "Caf&AOk-"`,
+		`RENAME "Entwürfe" "Entw&APw-rfe"`,
+		`SELECT "Entw&APw-rfe"`,
+		`DELETE "Résumé"`,
+		`SELECT "R&AOk-sum&AOk-"`,
+	}
+	cmds := server.Commands()
+	if got := cmds[len(cmds)-len(want):]; !slices.Equal(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
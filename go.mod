@@ -3,13 +3,13 @@ module github.com/BrianLeishman/go-imap
 go 1.26.1
 
 require (
-	github.com/StirlingMarketingGroup/go-retry v0.0.0-20190512160921-94a8eb23e893
 	github.com/davecgh/go-spew v1.1.1
 	github.com/dustin/go-humanize v1.0.1
 	github.com/jhillyerd/enmime/v2 v2.3.0
 	github.com/rs/xid v1.6.0
 	github.com/sqs/go-xoauth2 v0.0.0-20120917012134-0911dad68e56
 	golang.org/x/net v0.53.0
+	golang.org/x/text v0.36.0
 )
 
 require (
@@ -31,5 +31,4 @@ require (
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/ssor/bom v0.0.0-20170718123548-6386211fdfcf // indirect
 	golang.org/x/sys v0.43.0 // indirect
-	golang.org/x/text v0.36.0 // indirect
 )
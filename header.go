@@ -0,0 +1,145 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"mime"
+	"sort"
+	"strings"
+)
+
+// HeaderField is one field of a message header, such as "List-Id"
+type HeaderField struct {
+	// Name is the field name as it appears in the message
+	Name string
+	// Value is the unfolded value with RFC 2047 encoded words decoded
+	Value string
+}
+
+// Header holds the fields of a message header in the order they appear. A
+// field may occur more than once, e.g. Received.
+type Header []HeaderField
+
+// Get returns the value of the first field called name, compared without
+// regard to case, or "" if there is none
+func (h Header) Get(name string) string {
+	for _, f := range h {
+		if strings.EqualFold(f.Name, name) {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Values returns the values of all fields called name, compared without
+// regard to case, in order
+func (h Header) Values(name string) []string {
+	var values []string
+	for _, f := range h {
+		if strings.EqualFold(f.Name, name) {
+			values = append(values, f.Value)
+		}
+	}
+	return values
+}
+
+// Has reports whether the header has a field called name
+func (h Header) Has(name string) bool {
+	for _, f := range h {
+		if strings.EqualFold(f.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MessageIDs returns the message IDs in the first field called name, such as
+// References or In-Reply-To, in order
+func (h Header) MessageIDs(name string) []string {
+	return parseMessageIDs(h.Get(name))
+}
+
+// ParseHeader parses a message header, up to the first empty line. Folded
+// values are unfolded and RFC 2047 encoded words decoded; a value that cannot
+// be decoded is kept as is. Lines that are not fields are skipped.
+func ParseHeader(b []byte) Header {
+	var h Header
+	for line := range bytes.Lines(b) {
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			break
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			// A continuation of the previous field
+			if len(h) > 0 {
+				h[len(h)-1].Value += string(line)
+			}
+			continue
+		}
+		name, value, ok := bytes.Cut(line, []byte(":"))
+		name = bytes.TrimSpace(name)
+		if !ok || len(name) == 0 {
+			continue
+		}
+		h = append(h, HeaderField{Name: string(name), Value: string(value)})
+	}
+
+	dec := mime.WordDecoder{CharsetReader: CharsetReader}
+	for i := range h {
+		h[i].Value = strings.TrimSpace(h[i].Value)
+		if decoded, err := dec.DecodeHeader(h[i].Value); err == nil {
+			h[i].Value = decoded
+		}
+	}
+	return h
+}
+
+// Header returns the parsed header section requested with FetchBuilder.Header,
+// HeaderFields or HeaderFieldsNot, or nil if there is none
+func (r *FetchResult) Header() Header {
+	// The server may echo the field list differently than it was requested,
+	// e.g. in another case, so the section is found by its prefix
+	names := make([]string, 0, len(r.Sections))
+	for name := range r.Sections {
+		if strings.HasPrefix(name, "BODY[HEADER") {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	return ParseHeader(r.Sections[names[0]])
+}
+
+// FetchHeaders retrieves the headers of the messages in set, keyed by UID,
+// without their bodies. With fields, only those fields are fetched
+// (BODY.PEEK[HEADER.FIELDS (...)]); otherwise the whole header is. A message
+// with none of the fields has an empty Header.
+//
+// Example:
+//
+//	headers, err := conn.FetchHeaders(set, "List-Id", "Authentication-Results")
+//	for uid, h := range headers {
+//	    fmt.Println(uid, h.Get("List-Id"))
+//	}
+func (d *Dialer) FetchHeaders(set UIDSet, fields ...string) (map[int]Header, error) {
+	return d.FetchHeadersContext(context.Background(), set, fields...)
+}
+
+// FetchHeadersContext is like FetchHeaders but honors ctx
+func (d *Dialer) FetchHeadersContext(ctx context.Context, set UIDSet, fields ...string) (map[int]Header, error) {
+	items := Fetch().Header()
+	if len(fields) > 0 {
+		items = Fetch().HeaderFields(fields...)
+	}
+	results, err := d.FetchContext(ctx, set, items)
+	if err != nil {
+		return nil, err
+	}
+	headers := make(map[int]Header, len(results))
+	for uid, r := range results {
+		headers[uid] = r.Header()
+	}
+	return headers, nil
+}
@@ -0,0 +1,90 @@
+package imap
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseHeader(t *testing.T) {
+	t.Parallel()
+	h := ParseHeader([]byte("Received: from a\r\n" +
+		"List-Id: Go Nuts\r\n <golang-nuts.googlegroups.com>\r\n" +
+		"Subject: =?UTF-8?Q?caf=C3=A9?= time\r\n" +
+		"not a field\r\n" +
+		"received:\tfrom b\r\n" +
+		"X-Bad: =?x-unknown?Q?abc?=\r\n" +
+		"\r\n" +
+		"Body: no\r\n"))
+
+	var names []string
+	for _, f := range h {
+		names = append(names, f.Name)
+	}
+	if want := []string{"Received", "List-Id", "Subject", "received", "X-Bad"}; !slices.Equal(names, want) {
+		t.Errorf("names = %q, want %q", names, want)
+	}
+	if got := h.Get("LIST-ID"); got != "Go Nuts <golang-nuts.googlegroups.com>" {
+		t.Errorf("folded List-Id = %q", got)
+	}
+	if got := h.Get("Subject"); got != "café time" {
+		t.Errorf("encoded Subject = %q", got)
+	}
+	if got := h.Values("Received"); !slices.Equal(got, []string{"from a", "from b"}) {
+		t.Errorf("Values(Received) = %q", got)
+	}
+	if h.Has("Body") || h.Get("Body") != "" {
+		t.Error("fields after the empty line were parsed")
+	}
+
+	// LF line endings are accepted too
+	if h := ParseHeader([]byte("A: 1\nB: 2\n\nC: 3\n")); len(h) != 2 || h.Get("b") != "2" {
+		t.Errorf("LF header = %q", h)
+	}
+}
+
+func TestHeaderRecorder(t *testing.T) {
+	t.Parallel()
+	msg := "Subject: Hi\r\nX-A: 1\r\n\r\nBody\r\n\r\nMore\r\n"
+	// One byte at a time, so the empty line straddles reads
+	r := &headerRecorder{r: iotest.OneByteReader(strings.NewReader(msg))}
+	buf := make([]byte, 64)
+	for {
+		if _, err := r.Read(buf); err != nil {
+			break
+		}
+	}
+	if got := string(r.buf); got != "Subject: Hi\r\nX-A: 1\r\n" {
+		t.Errorf("recorded %q", got)
+	}
+}
+
+func TestFetchHeaders(t *testing.T) {
+	d, server := setupTestDialer(t)
+	server.responses[`UID FETCH 7:8 (UID BODY.PEEK[HEADER.FIELDS (List-Id X-Original-To)])`] = "" +
+		"* 1 FETCH (UID 7 BODY[HEADER.FIELDS (\"LIST-ID\" \"X-ORIGINAL-TO\")] {43}\r\n" +
+		"List-Id: <a.b>\r\nX-Original-To: me@x.org\r\n\r\n)\r\n" +
+		"* 2 FETCH (UID 8 BODY[HEADER.FIELDS (LIST-ID X-ORIGINAL-TO)] {2}\r\n\r\n)\r\n"
+
+	headers, err := d.FetchHeaders(UIDSetNum(7, 8), "List-Id", "X-Original-To")
+	if err != nil {
+		t.Fatalf("FetchHeaders() error = %v", err)
+	}
+	if h := headers[7]; h.Get("List-Id") != "<a.b>" || h.Get("X-Original-To") != "me@x.org" {
+		t.Errorf("headers[7] = %q", h)
+	}
+	if h, ok := headers[8]; !ok || len(h) != 0 {
+		t.Errorf("headers[8] = %q, %v", h, ok)
+	}
+
+	server.responses["UID FETCH 7 (UID BODY.PEEK[HEADER])"] = "* 1 FETCH (UID 7 BODY[HEADER] {13}\r\nSubject: Hi\r\n)\r\n"
+	headers, err = d.FetchHeaders(UIDSetNum(7))
+	if err != nil {
+		t.Fatalf("FetchHeaders() error = %v", err)
+	}
+	if fmt.Sprint(headers[7]) != "[{Subject Hi}]" {
+		t.Errorf("whole header = %q", headers[7])
+	}
+}
@@ -2,12 +2,16 @@ package imap
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
+
+	"github.com/rs/xid"
 )
 
 // Connection state constants
@@ -25,6 +29,7 @@ const (
 	IdleEventExists  = "EXISTS"
 	IdleEventExpunge = "EXPUNGE"
 	IdleEventFetch   = "FETCH"
+	IdleEventRecent  = "RECENT"
 )
 
 // ExistsEvent represents an EXISTS event from IDLE
@@ -44,11 +49,35 @@ type FetchEvent struct {
 	Flags        []string
 }
 
-// IdleHandler provides callbacks for IDLE events
+// RecentEvent represents a RECENT response: the number of messages with the
+// \Recent flag
+type RecentEvent struct {
+	Count int
+}
+
+// AlertEvent represents an [ALERT] response code. RFC 3501 requires the text
+// to be presented to the user.
+type AlertEvent struct {
+	Text string
+}
+
+// ByeEvent represents a BYE response: the server is closing the connection
+type ByeEvent struct {
+	// Code is the response code, e.g. "UNAVAILABLE", or empty
+	Code string
+	Text string
+}
+
+// IdleHandler provides callbacks for IDLE events. Set it as
+// Config.UnsolicitedHandler to receive the same events while other commands
+// run. Callbacks are invoked on their own goroutines.
 type IdleHandler struct {
 	OnExists  func(event ExistsEvent)
 	OnExpunge func(event ExpungeEvent)
 	OnFetch   func(event FetchEvent)
+	OnRecent  func(event RecentEvent)
+	OnAlert   func(event AlertEvent)
+	OnBye     func(event ByeEvent)
 }
 
 // runIdleEvent processes an IDLE event and calls the appropriate handler
@@ -67,6 +96,10 @@ func (d *Dialer) runIdleEvent(data []byte, handler *IdleHandler) error {
 		if handler.OnExpunge != nil {
 			go handler.OnExpunge(ExpungeEvent{MessageIndex: index})
 		}
+	case IdleEventRecent:
+		if handler.OnRecent != nil {
+			go handler.OnRecent(RecentEvent{Count: index})
+		}
 	case IdleEventFetch:
 		if handler.OnFetch == nil {
 			return nil
@@ -89,126 +122,300 @@ func (d *Dialer) runIdleEvent(data []byte, handler *IdleHandler) error {
 	return nil
 }
 
-// StartIdle starts IDLE monitoring with automatic reconnection and timeout handling
+// idleRefreshInterval is how often the IDLE command is reissued; RFC 2177
+// asks clients to do so at least every 29 minutes.
+const idleRefreshInterval = 5 * time.Minute
+
+// idleContinuationTimeout bounds the wait for the server to accept IDLE
+const idleContinuationTimeout = 5 * time.Second
+
+// idleDoneTimeout bounds the wait for IDLE to complete after DONE when no
+// CommandTimeout is set
+const idleDoneTimeout = 30 * time.Second
+
+// idleMonitor is the state of a running StartIdle loop
+type idleMonitor struct {
+	handler   *IdleHandler
+	stop      chan struct{} // closed by StopIdle
+	stopOnce  sync.Once
+	done      chan struct{} // closed when the loop exits
+	interrupt chan struct{} // asks the active IDLE command to end
+	resume    chan struct{} // signals that waiting commands have finished
+}
+
+// signal does a non-blocking send on a buffered notification channel
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// idleMonitor returns the running IDLE monitor, if any
+func (d *Dialer) idleMonitor() *idleMonitor {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	return d.idle
+}
+
+// StartIdle starts IDLE monitoring with automatic reconnection and timeout
+// handling. It returns once the server has accepted the first IDLE command,
+// or with the error that prevented it; an *UnsupportedError if the server
+// does not advertise IDLE.
+//
+// Other methods may be called while monitoring: the IDLE command is ended
+// with DONE, the method runs, and IDLE is resumed afterwards. Events that
+// arrive while IDLE is paused are not reported to handler.
 func (d *Dialer) StartIdle(handler *IdleHandler) error {
-	go func() {
-		ticker := time.NewTicker(5 * time.Minute)
-		defer ticker.Stop()
-
-		for {
-			if !d.Connected {
-				if err := d.Reconnect(); err != nil {
-					if Verbose {
-						warnLog(d.ConnNum, d.Folder, "IDLE reconnect failed", "error", err)
-					}
-					return
-				}
-			}
-			if err := d.startIdleSingle(handler); err != nil {
-				if Verbose {
-					warnLog(d.ConnNum, d.Folder, "IDLE session stopped", "error", err)
-				}
-				return
-			}
+	return d.StartIdleContext(context.Background(), handler)
+}
+
+// StartIdleContext is like StartIdle but stops monitoring when ctx is done.
+// The active IDLE command is ended cleanly with DONE, so the connection
+// remains usable for further commands.
+func (d *Dialer) StartIdleContext(ctx context.Context, handler *IdleHandler) error {
+	if err := d.requireCapability(ctx, "IDLE"); err != nil {
+		return err
+	}
+
+	m := &idleMonitor{
+		handler:   handler,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		interrupt: make(chan struct{}, 1),
+		resume:    make(chan struct{}, 1),
+	}
+	d.stateMu.Lock()
+	if d.idle != nil {
+		d.stateMu.Unlock()
+		return fmt.Errorf("already entering or in IDLE")
+	}
+	d.idle = m
+	d.stateMu.Unlock()
 
+	ready := make(chan error, 1)
+	go d.runIdle(ctx, m, ready)
+	return <-ready
+}
+
+// runIdle reissues IDLE until the monitor is stopped, pausing whenever other
+// goroutines are waiting for the connection. The first IDLE's outcome is
+// sent on ready.
+func (d *Dialer) runIdle(ctx context.Context, m *idleMonitor, ready chan<- error) {
+	report := func(err error) {
+		if ready != nil {
+			ready <- err
+			ready = nil
+		}
+	}
+	defer func() {
+		d.stateMu.Lock()
+		d.idle = nil
+		d.stateMu.Unlock()
+		close(m.done)
+		report(fmt.Errorf("IDLE stopped before it started"))
+	}()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ctx.Done():
+			report(ctx.Err())
+			return
+		default:
+		}
+
+		if d.waiting.Load() > 0 {
 			select {
-			case <-ticker.C:
-				_ = d.StopIdle()
-			case <-d.idleDone:
+			case <-m.resume:
+			case <-m.stop:
+				return
+			case <-ctx.Done():
+				report(ctx.Err())
 				return
 			}
+			continue
 		}
-	}()
 
-	return nil
+		d.mu.Lock()
+		established, err := d.idleOnceLocked(ctx, m, func() { report(nil) })
+		d.mu.Unlock()
+		if err != nil {
+			if d.config.Verbose {
+				d.warnLog("IDLE session stopped", "error", err)
+			}
+			report(err)
+			// A connection lost while idling is reopened on the next pass;
+			// anything else ends monitoring.
+			if !established || ClassifyError(err) != ErrorTransport {
+				return
+			}
+		}
+	}
 }
 
-// startIdleSingle starts a single IDLE session
-func (d *Dialer) startIdleSingle(handler *IdleHandler) error {
-	if d.State() == StateIdling || d.State() == StateIdlePending {
-		return fmt.Errorf("already entering or in IDLE")
+// idleOnceLocked runs one IDLE command until it is refreshed, interrupted
+// or stopped. The caller holds the connection lock. established reports
+// whether the server accepted IDLE; onReady is called when it does.
+func (d *Dialer) idleOnceLocked(ctx context.Context, m *idleMonitor, onReady func()) (established bool, err error) {
+	if err := d.ensureConnectedLocked(ctx); err != nil {
+		if d.config.Verbose {
+			d.warnLog("IDLE reconnect failed", "error", err)
+		}
+		return false, err
+	}
+
+	// Discard interrupts meant for an earlier IDLE, then yield to any
+	// goroutine that started waiting since.
+	select {
+	case <-m.interrupt:
+	default:
+	}
+	if d.waiting.Load() > 0 {
+		return false, nil
 	}
 
+	tag := []byte(strings.ToUpper(xid.New().String()))
+	cmd, err := d.startCommand(tag, modeBuffered)
+	if err != nil {
+		d.setState(StateDisconnected)
+		_ = d.closeLocked()
+		return false, fmt.Errorf("imap idle: %w", err)
+	}
+	defer d.reader.release(cmd)
+
 	d.setState(StateIdlePending)
+	d.debugLog("sending command", "command", string(tag)+" IDLE")
 
-	d.idleStop = make(chan struct{})
-	d.idleDone = make(chan struct{})
-	idleReady := make(chan struct{})
+	stop := d.watchContext(ctx)
+	_, err = fmt.Fprintf(d.conn, "%s IDLE\r\n", tag)
+	stop()
+	if err != nil {
+		d.setState(StateDisconnected)
+		_ = d.closeLocked()
+		return false, fmt.Errorf("imap idle: %w", err)
+	}
 
-	go func() {
-		defer func() {
-			close(d.idleStop)
-			if d.State() == StateIdling {
-				d.setState(StateSelected)
-			}
-		}()
-
-		_, err := d.Exec("IDLE", true, 0, func(line []byte) error {
-			line = []byte(strings.ToUpper(string(line)))
-			switch {
-			case bytes.HasPrefix(line, []byte("+")):
-				d.setState(StateIdling)
-				close(idleReady)
-				return nil
-			case bytes.HasPrefix(line, []byte("* ")):
-				strLine := string(line[2:])
-				if strings.HasPrefix(strLine, "OK") {
-					return nil
-				}
-				if strings.HasPrefix(strLine, "BYE") {
-					d.setState(StateDisconnected)
-					_ = d.Close()
-					return fmt.Errorf("server sent BYE: %s", line)
-				}
-				return d.runIdleEvent([]byte(strLine), handler)
-			case bytes.HasPrefix(line, []byte("OK ")):
-				strLine := string(line[3:])
-				if strings.HasPrefix(strLine, "IDLE") {
-					d.setState(StateSelected)
-				}
-				return nil
-			}
-			return nil
-		})
+	expired, stopTimer := deadlineTimer(time.Now().Add(idleContinuationTimeout))
+	defer stopTimer()
+	for {
+		line, err := d.readIdleLine(ctx, cmd, expired)
 		if err != nil {
-			if Verbose {
-				warnLog(d.ConnNum, d.Folder, "IDLE command error", "error", err)
-			}
 			d.setState(StateDisconnected)
+			_ = d.closeLocked()
+			return false, fmt.Errorf("imap idle: %w", err)
+		}
+		if bytes.HasPrefix(line, []byte("+")) {
+			break
+		}
+		if done, err := d.handleIdleLine(line, tag, m.handler); done {
+			return false, err
+		}
+	}
+
+	d.setState(StateIdling)
+	onReady()
+
+	// End the IDLE command with DONE when it is due for a refresh, another
+	// goroutine needs the connection, or monitoring stops. The completion
+	// must then arrive within the command timeout, or idleDoneTimeout if
+	// there is none.
+	finished := make(chan struct{})
+	timedOut := make(chan time.Time)
+	var timeout *time.Timer
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		refresh := time.NewTimer(idleRefreshInterval)
+		defer refresh.Stop()
+		select {
+		case <-refresh.C:
+		case <-m.interrupt:
+		case <-m.stop:
+		case <-ctx.Done():
+		case <-finished:
+			return
+		}
+		d.setState(StateStoppingIdle)
+		d.debugLog("sending DONE to exit IDLE")
+		deadline := d.commandDeadline(context.Background())
+		if deadline.IsZero() {
+			deadline = time.Now().Add(idleDoneTimeout)
+		}
+		timeout = time.AfterFunc(time.Until(deadline), func() { close(timedOut) })
+		if _, err := d.conn.Write([]byte("DONE\r\n")); err != nil {
+			d.debugLog("failed to send DONE", "error", err)
+		}
+	})
+	defer func() {
+		close(finished)
+		wg.Wait()
+		if timeout != nil {
+			timeout.Stop()
 		}
 	}()
 
-	select {
-	case <-idleReady:
-		return nil
-	case <-time.After(5 * time.Second):
-		d.setState(StateSelected)
-		return fmt.Errorf("timeout waiting for + IDLE response")
+	for {
+		// ctx is handled by sending DONE, so the IDLE completes cleanly
+		line, err := d.readIdleLine(context.Background(), cmd, timedOut)
+		if err != nil {
+			d.setState(StateDisconnected)
+			_ = d.closeLocked()
+			return true, fmt.Errorf("imap idle: %w", err)
+		}
+		if done, err := d.handleIdleLine(line, tag, m.handler); done {
+			return true, err
+		}
 	}
 }
 
-// StopIdle stops the current IDLE session
-func (d *Dialer) StopIdle() error {
-	if d.State() != StateIdling {
-		return fmt.Errorf("not in IDLE state")
+// readIdleLine waits for the next response to the IDLE command
+func (d *Dialer) readIdleLine(ctx context.Context, cmd *pendingCommand, expired <-chan time.Time) ([]byte, error) {
+	line, err := d.reader.next(ctx, cmd, expired)
+	if err != nil {
+		return nil, err
+	}
+	d.responseLog(line)
+	return line, nil
+}
+
+// handleIdleLine processes a response received while entering or in IDLE.
+// done reports that the IDLE command has completed, with err set if it did
+// not complete with OK.
+func (d *Dialer) handleIdleLine(line []byte, tag []byte, handler *IdleHandler) (done bool, err error) {
+	if bytes.HasPrefix(line, tag) {
+		d.setState(StateSelected)
+		resp := line[len(tag):]
+		if len(resp) > 0 && resp[0] == ' ' {
+			resp = resp[1:]
+		}
+		if hasPrefixFold(resp, "OK") {
+			return true, nil
+		}
+		return true, parseStatusError(resp)
 	}
 
-	if Verbose {
-		debugLog(d.ConnNum, d.Folder, "sending DONE to exit IDLE")
+	if !bytes.HasPrefix(line, []byte("* ")) {
+		return false, nil
 	}
-	if _, err := d.conn.Write([]byte("DONE\r\n")); err != nil {
-		return fmt.Errorf("failed to send DONE: %v", err)
+	d.handleUnsolicited(handler, "IDLE", line)
+	if hasPrefixFold(line[2:], "BYE") {
+		d.setState(StateDisconnected)
+		_ = d.closeLocked()
+		return true, parseStatusError(line[2:])
 	}
+	return false, nil
+}
 
-	d.setState(StateStoppingIdle)
-	close(d.idleDone)
-
-	<-d.idleStop
-	d.idleDone, d.idleStop = nil, nil
-	if d.State() == StateStoppingIdle {
-		d.setState(StateSelected)
+// StopIdle stops IDLE monitoring started by StartIdle, ending the active
+// IDLE command with DONE. It returns an error if no monitor is running.
+func (d *Dialer) StopIdle() error {
+	m := d.idleMonitor()
+	if m == nil {
+		return fmt.Errorf("not in IDLE state")
 	}
-
+	m.stopOnce.Do(func() { close(m.stop) })
+	<-m.done
 	return nil
 }
 
@@ -1,6 +1,7 @@
 package imap
 
 import (
+	"context"
 	"sync"
 	"testing"
 	"time"
@@ -201,3 +202,125 @@ func TestIdleEventConstants(t *testing.T) {
 		t.Errorf("unexpected IdleEventFetch: %s", IdleEventFetch)
 	}
 }
+
+func TestStartIdle_ConcurrentCommands(t *testing.T) {
+	t.Parallel()
+	server, err := newMockIMAPServer("user", "pass")
+	if err != nil {
+		t.Fatalf("failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	server.capabilities = "IMAP4rev1 IDLE"
+	server.idleEvents = "* 3 EXISTS\r\n"
+
+	d, err := Dial(context.Background(), newTestConfig(server))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer d.Close()
+
+	exists := make(chan ExistsEvent, 16)
+	if err := d.StartIdle(&IdleHandler{
+		OnExists: func(e ExistsEvent) {
+			select {
+			case exists <- e:
+			default:
+			}
+		},
+	}); err != nil {
+		t.Fatalf("StartIdle() error = %v", err)
+	}
+	select {
+	case e := <-exists:
+		if e.MessageIndex != 3 {
+			t.Errorf("MessageIndex = %d, want 3", e.MessageIndex)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for EXISTS event")
+	}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			for range 5 {
+				if _, err := d.Exec("NOOP", false, 0, nil); err != nil {
+					t.Errorf("NOOP during IDLE: %v", err)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	// The monitor resumes IDLE in the background once the commands are done
+	deadline := time.Now().Add(2 * time.Second)
+	for countCommands(server, "IDLE") < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := d.StopIdle(); err != nil {
+		t.Fatalf("StopIdle() error = %v", err)
+	}
+	if n := countCommands(server, "NOOP"); n != 40 {
+		t.Errorf("NOOP sent %d times, want 40", n)
+	}
+	if n := countCommands(server, "IDLE"); n < 2 {
+		t.Errorf("IDLE sent %d times, want it resumed after commands", n)
+	}
+	if err := d.StopIdle(); err == nil {
+		t.Error("second StopIdle() should fail")
+	}
+	if _, err := d.Exec("NOOP", false, 0, nil); err != nil {
+		t.Errorf("NOOP after StopIdle: %v", err)
+	}
+}
+
+func TestStartIdle_AlreadyIdling(t *testing.T) {
+	d, _ := dialWithCapabilities(t, "IMAP4rev1 IDLE")
+	if err := d.StartIdle(&IdleHandler{}); err != nil {
+		t.Fatalf("StartIdle() error = %v", err)
+	}
+	if err := d.StartIdle(&IdleHandler{}); err == nil {
+		t.Error("second StartIdle() should fail")
+	}
+	if err := d.StopIdle(); err != nil {
+		t.Errorf("StopIdle() error = %v", err)
+	}
+}
+
+func TestClose_StopsIdle(t *testing.T) {
+	d, _ := dialWithCapabilities(t, "IMAP4rev1 IDLE")
+	if err := d.StartIdle(&IdleHandler{}); err != nil {
+		t.Fatalf("StartIdle() error = %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- d.Close() }()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close() blocked while IDLE was active")
+	}
+	if d.idleMonitor() != nil {
+		t.Error("IDLE monitor still running after Close()")
+	}
+}
+
+func TestStartIdleContext_Cancel(t *testing.T) {
+	d, _ := dialWithCapabilities(t, "IMAP4rev1 IDLE")
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := d.StartIdleContext(ctx, &IdleHandler{}); err != nil {
+		t.Fatalf("StartIdleContext() error = %v", err)
+	}
+	cancel()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for d.idleMonitor() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("IDLE monitor did not stop after cancel")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := d.Exec("NOOP", false, 0, nil); err != nil {
+		t.Errorf("NOOP after cancel: %v", err)
+	}
+}
@@ -3,12 +3,12 @@
 package imap
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net"
 	"net/smtp"
 	"os"
-	"sync"
 	"testing"
 	"time"
 )
@@ -17,9 +17,6 @@ import (
 // Start one with: docker compose up -d
 //
 // Run tests with: go test -tags=integration -v ./...
-//
-// Note: These tests modify the global TLSSkipVerify variable and use a mutex
-// to prevent race conditions. Do not run with t.Parallel() at the top level.
 
 const (
 	testIMAPHost = "localhost"
@@ -30,10 +27,6 @@ const (
 	testPass     = "testpass"
 )
 
-// tlsSkipVerifyMu protects access to the global TLSSkipVerify variable
-// to prevent race conditions when tests run concurrently.
-var tlsSkipVerifyMu sync.Mutex
-
 func getTestConfig() (host string, imapPort, smtpPort int) {
 	host = testIMAPHost
 	imapPort = testIMAPPort
@@ -45,6 +38,18 @@ func getTestConfig() (host string, imapPort, smtpPort int) {
 	return host, imapPort, smtpPort
 }
 
+// testDialConfig returns a per-connection config for the GreenMail IMAPS
+// port, which uses a self-signed certificate.
+func testDialConfig(host string) Config {
+	cfg := DefaultConfig()
+	cfg.Host = host
+	cfg.Port = 3993
+	cfg.Username = testUser
+	cfg.Password = testPass
+	cfg.TLSSkipVerify = true
+	return cfg
+}
+
 func waitForServer(host string, port int, timeout time.Duration) error {
 	deadline := time.Now().Add(timeout)
 	for time.Now().Before(deadline) {
@@ -77,19 +82,9 @@ func setupTestConnection(t *testing.T) *Dialer {
 		t.Skipf("SMTP server not available: %v (run: docker compose up -d)", err)
 	}
 
-	// GreenMail uses non-TLS on port 3143, so we need to connect without TLS
-	// For now, let's use the TLS port with skip verify
-	tlsSkipVerifyMu.Lock()
-	oldSkipVerify := TLSSkipVerify
-	TLSSkipVerify = true
-	t.Cleanup(func() {
-		TLSSkipVerify = oldSkipVerify
-		tlsSkipVerifyMu.Unlock()
-	})
-
 	// GreenMail creates users on first login attempt
 	// Try connecting to the IMAPS port (3993)
-	conn, err := New(testUser, testPass, host, 3993)
+	conn, err := Dial(context.Background(), testDialConfig(host))
 	if err != nil {
 		// If TLS fails, try a plain connection approach
 		t.Skipf("Could not connect to IMAP server: %v", err)
@@ -295,16 +290,8 @@ func TestIntegration_Connection(t *testing.T) {
 		t.Skipf("IMAP server not available: %v", err)
 	}
 
-	tlsSkipVerifyMu.Lock()
-	oldSkipVerify := TLSSkipVerify
-	TLSSkipVerify = true
-	defer func() {
-		TLSSkipVerify = oldSkipVerify
-		tlsSkipVerifyMu.Unlock()
-	}()
-
 	t.Run("Connect and authenticate", func(t *testing.T) {
-		conn, err := New(testUser, testPass, host, 3993)
+		conn, err := Dial(context.Background(), testDialConfig(host))
 		if err != nil {
 			t.Fatalf("Failed to connect: %v", err)
 		}
@@ -319,6 +306,21 @@ func TestIntegration_Connection(t *testing.T) {
 			t.Error("Expected at least one folder")
 		}
 	})
+
+	t.Run("Connect over plaintext", func(t *testing.T) {
+		cfg := testDialConfig(host)
+		cfg.Port = imapPort
+		cfg.Transport = TransportInsecure
+		conn, err := Dial(context.Background(), cfg)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+
+		if _, err := conn.GetFolders(); err != nil {
+			t.Fatalf("Failed to get folders: %v", err)
+		}
+	})
 }
 
 // Ensure tls package is available for TLS connections
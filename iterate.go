@@ -0,0 +1,215 @@
+package imap
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultPageSize is the number of messages Messages fetches per request
+// when FetchOptions.PageSize is not set
+const DefaultPageSize = 100
+
+// MessageOrder is the order in which Messages yields messages
+type MessageOrder int
+
+const (
+	// OrderUIDAsc yields messages by ascending UID, which is the order they
+	// were added to the folder
+	OrderUIDAsc MessageOrder = iota
+	// OrderUIDDesc yields messages by descending UID, newest first
+	OrderUIDDesc
+	// OrderDateAsc yields messages by the date they arrived in the folder
+	// (INTERNALDATE), oldest first
+	OrderDateAsc
+	// OrderDateDesc yields messages by arrival date, newest first
+	OrderDateDesc
+)
+
+// FetchOptions controls what Messages retrieves and how
+type FetchOptions struct {
+	// Overview fetches only the envelope, flags, size and dates, like
+	// GetOverviews, instead of full messages with bodies like GetEmails
+	Overview bool
+	// LazyAttachments fetches only the text and HTML bodies and describes
+	// attachments without their content, like GetEmailsLazy. It is ignored
+	// with Overview.
+	LazyAttachments bool
+	// PageSize is the number of messages fetched per request; zero means
+	// DefaultPageSize
+	PageSize int
+	// Order is the order in which messages are yielded
+	Order MessageOrder
+}
+
+// Messages returns an iterator over the messages in the selected folder that
+// match search, which takes the same criteria as GetUIDs ("" means "ALL").
+// The matching UIDs are looked up once; the messages are then fetched a page
+// at a time as the loop advances, so breaking out of the loop early skips the
+// remaining requests:
+//
+//	for email, err := range conn.Messages(ctx, "UNSEEN", imap.FetchOptions{PageSize: 50}) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(email.Subject)
+//	}
+//
+// Messages expunged after the search are skipped. On error the iterator
+// yields it once, with a nil Email, and stops. The connection is not held
+// between pages, so the loop body may use it for other commands.
+//
+// Ordering by date uses SORT (RFC 5256) when the server supports it, and
+// otherwise fetches the INTERNALDATE of every matching message first.
+func (d *Dialer) Messages(ctx context.Context, search string, opts FetchOptions) iter.Seq2[*Email, error] {
+	return func(yield func(*Email, error) bool) {
+		uids, err := d.orderedUIDs(ctx, search, opts.Order)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		size := opts.PageSize
+		if size <= 0 {
+			size = DefaultPageSize
+		}
+		for page := range slices.Chunk(uids, size) {
+			var emails map[int]*Email
+			switch {
+			case opts.Overview:
+				emails, err = d.GetOverviewsContext(ctx, page...)
+			case opts.LazyAttachments:
+				emails, err = d.GetEmailsLazyContext(ctx, page...)
+			default:
+				emails, err = d.GetEmailsContext(ctx, page...)
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, uid := range page {
+				e, ok := emails[uid]
+				if !ok {
+					continue
+				}
+				if !yield(e, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// orderedUIDs returns the UIDs matching search in order
+func (d *Dialer) orderedUIDs(ctx context.Context, search string, order MessageOrder) ([]int, error) {
+	if search == "" {
+		search = "ALL"
+	}
+	switch order {
+	case OrderUIDAsc, OrderUIDDesc:
+		uids, err := d.GetUIDsContext(ctx, search)
+		if err != nil {
+			return nil, err
+		}
+		slices.Sort(uids)
+		if order == OrderUIDDesc {
+			slices.Reverse(uids)
+		}
+		return uids, nil
+	case OrderDateAsc, OrderDateDesc:
+		sort, err := d.HasCapabilityContext(ctx, "SORT")
+		if err != nil {
+			return nil, err
+		}
+		if sort {
+			return d.sortUIDs(ctx, search, order == OrderDateDesc)
+		}
+		uids, err := d.GetUIDsContext(ctx, search)
+		if err != nil {
+			return nil, err
+		}
+		dates, err := d.internalDates(ctx, uids)
+		if err != nil {
+			return nil, err
+		}
+		slices.SortFunc(uids, func(a, b int) int {
+			if c := dates[a].Compare(dates[b]); c != 0 {
+				return c
+			}
+			return cmp.Compare(a, b)
+		})
+		if order == OrderDateDesc {
+			slices.Reverse(uids)
+		}
+		return uids, nil
+	}
+	return nil, fmt.Errorf("imap: unknown message order %d", order)
+}
+
+// sortUIDs returns the UIDs matching search by arrival date using UID SORT
+func (d *Dialer) sortUIDs(ctx context.Context, search string, reverse bool) ([]int, error) {
+	criteria := "(ARRIVAL)"
+	if reverse {
+		criteria = "(REVERSE ARRIVAL)"
+	}
+	// SORT names the charset itself rather than in a CHARSET search key
+	charset := "UTF-8"
+	if rest, ok := strings.CutPrefix(search, "CHARSET "); ok {
+		charset, search, _ = strings.Cut(rest, " ")
+	}
+	r, err := d.exec(ctx, "UID SORT "+criteria+" "+charset+" "+search, true, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	return parseNumberList(r, "SORT")
+}
+
+// internalDates fetches the INTERNALDATE of each message in uids
+func (d *Dialer) internalDates(ctx context.Context, uids []int) (map[int]time.Time, error) {
+	dates := make(map[int]time.Time, len(uids))
+	if len(uids) == 0 {
+		return dates, nil
+	}
+	collect := func(m *FetchMessage) error {
+		var uid int
+		var date time.Time
+		for {
+			item, err := m.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+			switch item.Name {
+			case "UID":
+				if err := d.CheckType(item.Value, []TType{TNumber}, nil, "after UID"); err != nil {
+					return err
+				}
+				uid = item.Value.Num
+			case "INTERNALDATE":
+				if err := d.CheckType(item.Value, []TType{TQuoted}, nil, "after INTERNALDATE"); err != nil {
+					return err
+				}
+				if date, err = parseInternalDate(item.Value.Str); err != nil {
+					return err
+				}
+			}
+		}
+		if uid != 0 {
+			dates[uid] = date
+		}
+		return nil
+	}
+	d.lock()
+	defer d.unlock()
+	for _, chunk := range d.splitUIDs(UIDSetNum(uids...), len("UID FETCH  (INTERNALDATE)")) {
+		if err := d.fetchStreamLocked(ctx, "UID FETCH "+chunk.String()+" (INTERNALDATE)", true, collect); err != nil {
+			return nil, err
+		}
+	}
+	return dates, nil
+}
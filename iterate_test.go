@@ -0,0 +1,108 @@
+package imap
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// overviewResponse returns the UID FETCH ... ALL response for uids
+func overviewResponse(uids ...int) string {
+	var b strings.Builder
+	for i, uid := range uids {
+		fmt.Fprintf(&b, "* %d FETCH (UID %d FLAGS () INTERNALDATE \" 9-Apr-2026 17:06:19 -0400\" RFC822.SIZE 100 "+
+			"ENVELOPE (\"Thu, 9 Apr 2026 21:06:17 +0000\" \"message %d\" NIL NIL NIL NIL NIL NIL NIL \"<%d@example.com>\"))\r\n",
+			i+1, uid, uid, uid)
+	}
+	return b.String()
+}
+
+// collectUIDs runs Messages and returns the UIDs it yielded, stopping after
+// limit messages if limit is positive
+func collectUIDs(t *testing.T, d *Dialer, search string, opts FetchOptions, limit int) []int {
+	t.Helper()
+	var uids []int
+	for e, err := range d.Messages(context.Background(), search, opts) {
+		if err != nil {
+			t.Fatalf("Messages() error = %v", err)
+		}
+		if e.Subject != fmt.Sprintf("message %d", e.UID) {
+			t.Errorf("UID %d has subject %q", e.UID, e.Subject)
+		}
+		uids = append(uids, e.UID)
+		if len(uids) == limit {
+			break
+		}
+	}
+	return uids
+}
+
+func TestMessages_Pages(t *testing.T) {
+	d, server := setupTestDialer(t)
+	server.responses["UID SEARCH ALL"] = "* SEARCH 3 1 2 4\r\n"
+	server.responses["UID FETCH 3:4 ALL"] = overviewResponse(4) // 3 was expunged
+	server.responses["UID FETCH 1:2 ALL"] = overviewResponse(2, 1)
+	opts := FetchOptions{Overview: true, PageSize: 2, Order: OrderUIDDesc}
+
+	if got := collectUIDs(t, d, "", opts, 0); !slices.Equal(got, []int{4, 2, 1}) {
+		t.Errorf("Messages() yielded %v, want [4 2 1]", got)
+	}
+	if n := countCommands(server, "UID FETCH"); n != 2 {
+		t.Errorf("sent %d fetches, want 2", n)
+	}
+
+	// Breaking out of the loop skips the remaining pages
+	if got := collectUIDs(t, d, "ALL", opts, 1); !slices.Equal(got, []int{4}) {
+		t.Errorf("Messages() yielded %v, want [4]", got)
+	}
+	if n := countCommands(server, "UID FETCH"); n != 3 {
+		t.Errorf("sent %d fetches in total, want 3", n)
+	}
+}
+
+func TestMessages_DateOrderWithoutSort(t *testing.T) {
+	d, server := dialWithCapabilities(t, "IMAP4rev1")
+	server.responses["UID SEARCH ALL"] = "* SEARCH 1 2 3\r\n"
+	server.responses["UID FETCH 1:3 (INTERNALDATE)"] = "" +
+		"* 1 FETCH (UID 1 INTERNALDATE \"01-Mar-2026 10:00:00 +0000\")\r\n" +
+		"* 2 FETCH (UID 2 INTERNALDATE \"01-Jan-2026 10:00:00 +0000\")\r\n" +
+		"* 3 FETCH (INTERNALDATE \"01-Feb-2026 10:00:00 +0000\" UID 3)\r\n"
+	server.responses["UID FETCH 1:3 ALL"] = overviewResponse(2, 3, 1)
+
+	got := collectUIDs(t, d, "ALL", FetchOptions{Overview: true, Order: OrderDateAsc}, 0)
+	if !slices.Equal(got, []int{2, 3, 1}) {
+		t.Errorf("Messages() yielded %v, want [2 3 1]", got)
+	}
+}
+
+func TestMessages_DateOrderWithSort(t *testing.T) {
+	d, server := dialWithCapabilities(t, "IMAP4rev1 SORT")
+	server.responses["UID SORT (REVERSE ARRIVAL) US-ASCII UNSEEN"] = "* SORT 9 5\r\n"
+	server.responses["UID FETCH 5,9 ALL"] = overviewResponse(9, 5)
+
+	got := collectUIDs(t, d, "CHARSET US-ASCII UNSEEN", FetchOptions{Overview: true, Order: OrderDateDesc}, 0)
+	if !slices.Equal(got, []int{9, 5}) {
+		t.Errorf("Messages() yielded %v, want [9 5]", got)
+	}
+	if n := countCommands(server, "UID SEARCH"); n != 0 {
+		t.Errorf("sent %d searches, want SORT only", n)
+	}
+}
+
+func TestMessages_Error(t *testing.T) {
+	d, server := setupTestDialer(t)
+	server.failCommands["UID"] = true
+
+	n := 0
+	for e, err := range d.Messages(context.Background(), "ALL", FetchOptions{}) {
+		n++
+		if e != nil || err == nil {
+			t.Errorf("Messages() yielded %v, %v; want only an error", e, err)
+		}
+	}
+	if n != 1 {
+		t.Errorf("Messages() yielded %d times, want 1", n)
+	}
+}
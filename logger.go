@@ -83,7 +83,11 @@ func getLogger() Logger {
 
 // connectionLogger adds per-connection context to the configured logger.
 func connectionLogger(connNum int, folder string) Logger {
-	logger := getLogger()
+	return withConnection(getLogger(), connNum, folder)
+}
+
+// withConnection adds per-connection context to logger.
+func withConnection(logger Logger, connNum int, folder string) Logger {
 	// connNum < 0 signals that the caller does not have an active connection
 	// context (for example, package-level diagnostics).
 	if connNum < 0 && folder == "" {
@@ -112,3 +116,38 @@ func warnLog(connNum int, folder string, msg string, args ...any) {
 func errorLog(connNum int, folder string, msg string, args ...any) {
 	connectionLogger(connNum, folder).Error(msg, args...)
 }
+
+// logger returns the connection's logger (Config.Logger, or the package
+// logger when unset) annotated with the connection number and mailbox.
+func (d *Dialer) logger() Logger {
+	logger := getLogger()
+	if d.config.Logger != nil {
+		logger = d.config.Logger.WithAttrs("component", "imap/agent")
+	}
+	return withConnection(logger, d.ConnNum, d.Folder)
+}
+
+// debugLog emits a debug log entry when the connection is verbose.
+func (d *Dialer) debugLog(msg string, args ...any) {
+	if !d.config.Verbose {
+		return
+	}
+	d.logger().Debug(msg, args...)
+}
+
+// responseLog emits a server response at debug level unless responses are
+// suppressed for the connection.
+func (d *Dialer) responseLog(line []byte) {
+	if d.config.SkipResponses {
+		return
+	}
+	d.debugLog("server response", "response", string(dropNl(line)))
+}
+
+func (d *Dialer) warnLog(msg string, args ...any) {
+	d.logger().Warn(msg, args...)
+}
+
+func (d *Dialer) errorLog(msg string, args ...any) {
+	d.logger().Error(msg, args...)
+}
@@ -1,48 +1,89 @@
 package imap
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"maps"
 	"mime"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
-	retry "github.com/StirlingMarketingGroup/go-retry"
 	"github.com/davecgh/go-spew/spew"
 	humanize "github.com/dustin/go-humanize"
 	"github.com/jhillyerd/enmime/v2"
-	"golang.org/x/net/html/charset"
 )
 
-// EmailAddresses represents a map of email addresses to display names
+// EmailAddresses represents a map of email addresses to display names. It is a
+// convenience view of an AddressList keyed by lower-case address, without
+// order, duplicates or groups.
 type EmailAddresses map[string]string
 
 // Email represents an IMAP email message
 type Email struct {
-	Flags       []string
-	Received    time.Time
-	Sent        time.Time
-	Size        uint64
-	Subject     string
-	UID         int
-	MessageID   string
-	From        EmailAddresses
-	To          EmailAddresses
-	ReplyTo     EmailAddresses
-	CC          EmailAddresses
-	BCC         EmailAddresses
+	Flags    []string
+	Received time.Time
+	Sent     time.Time
+	// RawDate is the Date header as sent, which Sent is parsed from.
+	// SentErr is set if it could not be parsed, leaving Sent zero.
+	RawDate   string
+	SentErr   error
+	Size      uint64
+	Subject   string
+	UID       int
+	MessageID string
+	// InReplyTo is the In-Reply-To header, the Message-ID of the message
+	// replied to, e.g. "<1234@example.com>"
+	InReplyTo string
+	// References holds the Message-IDs in the References header, oldest
+	// first. ENVELOPE does not include it, so GetOverviews leaves it empty.
+	References []string
+	From       EmailAddresses
+	Sender     EmailAddresses
+	To         EmailAddresses
+	ReplyTo    EmailAddresses
+	CC         EmailAddresses
+	BCC        EmailAddresses
+	// FromList, SenderList, ReplyToList, ToList, CCList and BCCList hold the
+	// same addresses as From, Sender, ReplyTo, To, CC and BCC, in order and
+	// with groups
+	FromList    AddressList
+	SenderList  AddressList
+	ReplyToList AddressList
+	ToList      AddressList
+	CCList      AddressList
+	BCCList     AddressList
 	Text        string
 	HTML        string
 	Attachments []Attachment
+	// Header holds every header field in order. It is set when the message
+	// is fetched with its body, by GetEmails and GetEmailsLazy.
+	Header Header
 }
 
 // Attachment represents an email attachment
 type Attachment struct {
 	Name     string
 	MimeType string
-	Content  []byte
+	// Content is the decoded content. It is nil for attachments described by
+	// GetEmailsLazy, which are downloaded with FetchPart.
+	Content []byte
+	// Size is the size of Content, or for an attachment without Content the
+	// encoded size reported by the server
+	Size int
+	// Disposition is "attachment" or "inline", or "" if the part has none
+	Disposition string
+	// ContentID is the Content-ID without angle brackets, which HTML bodies
+	// refer to inline images by
+	ContentID string
+	// Path is the part number to pass to FetchPart, e.g. "2". It is set by
+	// GetEmailsLazy.
+	Path string
 }
 
 // Email parsing constants
@@ -66,24 +107,24 @@ const (
 	EEHost
 )
 
-// String returns a formatted string representation of EmailAddresses
+// String returns a formatted string representation of EmailAddresses, sorted
+// by address
 func (e EmailAddresses) String() string {
 	emails := strings.Builder{}
-	i := 0
-	for e, n := range e {
+	for i, addr := range slices.Sorted(maps.Keys(e)) {
+		n := e[addr]
 		if i != 0 {
 			emails.WriteString(", ")
 		}
 		if len(n) != 0 {
 			if strings.ContainsRune(n, ',') {
-				fmt.Fprintf(&emails, `"%s" <%s>`, AddSlashes.Replace(n), e)
+				fmt.Fprintf(&emails, `"%s" <%s>`, AddSlashes.Replace(n), addr)
 			} else {
-				fmt.Fprintf(&emails, `%s <%s>`, n, e)
+				fmt.Fprintf(&emails, `%s <%s>`, n, addr)
 			}
 		} else {
-			emails.WriteString(e)
+			emails.WriteString(addr)
 		}
-		i++
 	}
 	return emails.String()
 }
@@ -94,20 +135,23 @@ func (e Email) String() string {
 
 	fmt.Fprintf(&email, "Subject: %s\n", e.Subject)
 
-	if len(e.To) != 0 {
-		fmt.Fprintf(&email, "To: %s\n", e.To)
-	}
-	if len(e.From) != 0 {
-		fmt.Fprintf(&email, "From: %s\n", e.From)
-	}
-	if len(e.CC) != 0 {
-		fmt.Fprintf(&email, "CC: %s\n", e.CC)
-	}
-	if len(e.BCC) != 0 {
-		fmt.Fprintf(&email, "BCC: %s\n", e.BCC)
-	}
-	if len(e.ReplyTo) != 0 {
-		fmt.Fprintf(&email, "ReplyTo: %s\n", e.ReplyTo)
+	for _, a := range []struct {
+		label string
+		list  AddressList
+		m     EmailAddresses
+	}{
+		{"To", e.ToList, e.To},
+		{"From", e.FromList, e.From},
+		{"CC", e.CCList, e.CC},
+		{"BCC", e.BCCList, e.BCC},
+		{"ReplyTo", e.ReplyToList, e.ReplyTo},
+	} {
+		// The list keeps the order and groups the map loses
+		if len(a.list) != 0 {
+			fmt.Fprintf(&email, "%s: %s\n", a.label, a.list)
+		} else if len(a.m) != 0 {
+			fmt.Fprintf(&email, "%s: %s\n", a.label, a.m)
+		}
 	}
 	if len(e.Text) != 0 {
 		if len(e.Text) > 20 {
@@ -135,7 +179,11 @@ func (e Email) String() string {
 
 // String returns a formatted string representation of an Attachment
 func (a Attachment) String() string {
-	return fmt.Sprintf("%s (%s %s)", a.Name, a.MimeType, humanize.Bytes(uint64(len(a.Content))))
+	size := len(a.Content)
+	if a.Content == nil {
+		size = a.Size
+	}
+	return fmt.Sprintf("%s (%s %s)", a.Name, a.MimeType, humanize.Bytes(uint64(size)))
 }
 
 // GetUIDs retrieves message UIDs matching a search criteria.
@@ -151,13 +199,46 @@ func (a Attachment) String() string {
 //
 // Note: For retrieving the N most recent messages, use GetLastNUIDs instead.
 func (d *Dialer) GetUIDs(search string) (uids []int, err error) {
-	r, err := d.Exec(`UID SEARCH `+search, true, RetryCount, nil)
+	return d.GetUIDsContext(context.Background(), search)
+}
+
+// GetUIDsContext is like GetUIDs but honors ctx
+func (d *Dialer) GetUIDsContext(ctx context.Context, search string) (uids []int, err error) {
+	r, err := d.exec(ctx, `UID SEARCH `+search, true, true, nil)
 	if err != nil {
 		return nil, err
 	}
 	return parseUIDSearchResponse(r)
 }
 
+// GetUIDSet is like GetUIDs but returns the matching UIDs as a UIDSet. When
+// the server supports ESEARCH (RFC 4731) the result is sent as ranges, which
+// keeps it small for large mailboxes.
+func (d *Dialer) GetUIDSet(search string) (UIDSet, error) {
+	return d.GetUIDSetContext(context.Background(), search)
+}
+
+// GetUIDSetContext is like GetUIDSet but honors ctx
+func (d *Dialer) GetUIDSetContext(ctx context.Context, search string) (UIDSet, error) {
+	esearch, err := d.HasCapabilityContext(ctx, "ESEARCH")
+	if err != nil {
+		return UIDSet{}, err
+	}
+	if !esearch {
+		uids, err := d.GetUIDsContext(ctx, search)
+		if err != nil {
+			return UIDSet{}, err
+		}
+		return UIDSetNum(uids...), nil
+	}
+
+	r, err := d.exec(ctx, "UID SEARCH RETURN (ALL) "+search, true, true, nil)
+	if err != nil {
+		return UIDSet{}, err
+	}
+	return parseSearchAllResponse(r)
+}
+
 // GetLastNUIDs returns the N messages with the highest UIDs in the selected folder.
 // This is useful for fetching the most recent messages.
 //
@@ -170,10 +251,15 @@ func (d *Dialer) GetUIDs(search string) (uids []int, err error) {
 //	// Get the 10 most recent messages
 //	uids, err := conn.GetLastNUIDs(10)
 func (d *Dialer) GetLastNUIDs(n int) ([]int, error) {
+	return d.GetLastNUIDsContext(context.Background(), n)
+}
+
+// GetLastNUIDsContext is like GetLastNUIDs but honors ctx
+func (d *Dialer) GetLastNUIDsContext(ctx context.Context, n int) ([]int, error) {
 	if n <= 0 {
 		return nil, nil
 	}
-	allUIDs, err := d.GetUIDs("ALL")
+	allUIDs, err := d.GetUIDsContext(ctx, "ALL")
 	if err != nil {
 		return nil, err
 	}
@@ -183,28 +269,77 @@ func (d *Dialer) GetLastNUIDs(n int) ([]int, error) {
 	return allUIDs[len(allUIDs)-n:], nil
 }
 
-// Get max UID in the current folder using RFC-4731.
+// Get max UID in the current folder using RFC-4731. On servers without
+// ESEARCH, all UIDs are searched and the largest is returned.
 //
 // The folder of interest must be already selected in either read-only mode,
 // ExamineFolder, or in read-write mode, SelectFolder.
 func (d *Dialer) GetMaxUID() (uid int, err error) {
-	r, err := d.Exec("UID SEARCH RETURN (MAX) 1:*", true, RetryCount, nil)
+	return d.GetMaxUIDContext(context.Background())
+}
+
+// GetMaxUIDContext is like GetMaxUID but honors ctx
+func (d *Dialer) GetMaxUIDContext(ctx context.Context) (uid int, err error) {
+	esearch, err := d.HasCapabilityContext(ctx, "ESEARCH")
+	if err != nil {
+		return 0, err
+	}
+	if !esearch {
+		uids, err := d.GetUIDsContext(ctx, "ALL")
+		if err != nil {
+			return 0, err
+		}
+		for _, u := range uids {
+			uid = max(uid, u)
+		}
+		return uid, nil
+	}
+
+	r, err := d.exec(ctx, "UID SEARCH RETURN (MAX) 1:*", true, true, nil)
 	if err != nil {
 		return 0, err
 	}
 	return parseMaxUIDSearchResponse(r)
 }
 
-// MoveEmail moves an email to a different folder
+// MoveEmail moves an email to a different folder.
+//
+// UID MOVE (RFC 6851) is used when the server supports it. Otherwise the
+// message is copied, flagged \Deleted and removed with UID EXPUNGE, which
+// requires UIDPLUS; without either extension an *UnsupportedError is
+// returned and nothing is sent.
 func (d *Dialer) MoveEmail(uid int, folder string) (err error) {
+	return d.MoveEmailContext(context.Background(), uid, folder)
+}
+
+// MoveEmailContext is like MoveEmail but honors ctx
+func (d *Dialer) MoveEmailContext(ctx context.Context, uid int, folder string) (err error) {
+	move, err := d.HasCapabilityContext(ctx, "MOVE")
+	if err != nil {
+		return err
+	}
+	if !move {
+		uidplus, err := d.HasCapabilityContext(ctx, "UIDPLUS")
+		if err != nil {
+			return err
+		}
+		if !uidplus {
+			return &UnsupportedError{Extension: "MOVE"}
+		}
+	}
+
 	// if we are currently read-only, switch to SELECT for the move-operation
 	readOnlyState := d.ReadOnly
 	if readOnlyState {
-		_ = d.SelectFolder(d.Folder)
+		_ = d.SelectFolderContext(ctx, d.Folder)
+	}
+	if move {
+		_, err = d.exec(ctx, `UID MOVE `+strconv.Itoa(uid)+" "+d.quoteMailbox(folder), true, false, nil)
+	} else {
+		err = d.copyDeleteExpunge(ctx, strconv.Itoa(uid), folder)
 	}
-	_, err = d.Exec(`UID MOVE `+strconv.Itoa(uid)+` "`+AddSlashes.Replace(folder)+`"`, true, RetryCount, nil)
 	if readOnlyState {
-		_ = d.ExamineFolder(d.Folder)
+		_ = d.ExamineFolderContext(ctx, d.Folder)
 	}
 	if err != nil {
 		return err
@@ -213,19 +348,38 @@ func (d *Dialer) MoveEmail(uid int, folder string) (err error) {
 	return nil
 }
 
+// copyDeleteExpunge emulates UID MOVE of the UID set uids with UID COPY, UID
+// STORE and UID EXPUNGE (RFC 4315), touching no other message marked \Deleted.
+func (d *Dialer) copyDeleteExpunge(ctx context.Context, uids, folder string) error {
+	if _, err := d.exec(ctx, `UID COPY `+uids+" "+d.quoteMailbox(folder), false, false, nil); err != nil {
+		return err
+	}
+	if _, err := d.exec(ctx, `UID STORE `+uids+` +FLAGS.SILENT (\Deleted)`, false, false, nil); err != nil {
+		return err
+	}
+	_, err := d.exec(ctx, `UID EXPUNGE `+uids, false, false, nil)
+	return err
+}
+
 // CopyEmail copies an email to a different folder.
 // Unlike MoveEmail, the original message remains in the current folder.
-// UID COPY is not retried because duplicating a message is not idempotent.
+// UID COPY is retried only if it never reached the server, because a
+// replayed copy would duplicate the message.
 func (d *Dialer) CopyEmail(uid int, folder string) error {
+	return d.CopyEmailContext(context.Background(), uid, folder)
+}
+
+// CopyEmailContext is like CopyEmail but honors ctx
+func (d *Dialer) CopyEmailContext(ctx context.Context, uid int, folder string) error {
 	readOnlyState := d.ReadOnly
 	if readOnlyState {
-		if err := d.SelectFolder(d.Folder); err != nil {
+		if err := d.SelectFolderContext(ctx, d.Folder); err != nil {
 			return err
 		}
 	}
-	_, err := d.Exec(`UID COPY `+strconv.Itoa(uid)+` "`+AddSlashes.Replace(folder)+`"`, true, 0, nil)
+	_, err := d.exec(ctx, `UID COPY `+strconv.Itoa(uid)+" "+d.quoteMailbox(folder), true, false, nil)
 	if readOnlyState {
-		if e := d.ExamineFolder(d.Folder); e != nil && err == nil {
+		if e := d.ExamineFolderContext(ctx, d.Folder); e != nil && err == nil {
 			err = e
 		}
 	}
@@ -234,17 +388,22 @@ func (d *Dialer) CopyEmail(uid int, folder string) error {
 
 // MarkSeen marks an email as seen/read
 func (d *Dialer) MarkSeen(uid int) (err error) {
+	return d.MarkSeenContext(context.Background(), uid)
+}
+
+// MarkSeenContext is like MarkSeen but honors ctx
+func (d *Dialer) MarkSeenContext(ctx context.Context, uid int) (err error) {
 	flags := Flags{
 		Seen: FlagAdd,
 	}
 
 	readOnlyState := d.ReadOnly
 	if readOnlyState {
-		_ = d.SelectFolder(d.Folder)
+		_ = d.SelectFolderContext(ctx, d.Folder)
 	}
-	err = d.SetFlags(uid, flags)
+	err = d.SetFlagsContext(ctx, uid, flags)
 	if readOnlyState {
-		_ = d.ExamineFolder(d.Folder)
+		_ = d.ExamineFolderContext(ctx, d.Folder)
 	}
 
 	return err
@@ -252,19 +411,24 @@ func (d *Dialer) MarkSeen(uid int) (err error) {
 
 // DeleteEmail marks an email for deletion
 func (d *Dialer) DeleteEmail(uid int) (err error) {
+	return d.DeleteEmailContext(context.Background(), uid)
+}
+
+// DeleteEmailContext is like DeleteEmail but honors ctx
+func (d *Dialer) DeleteEmailContext(ctx context.Context, uid int) (err error) {
 	flags := Flags{
 		Deleted: FlagAdd,
 	}
 
 	readOnlyState := d.ReadOnly
 	if readOnlyState {
-		if err = d.SelectFolder(d.Folder); err != nil {
+		if err = d.SelectFolderContext(ctx, d.Folder); err != nil {
 			return err
 		}
 	}
-	err = d.SetFlags(uid, flags)
+	err = d.SetFlagsContext(ctx, uid, flags)
 	if readOnlyState {
-		if e := d.ExamineFolder(d.Folder); e != nil && err == nil {
+		if e := d.ExamineFolderContext(ctx, d.Folder); e != nil && err == nil {
 			err = e
 		}
 	}
@@ -274,15 +438,20 @@ func (d *Dialer) DeleteEmail(uid int) (err error) {
 
 // Expunge permanently removes emails marked for deletion
 func (d *Dialer) Expunge() (err error) {
+	return d.ExpungeContext(context.Background())
+}
+
+// ExpungeContext is like Expunge but honors ctx
+func (d *Dialer) ExpungeContext(ctx context.Context) (err error) {
 	readOnlyState := d.ReadOnly
 	if readOnlyState {
-		if err = d.SelectFolder(d.Folder); err != nil {
+		if err = d.SelectFolderContext(ctx, d.Folder); err != nil {
 			return err
 		}
 	}
-	_, err = d.Exec("EXPUNGE", false, RetryCount, nil)
+	_, err = d.exec(ctx, "EXPUNGE", false, false, nil)
 	if readOnlyState {
-		if e := d.ExamineFolder(d.Folder); e != nil && err == nil {
+		if e := d.ExamineFolderContext(ctx, d.Folder); e != nil && err == nil {
 			err = e
 		}
 	}
@@ -291,6 +460,11 @@ func (d *Dialer) Expunge() (err error) {
 
 // SetFlags sets message flags (seen, deleted, etc.)
 func (d *Dialer) SetFlags(uid int, flags Flags) (err error) {
+	return d.SetFlagsContext(context.Background(), uid, flags)
+}
+
+// SetFlagsContext is like SetFlags but honors ctx
+func (d *Dialer) SetFlagsContext(ctx context.Context, uid int, flags Flags) (err error) {
 	// craft the flags-string
 	addFlags := []string{}
 	removeFlags := []string{}
@@ -321,22 +495,28 @@ func (d *Dialer) SetFlags(uid int, flags Flags) (err error) {
 		}
 	}
 
-	query := fmt.Sprintf("UID STORE %d", uid)
+	// STORE takes a single data item, so adding and removing are separate
+	// commands
+	var queries []string
 	if len(addFlags) > 0 {
-		query += fmt.Sprintf(` +FLAGS (%s)`, strings.Join(addFlags, " "))
+		queries = append(queries, fmt.Sprintf(`UID STORE %d +FLAGS (%s)`, uid, strings.Join(addFlags, " ")))
 	}
 	if len(removeFlags) > 0 {
-		query += fmt.Sprintf(` -FLAGS (%s)`, strings.Join(removeFlags, " "))
+		queries = append(queries, fmt.Sprintf(`UID STORE %d -FLAGS (%s)`, uid, strings.Join(removeFlags, " ")))
 	}
 
 	// if we are currently read-only, switch to SELECT for the move-operation
 	readOnlyState := d.ReadOnly
 	if readOnlyState {
-		_ = d.SelectFolder(d.Folder)
+		_ = d.SelectFolderContext(ctx, d.Folder)
+	}
+	for _, query := range queries {
+		if _, err = d.exec(ctx, query, true, false, nil); err != nil {
+			break
+		}
 	}
-	_, err = d.Exec(query, true, RetryCount, nil)
 	if readOnlyState {
-		_ = d.ExamineFolder(d.Folder)
+		_ = d.ExamineFolderContext(ctx, d.Folder)
 	}
 
 	return err
@@ -345,55 +525,120 @@ func (d *Dialer) SetFlags(uid int, flags Flags) (err error) {
 // parseEmailBody parses an RFC 2822 message body string and populates the Email fields.
 // Returns true if parsing succeeded.
 func (d *Dialer) parseEmailBody(e *Email, bodyStr string) bool {
-	r := strings.NewReader(bodyStr)
-	env, err := enmime.ReadEnvelope(r)
+	if !d.parseEmailReader(e, strings.NewReader(bodyStr)) {
+		if d.config.Verbose {
+			spew.Dump(bodyStr)
+		}
+		return false
+	}
+	return true
+}
+
+// parseEmailReader is like parseEmailBody but reads the message from r
+func (d *Dialer) parseEmailReader(e *Email, r io.Reader) bool {
+	header := &headerRecorder{r: r}
+	env, err := enmime.ReadEnvelope(header)
 	if err != nil {
-		if Verbose {
-			warnLog(d.ConnNum, d.Folder, "email body could not be parsed", "error", err)
+		if d.config.Verbose {
+			d.warnLog("email body could not be parsed", "error", err)
 			spew.Dump(env)
-			spew.Dump(bodyStr)
 		}
 		return false
 	}
 
-	e.Subject = env.GetHeader("Subject")
+	e.Header = ParseHeader(header.buf)
+	e.Subject = decodeWords(env.GetHeader("Subject"))
+	e.RawDate = env.Root.Header.Get("Date")
+	d.parseSent(e)
+	e.InReplyTo = strings.TrimSpace(env.Root.Header.Get("In-Reply-To"))
+	e.References = parseMessageIDs(env.Root.Header.Get("References"))
 	e.Text = env.Text
 	e.HTML = env.HTML
-
-	for _, a := range env.Attachments {
-		e.Attachments = append(e.Attachments, Attachment{
-			Name:     a.FileName,
-			MimeType: a.ContentType,
-			Content:  a.Content,
-		})
+	// The MIME parser leaves text in a charset it does not know as is
+	for _, p := range env.Root.DepthMatchAll(charsetFailed) {
+		decoded := decodeCharset(p.Content, p.Charset)
+		switch string(p.Content) {
+		case env.Text:
+			e.Text = decoded
+		case env.HTML:
+			e.HTML = decoded
+		}
 	}
-	for _, a := range env.Inlines {
+
+	for _, a := range append(env.Attachments, env.Inlines...) {
 		e.Attachments = append(e.Attachments, Attachment{
-			Name:     a.FileName,
-			MimeType: a.ContentType,
-			Content:  a.Content,
+			Name:        decodeWords(a.FileName),
+			MimeType:    a.ContentType,
+			Content:     a.Content,
+			Size:        len(a.Content),
+			Disposition: a.Disposition,
+			ContentID:   a.ContentID,
 		})
 	}
 
 	for _, a := range []struct {
 		dest   *EmailAddresses
+		list   *AddressList
 		header string
 	}{
-		{&e.From, "From"},
-		{&e.ReplyTo, "Reply-To"},
-		{&e.To, "To"},
-		{&e.CC, "cc"},
-		{&e.BCC, "bcc"},
+		{&e.From, &e.FromList, "From"},
+		{&e.Sender, &e.SenderList, "Sender"},
+		{&e.ReplyTo, &e.ReplyToList, "Reply-To"},
+		{&e.To, &e.ToList, "To"},
+		{&e.CC, &e.CCList, "cc"},
+		{&e.BCC, &e.BCCList, "bcc"},
 	} {
-		alist, _ := env.AddressList(a.header)
-		(*a.dest) = make(map[string]string, len(alist))
-		for _, addr := range alist {
-			(*a.dest)[strings.ToLower(addr.Address)] = addr.Name
-		}
+		// Parsed from the raw header, as the groups are lost once decoded
+		*a.list, _ = ParseAddressList(env.Root.Header.Get(a.header))
+		*a.dest = a.list.Map()
 	}
 	return true
 }
 
+// charsetFailed matches the text parts the MIME parser could not convert to
+// UTF-8
+func charsetFailed(p *enmime.Part) bool {
+	return strings.HasPrefix(p.ContentType, "text/") && slices.ContainsFunc(p.Errors, func(err *enmime.Error) bool {
+		return err.Name == enmime.ErrorCharsetConversion
+	})
+}
+
+// decodeWords decodes the RFC 2047 encoded words the MIME parser left in s,
+// those in charsets it does not know
+func decodeWords(s string) string {
+	if !strings.Contains(s, "=?") {
+		return s
+	}
+	dec := mime.WordDecoder{CharsetReader: CharsetReader}
+	if decoded, err := dec.DecodeHeader(s); err == nil {
+		return decoded
+	}
+	return s
+}
+
+// headerRecorder passes a message through while keeping a copy of its header
+type headerRecorder struct {
+	r    io.Reader
+	buf  []byte
+	done bool
+}
+
+func (h *headerRecorder) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	if !h.done {
+		// Look for the empty line from just before the new bytes, in case it
+		// straddles two reads
+		from := max(len(h.buf)-3, 0)
+		h.buf = append(h.buf, p[:n]...)
+		if i := bytes.Index(h.buf[from:], []byte("\n\r\n")); i >= 0 {
+			h.buf, h.done = h.buf[:from+i+1], true
+		} else if i := bytes.Index(h.buf[from:], []byte("\n\n")); i >= 0 {
+			h.buf, h.done = h.buf[:from+i+1], true
+		}
+	}
+	return n, err
+}
+
 // unwrapTokens flattens single-child TContainer wrappers that some servers add.
 func unwrapTokens(tks []*Token) []*Token {
 	for len(tks) == 1 && tks[0].Type == TContainer {
@@ -437,9 +682,61 @@ func (d *Dialer) parseEmailRecord(tks []*Token) (*Email, bool, error) {
 	return e, success, nil
 }
 
+// parseEmailMessage is parseEmailRecord for a streamed FETCH response: the
+// BODY[] literal is parsed straight from the connection.
+func (d *Dialer) parseEmailMessage(m *FetchMessage) (*Email, bool, error) {
+	e := &Email{}
+	success := true
+	for {
+		item, err := m.Next()
+		if err == io.EOF {
+			return e, success, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+		switch item.Name {
+		case "BODY[]":
+			r := item.Body
+			if r == nil {
+				if err := d.CheckType(item.Value, []TType{TAtom, TQuoted}, nil, "after BODY[]"); err != nil {
+					return nil, false, err
+				}
+				r = strings.NewReader(item.Value.Str)
+			}
+			if !d.parseEmailReader(e, r) {
+				success = false
+			}
+		case "UID":
+			if err := d.CheckType(item.Value, []TType{TNumber}, nil, "after UID"); err != nil {
+				return nil, false, err
+			}
+			e.UID = item.Value.Num
+		}
+	}
+}
+
 // GetEmails retrieves full email messages including body content
 func (d *Dialer) GetEmails(uids ...int) (emails map[int]*Email, err error) {
-	emails, err = d.GetOverviews(uids...)
+	return d.GetEmailsContext(context.Background(), uids...)
+}
+
+// GetEmailsContext is like GetEmails but honors ctx
+func (d *Dialer) GetEmailsContext(ctx context.Context, uids ...int) (emails map[int]*Email, err error) {
+	return d.GetEmailsSetContext(ctx, uidSetOrAll(uids))
+}
+
+// GetEmailsSet is like GetEmails but takes a UIDSet. A set too long for the
+// connection's MaxLineLength is fetched with several commands.
+func (d *Dialer) GetEmailsSet(set UIDSet) (emails map[int]*Email, err error) {
+	return d.GetEmailsSetContext(context.Background(), set)
+}
+
+// GetEmailsSetContext is like GetEmailsSet but honors ctx. A body response
+// that cannot be parsed fails the call with an ErrorProtocol error and the
+// emails collected so far; it is not retried, since the server would send
+// the same response again, and the connection stays open.
+func (d *Dialer) GetEmailsSetContext(ctx context.Context, set UIDSet) (emails map[int]*Email, err error) {
+	emails, err = d.GetOverviewsSetContext(ctx, set)
 	if err != nil {
 		return nil, err
 	}
@@ -448,78 +745,77 @@ func (d *Dialer) GetEmails(uids ...int) (emails map[int]*Email, err error) {
 		return emails, err
 	}
 
-	uidsStr := strings.Builder{}
-	if len(uids) == 0 {
-		uidsStr.WriteString("1:*")
-	} else {
-		i := 0
+	// Only fetch bodies for the messages that exist
+	if !set.Dynamic() {
+		set = UIDSet{}
 		for u := range emails {
-			if u == 0 {
-				continue
+			if u != 0 {
+				set.AddNum(u)
 			}
-
-			if i != 0 {
-				uidsStr.WriteByte(',')
-			}
-			uidsStr.WriteString(strconv.Itoa(u))
-			i++
 		}
 	}
 
-	var records [][]*Token
-	err = retry.Retry(func() (err error) {
-		r, err := d.Exec("UID FETCH "+uidsStr.String()+" BODY.PEEK[]", true, 0, nil)
-		if err != nil {
-			return err
+	// Each body is parsed as it arrives rather than buffering the whole
+	// response
+	d.lock()
+	defer d.unlock()
+	for _, chunk := range d.splitUIDs(set, len("UID FETCH  BODY.PEEK[]")) {
+		if err := d.fetchStreamLocked(ctx, "UID FETCH "+chunk.String()+" BODY.PEEK[]", true, d.collectEmail(emails)); err != nil {
+			return emails, err
 		}
+	}
+	return emails, nil
+}
 
-		records, err = d.ParseFetchResponse(r)
+// collectEmail returns a FetchStream callback that parses a BODY[] response
+// into the matching entry of emails
+func (d *Dialer) collectEmail(emails map[int]*Email) func(m *FetchMessage) error {
+	return func(m *FetchMessage) error {
+		e, success, err := d.parseEmailMessage(m)
 		if err != nil {
+			d.errorLog("fetch failed", "error", err)
 			return err
 		}
-
-		for _, tks := range records {
-			e, success, err := d.parseEmailRecord(tks)
-			if err != nil {
-				return err
-			}
-			if success {
-				if emails[e.UID] == nil {
-					emails[e.UID] = &Email{UID: e.UID}
-				}
-				emails[e.UID].Subject = e.Subject
-				emails[e.UID].From = e.From
-				emails[e.UID].ReplyTo = e.ReplyTo
-				emails[e.UID].To = e.To
-				emails[e.UID].CC = e.CC
-				emails[e.UID].BCC = e.BCC
-				emails[e.UID].Text = e.Text
-				emails[e.UID].HTML = e.HTML
-				emails[e.UID].Attachments = e.Attachments
-			} else {
-				delete(emails, e.UID)
-			}
+		if e.UID == 0 {
+			// A flag change for some other message
+			return nil
 		}
-		return err
-	}, RetryCount, func(err error) error {
-		errorLog(d.ConnNum, d.Folder, "fetch failed", "error", err)
-		_ = d.Close()
+		if !success {
+			delete(emails, e.UID)
+			return nil
+		}
+		if emails[e.UID] == nil {
+			emails[e.UID] = &Email{UID: e.UID}
+		}
+		emails[e.UID].Subject = e.Subject
+		emails[e.UID].Sent = e.Sent
+		emails[e.UID].RawDate = e.RawDate
+		emails[e.UID].SentErr = e.SentErr
+		emails[e.UID].InReplyTo = e.InReplyTo
+		emails[e.UID].References = e.References
+		emails[e.UID].From = e.From
+		emails[e.UID].Sender = e.Sender
+		emails[e.UID].ReplyTo = e.ReplyTo
+		emails[e.UID].To = e.To
+		emails[e.UID].CC = e.CC
+		emails[e.UID].BCC = e.BCC
+		emails[e.UID].FromList = e.FromList
+		emails[e.UID].SenderList = e.SenderList
+		emails[e.UID].ReplyToList = e.ReplyToList
+		emails[e.UID].ToList = e.ToList
+		emails[e.UID].CCList = e.CCList
+		emails[e.UID].BCCList = e.BCCList
+		emails[e.UID].Text = e.Text
+		emails[e.UID].HTML = e.HTML
+		emails[e.UID].Attachments = e.Attachments
+		emails[e.UID].Header = e.Header
 		return nil
-	}, func() error {
-		return d.Reconnect()
-	})
-
-	return emails, err
+	}
 }
 
 // parseEnvelope extracts envelope data (date, subject, addresses, message-id) from an ENVELOPE token.
 func (d *Dialer) parseEnvelope(e *Email, envelopeToken *Token, tks []*Token) error {
-	charsetReader := func(label string, input io.Reader) (io.Reader, error) {
-		label = strings.ReplaceAll(label, "windows-", "cp")
-		encoding, _ := charset.Lookup(label)
-		return encoding.NewDecoder().Reader(input), nil
-	}
-	dec := mime.WordDecoder{CharsetReader: charsetReader}
+	dec := mime.WordDecoder{CharsetReader: CharsetReader}
 
 	if err := d.CheckType(envelopeToken, []TType{TContainer}, tks, "after ENVELOPE"); err != nil {
 		return err
@@ -531,8 +827,8 @@ func (d *Dialer) parseEnvelope(e *Email, envelopeToken *Token, tks []*Token) err
 		return err
 	}
 
-	e.Sent, _ = time.Parse("Mon, _2 Jan 2006 15:04:05 -0700", envelopeToken.Tokens[EDate].Str)
-	e.Sent = e.Sent.UTC()
+	e.RawDate = envelopeToken.Tokens[EDate].Str
+	d.parseSent(e)
 
 	var err error
 	e.Subject, err = dec.DecodeHeader(envelopeToken.Tokens[ESubject].Str)
@@ -542,33 +838,74 @@ func (d *Dialer) parseEnvelope(e *Email, envelopeToken *Token, tks []*Token) err
 
 	for _, a := range []struct {
 		dest  *EmailAddresses
+		list  *AddressList
 		pos   uint8
 		debug string
 	}{
-		{&e.From, EFrom, "FROM"},
-		{&e.ReplyTo, EReplyTo, "REPLYTO"},
-		{&e.To, ETo, "TO"},
-		{&e.CC, ECC, "CC"},
-		{&e.BCC, EBCC, "BCC"},
+		{&e.From, &e.FromList, EFrom, "FROM"},
+		{&e.Sender, &e.SenderList, ESender, "SENDER"},
+		{&e.ReplyTo, &e.ReplyToList, EReplyTo, "REPLYTO"},
+		{&e.To, &e.ToList, ETo, "TO"},
+		{&e.CC, &e.CCList, ECC, "CC"},
+		{&e.BCC, &e.BCCList, EBCC, "BCC"},
 	} {
-		if err := d.parseEnvelopeAddresses(a.dest, envelopeToken.Tokens[a.pos], &dec, tks, a.debug); err != nil {
+		if err := d.parseEnvelopeAddresses(a.list, envelopeToken.Tokens[a.pos], &dec, tks, a.debug); err != nil {
 			return err
 		}
+		if *a.list != nil {
+			*a.dest = a.list.Map()
+		}
 	}
 
+	e.InReplyTo = envelopeToken.Tokens[EInReplyTo].Str
 	e.MessageID = envelopeToken.Tokens[EMessageID].Str
 	return nil
 }
 
+// parseSent parses e.RawDate into e.Sent, recording any error in e.SentErr.
+// A message without a date has a zero Sent and no error.
+func (d *Dialer) parseSent(e *Email) {
+	e.Sent, e.SentErr = time.Time{}, nil
+	if strings.TrimSpace(e.RawDate) == "" {
+		return
+	}
+	if e.Sent, e.SentErr = ParseDate(e.RawDate); e.SentErr != nil {
+		d.debugLog("unparseable date", "date", e.RawDate, "error", e.SentErr)
+	}
+}
+
+// parseMessageIDs returns the message IDs in a References or In-Reply-To
+// header, e.g. "<1@example.com> <2@example.com>". IDs missing their angle
+// brackets are split on white space and commas.
+func parseMessageIDs(s string) []string {
+	var ids []string
+	for rest := s; ; {
+		start := strings.IndexByte(rest, '<')
+		end := strings.IndexByte(rest[max(start, 0):], '>')
+		if start < 0 || end < 0 {
+			break
+		}
+		ids = append(ids, strings.Join(strings.Fields(rest[start:start+end+1]), ""))
+		rest = rest[start+end+1:]
+	}
+	if ids == nil {
+		ids = strings.FieldsFunc(s, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+	}
+	return ids
+}
+
 // parseEnvelopeAddresses parses a single address-list field from an ENVELOPE token.
-func (d *Dialer) parseEnvelopeAddresses(dest *EmailAddresses, addrToken *Token, dec *mime.WordDecoder, tks []*Token, debug string) error {
+// Groups are marked as in RFC 3501 §7.4.2: an address with a NIL host and the
+// group name as mailbox starts one, and one with a NIL host and mailbox ends it.
+func (d *Dialer) parseEnvelopeAddresses(dest *AddressList, addrToken *Token, dec *mime.WordDecoder, tks []*Token, debug string) error {
 	if addrToken.Type == TNil {
 		return nil
 	}
 	if err := d.CheckType(addrToken, []TType{TNil, TContainer}, tks, "for ENVELOPE address %s", debug); err != nil {
 		return err
 	}
-	*dest = make(map[string]string, len(addrToken.Tokens))
+	*dest = make(AddressList, 0, len(addrToken.Tokens))
+	group, members := "", 0
 	for i, t := range addrToken.Tokens {
 		if err := d.CheckType(t.Tokens[EEName], []TType{TQuoted, TAtom, TNil}, tks, "for %s[%d][%d]", debug, i, EEName); err != nil {
 			return err
@@ -592,7 +929,18 @@ func (d *Dialer) parseEnvelopeAddresses(dest *EmailAddresses, addrToken *Token,
 		if err != nil {
 			return err
 		}
-		(*dest)[strings.ToLower(mailbox+"@"+host)] = name
+		switch {
+		case t.Tokens[EEHost].Type != TNil:
+			*dest = append(*dest, Address{Name: name, Mailbox: mailbox, Host: host, Group: group})
+			members++
+		case t.Tokens[EEMailbox].Type != TNil:
+			group, members = mailbox, 0
+		default:
+			if members == 0 && group != "" {
+				*dest = append(*dest, Address{Group: group})
+			}
+			group = ""
+		}
 	}
 	return nil
 }
@@ -617,7 +965,7 @@ func (d *Dialer) parseOverviewField(e *Email, tks []*Token, i int, fieldName str
 		if err = d.CheckType(tks[i+1], []TType{TQuoted}, tks, "after INTERNALDATE"); err != nil {
 			return 0, err
 		}
-		e.Received, err = time.Parse(TimeFormat, tks[i+1].Str)
+		e.Received, err = parseInternalDate(tks[i+1].Str)
 		if err != nil {
 			return 0, err
 		}
@@ -668,60 +1016,73 @@ func (d *Dialer) parseOverviewRecord(tks []*Token) (*Email, error) {
 
 // GetOverviews retrieves email overview information (headers, flags, etc.)
 func (d *Dialer) GetOverviews(uids ...int) (emails map[int]*Email, err error) {
-	uidsStr := strings.Builder{}
-	if len(uids) == 0 {
-		uidsStr.WriteString("1:*")
-	} else {
-		for i, u := range uids {
-			if u == 0 {
-				continue
-			}
+	return d.GetOverviewsContext(context.Background(), uids...)
+}
 
-			if i != 0 {
-				uidsStr.WriteByte(',')
-			}
-			uidsStr.WriteString(strconv.Itoa(u))
+// GetOverviewsContext is like GetOverviews but honors ctx
+func (d *Dialer) GetOverviewsContext(ctx context.Context, uids ...int) (emails map[int]*Email, err error) {
+	return d.GetOverviewsSetContext(ctx, uidSetOrAll(uids))
+}
+
+// GetOverviewsSet is like GetOverviews but takes a UIDSet. A set too long for
+// the connection's MaxLineLength is fetched with several commands.
+func (d *Dialer) GetOverviewsSet(set UIDSet) (emails map[int]*Email, err error) {
+	return d.GetOverviewsSetContext(context.Background(), set)
+}
+
+// GetOverviewsSetContext is like GetOverviewsSet but honors ctx
+func (d *Dialer) GetOverviewsSetContext(ctx context.Context, set UIDSet) (emails map[int]*Email, err error) {
+	emails = make(map[int]*Email)
+	for _, chunk := range d.splitUIDs(set, len("UID FETCH  ALL")) {
+		if chunk.IsEmpty() {
+			continue
+		}
+		if err := d.getOverviews(ctx, chunk, emails); err != nil {
+			return nil, err
 		}
 	}
+	return emails, nil
+}
 
+// getOverviews fetches the overviews of the messages in set into emails
+func (d *Dialer) getOverviews(ctx context.Context, set UIDSet, emails map[int]*Email) error {
 	var records [][]*Token
-	err = retry.Retry(func() (err error) {
-		r, err := d.Exec("UID FETCH "+uidsStr.String()+" ALL", true, 0, nil)
+	d.lock()
+	err := d.retry(ctx, true, -1, func(n int) (bool, error) {
+		if n > 1 {
+			if err := d.ensureConnectedLocked(ctx); err != nil {
+				return false, err
+			}
+		}
+		r, err := d.execLocked(ctx, "UID FETCH "+set.String()+" ALL", true, true, 0, nil)
 		if err != nil {
-			return err
+			return true, err
 		}
 
 		if len(r) == 0 {
-			return err
+			return true, nil
 		}
 
 		records, err = d.ParseFetchResponse(r)
 		if err != nil {
-			return err
+			d.errorLog("fetch failed", "error", err)
+			return true, err
 		}
-		return err
-	}, RetryCount, func(err error) error {
-		errorLog(d.ConnNum, d.Folder, "fetch failed", "error", err)
-		_ = d.Close()
-		return nil
-	}, func() error {
-		return d.Reconnect()
+		return true, nil
 	})
+	d.unlock()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	emails = make(map[int]*Email, len(uids))
-
 	for _, tks := range records {
 		e, err := d.parseOverviewRecord(tks)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		if e.UID > 0 {
 			emails[e.UID] = e
 		}
 	}
-
-	return emails, nil
+	return nil
 }
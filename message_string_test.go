@@ -684,7 +684,7 @@ func TestParseOverviewRecord_WrappedContainer(t *testing.T) {
 func TestParseEnvelopeAddresses_NilToken(t *testing.T) {
 	t.Parallel()
 	d := &Dialer{}
-	dest := &EmailAddresses{}
+	dest := &AddressList{}
 	err := d.parseEnvelopeAddresses(dest, &Token{Type: TNil}, nil, nil, "TEST")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -694,7 +694,7 @@ func TestParseEnvelopeAddresses_NilToken(t *testing.T) {
 func TestParseEnvelopeAddresses_MultipleAddresses(t *testing.T) {
 	t.Parallel()
 	d := &Dialer{}
-	dest := &EmailAddresses{}
+	dest := &AddressList{}
 	dec := &mime.WordDecoder{}
 	addrToken := &Token{Type: TContainer, Tokens: []*Token{
 		{Type: TContainer, Tokens: []*Token{
@@ -717,11 +717,12 @@ func TestParseEnvelopeAddresses_MultipleAddresses(t *testing.T) {
 	if len(*dest) != 2 {
 		t.Errorf("expected 2 addresses, got %d", len(*dest))
 	}
-	if (*dest)["alice@example.com"] != "Alice" {
-		t.Errorf("expected Alice, got %q", (*dest)["alice@example.com"])
+	m := dest.Map()
+	if m["alice@example.com"] != "Alice" {
+		t.Errorf("expected Alice, got %q", m["alice@example.com"])
 	}
-	if (*dest)["bob@example.com"] != "Bob" {
-		t.Errorf("expected Bob, got %q", (*dest)["bob@example.com"])
+	if m["bob@example.com"] != "Bob" {
+		t.Errorf("expected Bob, got %q", m["bob@example.com"])
 	}
 }
 
@@ -1,6 +1,7 @@
 package imap
 
 import (
+	"fmt"
 	"io"
 	"mime"
 	"strings"
@@ -259,3 +260,64 @@ func TestEnvelopeAtomAddress(t *testing.T) {
 		t.Fatalf("got %q want %q", addr, name)
 	}
 }
+
+func TestParseEnvelope_ReplyFields(t *testing.T) {
+	t.Parallel()
+	tks, err := parseFetchTokens(`ENVELOPE ("9 Apr 2026 21:06:17 GMT" "Re: x" (("Ann" NIL "ann" "example.com")) ` +
+		`(("List" NIL "list-bounces" "example.org")) NIL NIL NIL NIL "<1@example.com>" "<2@example.com>")`)
+	if err != nil {
+		t.Fatalf("parseFetchTokens() error = %v", err)
+	}
+	d := &Dialer{}
+	e := &Email{}
+	if err := d.parseEnvelope(e, tks[1], tks); err != nil {
+		t.Fatalf("parseEnvelope() error = %v", err)
+	}
+	if e.RawDate != "9 Apr 2026 21:06:17 GMT" || e.InReplyTo != "<1@example.com>" || e.MessageID != "<2@example.com>" {
+		t.Errorf("RawDate = %q, InReplyTo = %q, MessageID = %q", e.RawDate, e.InReplyTo, e.MessageID)
+	}
+	if e.SenderList.String() != "List <list-bounces@example.org>" || e.Sender["list-bounces@example.org"] != "List" {
+		t.Errorf("Sender = %v, SenderList = %v", e.Sender, e.SenderList)
+	}
+}
+
+func TestParseEmailBody_ReplyFields(t *testing.T) {
+	t.Parallel()
+	d := &Dialer{}
+	e := &Email{}
+	body := "Date: Thu, 9 Apr 2026 21:06:17 +0000 (UTC)\r\nFrom: ann@example.com\r\nSender: bot@example.com\r\n" +
+		"In-Reply-To: <2@example.com>\r\nReferences: <1@example.com>\r\n <2@example.com>\r\nSubject: Re: x\r\n\r\nHi"
+	if !d.parseEmailBody(e, body) {
+		t.Fatal("expected parsing to succeed")
+	}
+	if e.RawDate != "Thu, 9 Apr 2026 21:06:17 +0000 (UTC)" || e.InReplyTo != "<2@example.com>" {
+		t.Errorf("RawDate = %q, InReplyTo = %q", e.RawDate, e.InReplyTo)
+	}
+	if e.Sent.Unix() != 1775768777 || e.SentErr != nil {
+		t.Errorf("Sent = %v, SentErr = %v", e.Sent, e.SentErr)
+	}
+	if len(e.References) != 2 || e.References[0] != "<1@example.com>" || e.References[1] != "<2@example.com>" {
+		t.Errorf("References = %q", e.References)
+	}
+	if _, ok := e.Sender["bot@example.com"]; !ok || len(e.SenderList) != 1 {
+		t.Errorf("Sender = %v", e.Sender)
+	}
+}
+
+func TestParseMessageIDs(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		in   string
+		want string
+	}{
+		{"<a@x> <b@y>", "[<a@x> <b@y>]"},
+		{"<a@x>,<b@y> (comment)", "[<a@x> <b@y>]"},
+		{"<a@\r\n x>", "[<a@x>]"},
+		{"a@x, b@y", "[a@x b@y]"},
+		{"", "[]"},
+	} {
+		if got := fmt.Sprint(parseMessageIDs(tt.in)); got != tt.want {
+			t.Errorf("parseMessageIDs(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
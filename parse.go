@@ -18,6 +18,7 @@ var (
 	atom             = regexp.MustCompile(`{\d+\+?}$`)
 	fetchLineStartRE = regexp.MustCompile(`(?m)^\* \d+ FETCH`)
 	searchMaxUIDRE   = regexp.MustCompile(`(?i)\* ESEARCH .* MAX (\d+)`)
+	searchAllRE      = regexp.MustCompile(`(?i)\* ESEARCH .* ALL (\S+)`)
 )
 
 // Token represents a parsed IMAP token
@@ -486,6 +487,12 @@ func (d *Dialer) ParseFetchResponse(responseBody string) (records [][]*Token, er
 
 // parseUIDSearchResponse parses UID SEARCH command responses
 func parseUIDSearchResponse(r string) ([]int, error) {
+	return parseNumberList(r, "SEARCH")
+}
+
+// parseNumberList parses the numbers of the first untagged name response,
+// e.g. "* SEARCH 1 2 3" or "* SORT 3 1 2"
+func parseNumberList(r, name string) ([]int, error) {
 	normalized := strings.ReplaceAll(r, nl, "\n")
 	for rawLine := range strings.SplitSeq(normalized, "\n") {
 		line := strings.TrimSpace(rawLine)
@@ -494,7 +501,7 @@ func parseUIDSearchResponse(r string) ([]int, error) {
 		}
 
 		fields := strings.Fields(line)
-		if len(fields) < 2 || fields[0] != "*" || !strings.EqualFold(fields[1], "SEARCH") {
+		if len(fields) < 2 || fields[0] != "*" || !strings.EqualFold(fields[1], name) {
 			continue
 		}
 
@@ -559,6 +566,22 @@ func parseMaxUIDSearchResponse(r string) (int, error) {
 	return 0, fmt.Errorf("no ESEARCH line. rfc4731 not supported?")
 }
 
+// parseSearchAllResponse parses the ALL set of an ESEARCH response. An
+// ESEARCH response without ALL means nothing matched (RFC 4731).
+func parseSearchAllResponse(r string) (UIDSet, error) {
+	normalized := strings.ReplaceAll(r, nl, "\n")
+	for rawLine := range strings.SplitSeq(normalized, "\n") {
+		line := strings.TrimSpace(rawLine)
+		if matches := searchAllRE.FindStringSubmatch(line); len(matches) > 1 {
+			return ParseUIDSet(matches[1])
+		}
+		if len(line) > 2 && line[:2] == "* " && strings.Contains(strings.ToUpper(line), "ESEARCH") {
+			return UIDSet{}, nil
+		}
+	}
+	return UIDSet{}, fmt.Errorf("no ESEARCH line. rfc4731 not supported?")
+}
+
 // IsLiteral checks if a rune is valid for a literal token.
 //
 // This matches RFC 3501 ATOM-CHAR (plus '\' and ']' for flag and
@@ -0,0 +1,247 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime/quotedprintable"
+	"strconv"
+	"strings"
+)
+
+// GetEmailsLazy is like GetEmails, but downloads only the header and the text
+// and HTML bodies of each message. Attachments and inline parts are described by
+// their name, type, size, disposition, Content-ID and Path, without Content;
+// download the ones you need with FetchPart. The message structure is taken
+// from BODYSTRUCTURE, so messages too large to download whole are cheap to
+// list.
+func (d *Dialer) GetEmailsLazy(uids ...int) (map[int]*Email, error) {
+	return d.GetEmailsLazyContext(context.Background(), uids...)
+}
+
+// GetEmailsLazyContext is like GetEmailsLazy but honors ctx
+func (d *Dialer) GetEmailsLazyContext(ctx context.Context, uids ...int) (map[int]*Email, error) {
+	return d.getEmailsLazy(ctx, uidSetOrAll(uids))
+}
+
+// lazyBody is a message whose text parts are still to be fetched
+type lazyBody struct {
+	email      *Email
+	text, html *BodyStructure
+}
+
+// getEmailsLazy fetches the overviews, structure, header and text parts of the
+// messages in set
+func (d *Dialer) getEmailsLazy(ctx context.Context, set UIDSet) (map[int]*Email, error) {
+	emails, err := d.GetOverviewsSetContext(ctx, set)
+	if err != nil || len(emails) == 0 {
+		return emails, err
+	}
+	var found UIDSet
+	for uid := range emails {
+		found.AddNum(uid)
+	}
+	structures, err := d.FetchContext(ctx, found, Fetch().BodyStructure().Header())
+	if err != nil {
+		return emails, err
+	}
+
+	// Messages shaped alike, e.g. with text in 1.1 and HTML in 1.2, have
+	// their text fetched together
+	groups := make(map[string]*UIDSet)
+	bodies := make(map[int]lazyBody)
+	for uid, r := range structures {
+		e := emails[uid]
+		if e == nil || r.BodyStructure == nil {
+			continue
+		}
+		e.Header = r.Header()
+		e.References = e.Header.MessageIDs("References")
+		b := lazyBody{e, r.BodyStructure.BestText("plain"), r.BodyStructure.BestText("html")}
+		e.Attachments = lazyAttachments(r.BodyStructure, b.text, b.html)
+		var sections []string
+		for _, p := range []*BodyStructure{b.text, b.html} {
+			if p != nil {
+				sections = append(sections, p.Section())
+			}
+		}
+		if len(sections) == 0 {
+			continue
+		}
+		key := strings.Join(sections, " ")
+		if groups[key] == nil {
+			groups[key] = &UIDSet{}
+		}
+		groups[key].AddNum(uid)
+		bodies[uid] = b
+	}
+
+	for key, uids := range groups {
+		items := Fetch()
+		for section := range strings.FieldsSeq(key) {
+			items.Section(section)
+		}
+		results, err := d.FetchContext(ctx, *uids, items)
+		if err != nil {
+			return emails, err
+		}
+		for uid, r := range results {
+			b, ok := bodies[uid]
+			if !ok {
+				continue
+			}
+			if b.text != nil {
+				b.email.Text = decodeText(r.Section(b.text.Section()), b.text)
+			}
+			if b.html != nil {
+				b.email.HTML = decodeText(r.Section(b.html.Section()), b.html)
+			}
+		}
+	}
+	return emails, nil
+}
+
+// lazyAttachments describes the parts of bs other than the text and html
+// bodies: attachments, and inline parts such as images
+func lazyAttachments(bs, text, html *BodyStructure) []Attachment {
+	var attachments []Attachment
+	bs.Walk(func(p *BodyStructure) bool {
+		switch {
+		case p == text, p == html, p.IsMultipart():
+			return true
+		case !p.IsAttachment() && (p.MIMEType == "text" || p.MediaType() == "message/rfc822"):
+			// An alternative not chosen, or a forwarded message whose parts
+			// are listed instead
+			return true
+		}
+		attachments = append(attachments, Attachment{
+			Name:        p.Filename(),
+			MimeType:    p.MediaType(),
+			Size:        p.Size,
+			Disposition: p.Disposition,
+			ContentID:   strings.Trim(p.ID, "<>"),
+			Path:        p.Path,
+		})
+		return false
+	})
+	return attachments
+}
+
+// decodeText removes the content transfer encoding of a text part and
+// converts it to UTF-8 with CharsetReader
+func decodeText(b []byte, part *BodyStructure) string {
+	decoded, err := io.ReadAll(decodeTransfer(bytes.NewReader(b), part.Encoding))
+	if err != nil {
+		decoded = b
+	}
+	return decodeCharset(decoded, part.Params["charset"])
+}
+
+// decodeTransfer returns a reader that removes the content transfer encoding
+// from r. Encodings other than base64 and quoted-printable are identities.
+func decodeTransfer(r io.Reader, encoding string) io.Reader {
+	switch strings.ToLower(encoding) {
+	case "base64":
+		// The decoder skips the line breaks
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+// FetchPart downloads one part of a message, such as an attachment described
+// by GetEmailsLazy or a BodyStructure, with its content transfer encoding
+// removed. path is the part number, e.g. "2" or "1.3".
+//
+// With the BINARY extension (RFC 3516) the server decodes the part;
+// otherwise the part's encoding is looked up with BODYSTRUCTURE and removed
+// as it is read. The content is read straight from the connection, which is
+// held until the reader is read to the end or closed, so other commands wait
+// meanwhile. Always close the reader.
+func (d *Dialer) FetchPart(uid int, path string) (io.ReadCloser, error) {
+	return d.FetchPartContext(context.Background(), uid, path)
+}
+
+// FetchPartContext is like FetchPart but honors ctx, which also bounds
+// reading the part
+func (d *Dialer) FetchPartContext(ctx context.Context, uid int, path string) (io.ReadCloser, error) {
+	binary, err := d.HasCapabilityContext(ctx, "BINARY")
+	if err != nil {
+		return nil, err
+	}
+	item, encoding := "BINARY.PEEK["+path+"]", ""
+	if !binary {
+		results, err := d.FetchContext(ctx, UIDSetNum(uid), Fetch().BodyStructure())
+		if err != nil {
+			return nil, err
+		}
+		r := results[uid]
+		if r == nil || r.BodyStructure == nil {
+			return nil, fmt.Errorf("imap: message UID %d not found", uid)
+		}
+		part := r.BodyStructure.Part(path)
+		if part == nil {
+			return nil, fmt.Errorf("imap: message UID %d has no part %q", uid, path)
+		}
+		item, encoding = "BODY.PEEK["+part.Section()+"]", part.Encoding
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(d.streamPart(ctx, uid, item, pw))
+	}()
+	return &partReader{Reader: decodeTransfer(pr, encoding), pipe: pr}, nil
+}
+
+// streamPart fetches item of message uid and copies its value to w
+func (d *Dialer) streamPart(ctx context.Context, uid int, item string, w io.Writer) error {
+	found := false
+	copyPart := func(m *FetchMessage) error {
+		for {
+			it, err := m.Next()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			switch {
+			case found, !strings.HasPrefix(it.Name, "BODY[") && !strings.HasPrefix(it.Name, "BINARY["):
+				continue
+			case it.Body != nil:
+				_, err = io.Copy(w, it.Body)
+			case it.Value.Type == TQuoted, it.Value.Type == TAtom:
+				_, err = io.WriteString(w, it.Value.Str)
+			}
+			if err != nil {
+				return err
+			}
+			found = true
+		}
+	}
+
+	d.lock()
+	defer d.unlock()
+	// The part may be partly written when a retry starts, so only a command
+	// that never reached the server is retried
+	if err := d.fetchStreamLocked(ctx, "UID FETCH "+strconv.Itoa(uid)+" ("+item+")", false, copyPart); err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("imap: message UID %d not found", uid)
+	}
+	return nil
+}
+
+// partReader is the io.ReadCloser returned by FetchPart
+type partReader struct {
+	io.Reader
+	pipe *io.PipeReader
+}
+
+// Close stops the download; the rest of the response is discarded
+func (p *partReader) Close() error {
+	return p.pipe.Close()
+}
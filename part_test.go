@@ -0,0 +1,106 @@
+package imap
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// lazyStructure is a message with text and HTML alternatives, an attached
+// PDF and an inline image
+const lazyStructure = `((("TEXT" "PLAIN" ("CHARSET" "iso-8859-1") NIL NIL "QUOTED-PRINTABLE" 9 1)` +
+	`("TEXT" "HTML" ("CHARSET" "utf-8") NIL NIL "BASE64" 24 1) "ALTERNATIVE")` +
+	`("APPLICATION" "PDF" NIL NIL NIL "BASE64" 5000 NIL ("ATTACHMENT" ("FILENAME" "report.pdf")) NIL NIL)` +
+	`("IMAGE" "PNG" NIL "<logo>" NIL "BASE64" 300 NIL ("INLINE" NIL) NIL NIL) "MIXED")`
+
+func TestGetEmailsLazy(t *testing.T) {
+	d, server := setupTestDialer(t)
+	server.responses["UID FETCH 7 ALL"] = overviewResponse(7)
+	server.responses["UID FETCH 7 (UID BODYSTRUCTURE BODY.PEEK[HEADER])"] = "* 1 FETCH (UID 7 BODYSTRUCTURE " + lazyStructure +
+		" BODY[HEADER] {57}\r\nSubject: message 7\r\nList-Id: <x.y>\r\nReferences: <a@b>\r\n\r\n)\r\n"
+	html := base64.StdEncoding.EncodeToString([]byte("<p>café</p>"))
+	server.responses["UID FETCH 7 (UID BODY.PEEK[1.1] BODY.PEEK[1.2])"] = fmt.Sprintf(
+		"* 1 FETCH (UID 7 BODY[1.1] {10}\r\ncaf=E9 =\r\n BODY[1.2] {%d}\r\n%s)\r\n", len(html), html)
+
+	emails, err := d.GetEmailsLazy(7)
+	if err != nil {
+		t.Fatalf("GetEmailsLazy() error = %v", err)
+	}
+	e := emails[7]
+	if e == nil {
+		t.Fatalf("GetEmailsLazy() = %v, want UID 7", emails)
+	}
+	if e.Subject != "message 7" || e.Text != "café " || e.HTML != "<p>café</p>" {
+		t.Errorf("Subject = %q, Text = %q, HTML = %q", e.Subject, e.Text, e.HTML)
+	}
+	if e.Header.Get("list-id") != "<x.y>" || fmt.Sprint(e.References) != "[<a@b>]" {
+		t.Errorf("Header = %q, References = %q", e.Header, e.References)
+	}
+	want := []Attachment{
+		{Name: "report.pdf", MimeType: "application/pdf", Size: 5000, Disposition: "attachment", Path: "2"},
+		{MimeType: "image/png", Size: 300, Disposition: "inline", ContentID: "logo", Path: "3"},
+	}
+	if fmt.Sprintf("%+v", e.Attachments) != fmt.Sprintf("%+v", want) {
+		t.Errorf("Attachments = %+v\nwant %+v", e.Attachments, want)
+	}
+	if n := countCommands(server, "UID FETCH 7 BODY.PEEK[]"); n != 0 {
+		t.Errorf("downloaded the whole message %d times", n)
+	}
+}
+
+func TestFetchPart_Decoded(t *testing.T) {
+	d, server := setupTestDialer(t)
+	server.responses["UID FETCH 7 (UID BODYSTRUCTURE)"] = "* 1 FETCH (UID 7 BODYSTRUCTURE " + lazyStructure + ")\r\n"
+	content := strings.Repeat("%PDF-1.7 ", 20)
+	encoded := base64.StdEncoding.EncodeToString([]byte(content))
+	encoded = encoded[:76] + "\r\n" + encoded[76:]
+	server.responses["UID FETCH 7 (BODY.PEEK[2])"] = fmt.Sprintf("* 1 FETCH (UID 7 BODY[2] {%d}\r\n%s)\r\n", len(encoded), encoded)
+
+	r, err := d.FetchPart(7, "2")
+	if err != nil {
+		t.Fatalf("FetchPart() error = %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading the part: %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if string(got) != content {
+		t.Errorf("FetchPart() read %q", got)
+	}
+
+	if _, err := d.FetchPart(7, "9"); err == nil {
+		t.Error("FetchPart() of a missing part succeeded")
+	}
+}
+
+func TestFetchPart_Binary(t *testing.T) {
+	d, server := dialWithCapabilities(t, "IMAP4rev1 BINARY")
+	server.responses["UID FETCH 7 (BINARY.PEEK[2])"] = "* 1 FETCH (UID 7 BINARY[2] ~{11}\r\nhello world)\r\n"
+
+	r, err := d.FetchPart(7, "2")
+	if err != nil {
+		t.Fatalf("FetchPart() error = %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("read %q, %v", buf, err)
+	}
+	// Closing early discards the rest and frees the connection
+	if err := r.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if _, err := d.Exec("NOOP", false, 0, nil); err != nil {
+		t.Errorf("NOOP after Close: %v", err)
+	}
+	if !d.Connected {
+		t.Error("closing the part should not close the connection")
+	}
+	if n := countCommands(server, "UID FETCH 7 (UID BODYSTRUCTURE)"); n != 0 {
+		t.Errorf("looked up the structure %d times with BINARY", n)
+	}
+}
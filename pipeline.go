@@ -0,0 +1,323 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// pipelineWindow caps the number of commands in flight, so that writing more
+// commands cannot stall behind responses that have not been read yet
+const pipelineWindow = 64
+
+// pipelineBarriers are commands that change the connection or mailbox state.
+// They are never in flight together with another command.
+var pipelineBarriers = map[string]bool{
+	"APPEND":       true,
+	"AUTHENTICATE": true,
+	"CLOSE":        true,
+	"COMPRESS":     true,
+	"ENABLE":       true,
+	"EXAMINE":      true,
+	"IDLE":         true,
+	"LOGIN":        true,
+	"LOGOUT":       true,
+	"SELECT":       true,
+	"STARTTLS":     true,
+	"UNSELECT":     true,
+}
+
+// Pipeline sends several commands without waiting for each to complete, which
+// saves a round trip per command on high-latency links. Create one with
+// Dialer.Pipeline, add commands, then call Run:
+//
+//	p := conn.Pipeline()
+//	for _, uid := range uids {
+//		p.Add(fmt.Sprintf(`UID STORE %d +FLAGS.SILENT (\Seen)`, uid))
+//	}
+//	if err := p.Run(ctx); err != nil {
+//		// the first failed command's error; see each command's Err
+//	}
+//
+// Commands are written back to back and their completions are matched by tag.
+// Following RFC 3501 §5.5, Run waits for outstanding commands where
+// pipelining would be ambiguous: before a command that uses message sequence
+// numbers (FETCH, STORE, SEARCH, COPY, MOVE) while a command that may expunge
+// messages is outstanding, between a STORE and a command that reads flags
+// (a FETCH of FLAGS or a SEARCH such as UNSEEN) in either order, and around
+// commands that change state (SELECT, EXAMINE, CLOSE, APPEND, ...) or carry
+// a literal.
+//
+// Pipelined commands are not retried, and a pipelined SELECT or EXAMINE is
+// not tracked in Dialer.Folder; use SelectFolder instead. A Pipeline is not
+// safe for concurrent use and Run may be called only once; the Dialer itself
+// remains available to other goroutines, which wait until Run returns.
+type Pipeline struct {
+	d        *Dialer
+	commands []*PipelinedCommand
+}
+
+// PipelinedCommand is a command added to a Pipeline. Response and Err are set
+// by Run.
+type PipelinedCommand struct {
+	// Command is the command text without the tag
+	Command string
+	// ProcessLine, if set, is called for each untagged response attributed to
+	// the command instead of collecting it in Response. Untagged responses
+	// are attributed to the oldest command still in flight.
+	ProcessLine func(line []byte) error
+	// Response holds the untagged responses attributed to the command
+	Response string
+	// Err is the command's error, or nil if it completed with OK
+	Err error
+
+	tag       []byte
+	cmd       *pendingCommand
+	resp      strings.Builder
+	expired   <-chan time.Time
+	stopTimer func()
+}
+
+// Pipeline returns an empty pipeline for this connection
+func (d *Dialer) Pipeline() *Pipeline {
+	return &Pipeline{d: d}
+}
+
+// Add queues command and returns a handle for its result
+func (p *Pipeline) Add(command string) *PipelinedCommand {
+	c := &PipelinedCommand{Command: command}
+	p.commands = append(p.commands, c)
+	return c
+}
+
+// Len returns the number of queued commands
+func (p *Pipeline) Len() int {
+	return len(p.commands)
+}
+
+// Run sends the queued commands and waits for all of them to complete. It
+// returns the error of the first command that failed, in the order the
+// commands were added. If the connection fails, commands that were not sent
+// report the same error and the connection is closed.
+func (p *Pipeline) Run(ctx context.Context) error {
+	d := p.d
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	d.lock()
+	defer d.unlock()
+
+	var failed error // set when the connection can no longer be used
+	if err := d.ensureConnectedLocked(ctx); err != nil {
+		failed = err
+	}
+
+	var inflight []*PipelinedCommand
+	drain := func(max int) {
+		for len(inflight) > max {
+			c := inflight[0]
+			inflight = inflight[1:]
+			if failed != nil {
+				d.reader.release(c.cmd)
+				c.stopTimer()
+				c.Err = failed
+				continue
+			}
+			if err := d.completePipelined(ctx, c); err != nil && c.Err == nil {
+				failed = err
+				c.Err = err
+			}
+		}
+	}
+
+	for _, c := range p.commands {
+		if failed == nil && len(inflight) > 0 && pipelineMustWait(c.Command, inflight) {
+			drain(0)
+		}
+		drain(pipelineWindow - 1)
+		if failed != nil {
+			c.Err = failed
+			continue
+		}
+		if err := d.sendPipelined(ctx, c); err != nil {
+			failed = err
+			c.Err = err
+			continue
+		}
+		inflight = append(inflight, c)
+		if pipelineBarrier(c.Command) {
+			drain(0)
+		}
+	}
+	drain(0)
+
+	if failed != nil && d.Connected && ClassifyError(failed) != ErrorCanceled {
+		if d.config.Verbose {
+			d.warnLog("pipeline failed, closing connection", "error", failed)
+		}
+		_ = d.closeLocked()
+	}
+	for _, c := range p.commands {
+		if c.Err != nil {
+			return c.Err
+		}
+	}
+	return nil
+}
+
+// sendPipelined registers and writes one pipelined command
+func (d *Dialer) sendPipelined(ctx context.Context, c *PipelinedCommand) error {
+	c.tag = []byte(strings.ToUpper(xid.New().String()))
+	cmd, err := d.startCommand(c.tag, modeBuffered)
+	if err != nil {
+		return err
+	}
+	c.cmd = cmd
+	c.expired, c.stopTimer = deadlineTimer(d.commandDeadline(ctx))
+
+	d.debugLog("sending command", "command", string(c.tag)+" "+c.Command)
+	stop := d.watchContext(ctx)
+	_, err = fmt.Fprintf(d.conn, "%s %s\r\n", c.tag, c.Command)
+	stop()
+	if err != nil {
+		d.reader.release(cmd)
+		c.stopTimer()
+		return d.contextError(ctx, err)
+	}
+	return nil
+}
+
+// completePipelined reads c's responses until its tagged completion. A NO or
+// BAD completion is recorded in c.Err; the returned error means the
+// connection can no longer be used.
+func (d *Dialer) completePipelined(ctx context.Context, c *PipelinedCommand) error {
+	defer d.reader.release(c.cmd)
+	defer c.stopTimer()
+	for {
+		line, err := d.reader.next(ctx, c.cmd, c.expired)
+		if err != nil {
+			return d.contextError(ctx, err)
+		}
+		d.responseLog(line)
+		d.captureCapabilities(line)
+		d.handleUnsolicited(d.config.UnsolicitedHandler, c.Command, line)
+
+		if len(line) > len(c.tag) && bytes.HasPrefix(line, c.tag) && line[len(c.tag)] == ' ' {
+			status := line[len(c.tag)+1:]
+			if !hasPrefixFold(status, "OK") && c.Err == nil {
+				c.Err = parseStatusError(status)
+			}
+			c.Response = c.resp.String()
+			return nil
+		}
+		if c.ProcessLine != nil {
+			if c.Err == nil {
+				c.Err = c.ProcessLine(line)
+			}
+			continue
+		}
+		c.resp.Write(line)
+	}
+}
+
+// commandName returns the upper-case command name, including the UID prefix
+func commandName(command string) string {
+	fields := strings.Fields(strings.ToUpper(command))
+	switch {
+	case len(fields) == 0:
+		return ""
+	case fields[0] == "UID" && len(fields) > 1:
+		return "UID " + fields[1]
+	}
+	return fields[0]
+}
+
+// pipelineBarrier reports whether command must not be in flight with others
+func pipelineBarrier(command string) bool {
+	return pipelineBarriers[commandName(command)] || strings.Contains(command, "}\r\n")
+}
+
+// usesSequenceNumbers reports whether command refers to messages by sequence
+// number, which an EXPUNGE response would shift
+func usesSequenceNumbers(command string) bool {
+	switch commandName(command) {
+	case "FETCH", "STORE", "SEARCH", "COPY", "MOVE":
+		return true
+	}
+	return false
+}
+
+// mayExpunge reports whether the server may send EXPUNGE responses while
+// command is in progress. RFC 3501 §7.4.1 forbids them only during FETCH,
+// STORE and SEARCH.
+func mayExpunge(command string) bool {
+	switch commandName(command) {
+	case "FETCH", "STORE", "SEARCH", "UID FETCH", "UID STORE", "UID SEARCH":
+		return false
+	}
+	return true
+}
+
+// pipelineMustWait reports whether c must wait for the commands in flight to
+// complete before it is sent
+func pipelineMustWait(command string, inflight []*PipelinedCommand) bool {
+	if pipelineBarrier(command) {
+		return true
+	}
+	for _, c := range inflight {
+		if usesSequenceNumbers(command) && mayExpunge(c.Command) {
+			return true
+		}
+		// Flags read while a STORE is outstanding may or may not include
+		// its changes
+		if readsFlags(command) && storesFlags(c.Command) || storesFlags(command) && readsFlags(c.Command) {
+			return true
+		}
+	}
+	return false
+}
+
+// flagFetchItems are the FETCH items that return FLAGS
+var flagFetchItems = map[string]bool{"FLAGS": true, "ALL": true, "FAST": true, "FULL": true}
+
+// flagSearchKeys are the SEARCH keys that match on flags
+var flagSearchKeys = map[string]bool{
+	"ANSWERED": true, "DELETED": true, "DRAFT": true, "FLAGGED": true,
+	"KEYWORD": true, "NEW": true, "OLD": true, "RECENT": true, "SEEN": true,
+	"UNANSWERED": true, "UNDELETED": true, "UNDRAFT": true, "UNFLAGGED": true,
+	"UNKEYWORD": true, "UNSEEN": true,
+}
+
+// storesFlags reports whether command changes flags
+func storesFlags(command string) bool {
+	switch commandName(command) {
+	case "STORE", "UID STORE":
+		return true
+	}
+	return false
+}
+
+// readsFlags reports whether the result of command depends on flags: a
+// FETCH of FLAGS, directly or through the ALL, FAST and FULL macros, or a
+// SEARCH on flags
+func readsFlags(command string) bool {
+	var words map[string]bool
+	switch commandName(command) {
+	case "FETCH", "UID FETCH":
+		words = flagFetchItems
+	case "SEARCH", "UID SEARCH":
+		words = flagSearchKeys
+	default:
+		return false
+	}
+	fields := strings.FieldsFunc(strings.ToUpper(command), func(r rune) bool {
+		return r == ' ' || r == '(' || r == ')'
+	})
+	return slices.ContainsFunc(fields, func(f string) bool { return words[f] })
+}
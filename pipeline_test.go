@@ -0,0 +1,276 @@
+package imap
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lazyServer holds command completions until it has received batch commands
+// or the client has been quiet for quiet, then answers them in reverse order.
+// It records a violation when a command using sequence numbers arrives while
+// a command that may expunge is unanswered, or a command reading flags while
+// a STORE is unanswered or vice versa.
+type lazyServer struct {
+	batch int
+	quiet time.Duration
+
+	mu         sync.Mutex
+	violations []string
+	flushes    int
+}
+
+func (s *lazyServer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, server := net.Pipe()
+	go s.serve(server)
+	return client, nil
+}
+
+func (s *lazyServer) serve(conn net.Conn) {
+	defer conn.Close()
+	w := bufio.NewWriter(conn)
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- strings.TrimRight(line, "\r\n")
+		}
+	}()
+
+	_, _ = w.WriteString("* OK [CAPABILITY IMAP4rev1] ready\r\n")
+	_ = w.Flush()
+
+	var held []string
+	flush := func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			tag, command, _ := strings.Cut(held[i], " ")
+			if strings.Contains(command, "FAIL") {
+				fmt.Fprintf(w, "%s NO [TRYCREATE] %s failed\r\n", tag, command)
+			} else {
+				fmt.Fprintf(w, "* %d FETCH (FLAGS (\\Seen))\r\n%s OK done\r\n", i+1, tag)
+			}
+		}
+		if len(held) > 0 {
+			s.mu.Lock()
+			s.flushes++
+			s.mu.Unlock()
+		}
+		held = nil
+		_ = w.Flush()
+	}
+
+	for {
+		var line string
+		var ok bool
+		select {
+		case line, ok = <-lines:
+			if !ok {
+				return
+			}
+		case <-time.After(s.quiet):
+			flush()
+			continue
+		}
+
+		tag, command, _ := strings.Cut(line, " ")
+		switch commandName(command) {
+		case "LOGIN", "AUTHENTICATE":
+			fmt.Fprintf(w, "%s OK logged in\r\n", tag)
+			_ = w.Flush()
+			continue
+		}
+		if slices.ContainsFunc(held, func(h string) bool {
+			_, c, _ := strings.Cut(h, " ")
+			return usesSequenceNumbers(command) && mayExpunge(c) ||
+				readsFlags(command) && storesFlags(c) || storesFlags(command) && readsFlags(c)
+		}) {
+			s.mu.Lock()
+			s.violations = append(s.violations, command)
+			s.mu.Unlock()
+		}
+		held = append(held, line)
+		if len(held) >= s.batch {
+			flush()
+		}
+	}
+}
+
+func dialLazy(t *testing.T, s *lazyServer) *Dialer {
+	t.Helper()
+	d, err := Dial(context.Background(), Config{
+		Host:        "imap.example.test",
+		Port:        143,
+		Username:    "user",
+		Password:    "pass",
+		Transport:   TransportInsecure,
+		DialContext: s.dial,
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func TestPipeline_SendsWithoutWaiting(t *testing.T) {
+	t.Parallel()
+	s := &lazyServer{batch: 10, quiet: 2 * time.Second}
+	d := dialLazy(t, s)
+
+	p := d.Pipeline()
+	var cmds []*PipelinedCommand
+	for uid := range 10 {
+		command := fmt.Sprintf(`UID STORE %d +FLAGS (\Seen)`, uid+1)
+		if uid == 4 {
+			command = "UID COPY 5 FAIL"
+		}
+		cmds = append(cmds, p.Add(command))
+	}
+
+	start := time.Now()
+	err := p.Run(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Run() took %v; commands were not pipelined", elapsed)
+	}
+	if !errors.Is(err, ErrTryCreate) {
+		t.Fatalf("Run() error = %v, want the failed command's error", err)
+	}
+	for i, c := range cmds {
+		if (c.Err != nil) != (i == 4) {
+			t.Errorf("command %d Err = %v", i, c.Err)
+		}
+	}
+	if !strings.Contains(cmds[0].Response+cmds[9].Response, "FETCH") {
+		t.Error("untagged responses were not collected")
+	}
+	if !d.Connected {
+		t.Error("a NO response should not close the connection")
+	}
+}
+
+func TestPipeline_WaitsOnAmbiguity(t *testing.T) {
+	t.Parallel()
+	s := &lazyServer{batch: 100, quiet: 30 * time.Millisecond}
+	d := dialLazy(t, s)
+
+	p := d.Pipeline()
+	p.Add(`UID STORE 1 +FLAGS (\Deleted)`)
+	p.Add(`EXPUNGE`)
+	p.Add(`FETCH 1 (FLAGS)`)
+	p.Add(`SEARCH UNSEEN`)
+	p.Add(`COPY 1 "Archive"`)
+	p.Add(`COPY 2 "Archive"`)
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.violations) > 0 {
+		t.Errorf("sent %q while a command that may expunge was in flight", s.violations)
+	}
+	// STORE+EXPUNGE, FETCH+SEARCH+COPY, COPY
+	if s.flushes != 3 {
+		t.Errorf("server answered %d batches, want 3", s.flushes)
+	}
+}
+
+func TestPipeline_WaitsBetweenStoreAndFlags(t *testing.T) {
+	t.Parallel()
+	s := &lazyServer{batch: 100, quiet: 30 * time.Millisecond}
+	d := dialLazy(t, s)
+
+	p := d.Pipeline()
+	p.Add(`UID STORE 1 +FLAGS (\Seen)`)
+	p.Add(`UID STORE 2 +FLAGS (\Seen)`)
+	p.Add(`UID FETCH 1 FLAGS`)
+	p.Add(`UID SEARCH UNSEEN`)
+	p.Add(`UID STORE 3 -FLAGS (\Seen)`)
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.violations) > 0 {
+		t.Errorf("sent %q while a conflicting flag command was in flight", s.violations)
+	}
+	// STORE+STORE, FETCH+SEARCH, STORE
+	if s.flushes != 3 {
+		t.Errorf("server answered %d batches, want 3", s.flushes)
+	}
+}
+
+func TestPipeline_ConnectionLost(t *testing.T) {
+	d, server := setupTestDialer(t)
+	server.hangCommands["SLOW"] = true
+	d.config.CommandTimeout = 200 * time.Millisecond
+
+	p := d.Pipeline()
+	first := p.Add("NOOP")
+	slow := p.Add("SLOW")
+	after := p.Add("NOOP")
+	err := p.Run(context.Background())
+	if err == nil || ClassifyError(err) != ErrorTransport {
+		t.Fatalf("Run() error = %v, want a transport error", err)
+	}
+	if first.Err != nil {
+		t.Errorf("first command Err = %v", first.Err)
+	}
+	if slow.Err == nil || after.Err == nil {
+		t.Errorf("slow.Err = %v, after.Err = %v; want both set", slow.Err, after.Err)
+	}
+	if d.Connected {
+		t.Error("connection should be closed after a transport failure")
+	}
+}
+
+func TestPipelineMustWait(t *testing.T) {
+	t.Parallel()
+	inflight := func(commands ...string) []*PipelinedCommand {
+		var cs []*PipelinedCommand
+		for _, c := range commands {
+			cs = append(cs, &PipelinedCommand{Command: c})
+		}
+		return cs
+	}
+	tests := []struct {
+		command  string
+		inflight []*PipelinedCommand
+		want     bool
+	}{
+		{"FETCH 1 BODY[]", inflight("STORE 2 +FLAGS (\\Seen)", "SEARCH ALL"), false},
+		{"FETCH 1 FLAGS", inflight("STORE 2 +FLAGS (\\Seen)", "SEARCH ALL"), true},
+		{"UID FETCH 1 (UID FLAGS)", inflight("UID STORE 1 +FLAGS (\\Seen)"), true},
+		{"UID FETCH 1 FAST", inflight("UID STORE 1 +FLAGS (\\Seen)"), true},
+		{"UID SEARCH UNSEEN", inflight("UID STORE 1 +FLAGS (\\Seen)"), true},
+		{"UID SEARCH SUBJECT hi", inflight("UID STORE 1 +FLAGS (\\Seen)"), false},
+		{"UID STORE 1 +FLAGS (\\Seen)", inflight("UID FETCH 1 (FLAGS)"), true},
+		{"UID STORE 1 +FLAGS (\\Seen)", inflight("UID STORE 2 +FLAGS (\\Seen)"), false},
+		{"FETCH 1 FLAGS", inflight("NOOP"), true},
+		{"STORE 1 +FLAGS (\\Seen)", inflight("UID EXPUNGE 4"), true},
+		{"COPY 1 Archive", inflight("COPY 2 Archive"), true},
+		{"UID COPY 1 Archive", inflight("UID COPY 2 Archive"), false},
+		{"uid fetch 1 FLAGS", inflight("EXPUNGE"), false},
+		{"NOOP", inflight("UID FETCH 1 FLAGS"), false},
+		{`SELECT "INBOX"`, inflight("NOOP"), true},
+		{"UID SEARCH SUBJECT {3}\r\nabc", inflight("NOOP"), true},
+	}
+	for _, tt := range tests {
+		if got := pipelineMustWait(tt.command, tt.inflight); got != tt.want {
+			t.Errorf("pipelineMustWait(%q) = %v, want %v", tt.command, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package imap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errReaderStopped is the reader error after it stopped on request, e.g. to
+// let STARTTLS take over the connection
+var errReaderStopped = errors.New("imap: response reader stopped")
+
+// errUnreadable wraps a reader failure that is not an I/O error, such as a
+// literal size that does not parse; nothing more can be read from the
+// connection after it
+var errUnreadable = errors.New("imap: unreadable response")
+
+// commandMode selects how the reader treats a pending command's responses
+type commandMode uint8
+
+const (
+	// modeBuffered delivers each response with its literals read into memory
+	modeBuffered commandMode = iota
+	// modeStopAfterOK stops the reader after the command's tagged OK, so
+	// that e.g. the TLS handshake after STARTTLS can take over the connection
+	modeStopAfterOK
+	// modeStream hands over the literals in untagged responses as readers
+	// backed by the connection instead of reading them into memory
+	modeStream
+)
+
+// pendingCommand is a command waiting for responses from the server. The
+// reader delivers the untagged responses that arrive while it is the oldest
+// pending command, continuation requests while it is the newest, and finally
+// its tagged completion.
+type pendingCommand struct {
+	tag       []byte // nil for the greeting
+	responses chan response
+	released  chan struct{} // closed when the caller stops reading
+	once      sync.Once
+	mode      commandMode
+}
+
+// response is a line delivered to a pending command. For a modeStream
+// command, a line ending in a literal's size is followed by the literal,
+// which the command must read (or close) before the reader continues with
+// the next part of the response.
+type response struct {
+	line    []byte
+	literal *literal
+}
+
+// literal is the body of a streamed literal, read straight from the
+// connection
+type literal struct {
+	r      io.Reader
+	err    error // the first read error other than io.EOF
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (l *literal) Read(p []byte) (int, error) {
+	select {
+	case <-l.closed:
+		// The reader owns the connection again
+		return 0, errLiteralClosed
+	default:
+	}
+	n, err := l.r.Read(p)
+	if err != nil && err != io.EOF && l.err == nil {
+		l.err = err
+	}
+	return n, err
+}
+
+// close hands the connection back to the reader, which discards whatever
+// was not read
+func (l *literal) close() {
+	l.once.Do(func() { close(l.closed) })
+}
+
+// responseReader owns the read side of a connection. A single goroutine reads
+// and parses every response once, through one buffered reader, and routes it
+// to the pending command it belongs to. Untagged responses that arrive while
+// no command is pending are queued for the next command.
+type responseReader struct {
+	r    *bufio.Reader
+	quit chan struct{} // closed when the connection is being closed
+	done chan struct{} // closed when the reading goroutine has exited
+	once sync.Once
+
+	mu          sync.Mutex
+	pending     []*pendingCommand
+	unsolicited [][]byte
+	err         error // why the reader stopped
+}
+
+// newResponseReader starts reading responses from r
+func newResponseReader(r *bufio.Reader) *responseReader {
+	rd := &responseReader{
+		r:    r,
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go rd.run()
+	return rd
+}
+
+func (rd *responseReader) run() {
+	defer close(rd.done)
+	var bye *Error
+	for {
+		line, err := rd.r.ReadBytes('\n')
+		if err == nil {
+			if cmd := rd.streamTarget(line); cmd != nil {
+				if err = rd.stream(cmd, line); err == nil {
+					continue
+				}
+			} else {
+				line, err = readLiterals(rd.r, line)
+			}
+		}
+		if err != nil {
+			switch {
+			case bye != nil:
+				// The server announced why it is closing the connection
+				err = bye
+			case !isTransportError(err):
+				err = fmt.Errorf("%w: %w", errUnreadable, err)
+			}
+			rd.stop(err)
+			return
+		}
+		if hasPrefixFold(line, "* BYE ") {
+			bye = parseStatusError(line[2:])
+		}
+
+		cmd, completed := rd.route(line)
+		if cmd == nil {
+			continue
+		}
+		if err := rd.deliver(cmd, response{line: line}); err != nil {
+			rd.stop(err)
+			return
+		}
+		if completed && cmd.mode == modeStopAfterOK && hasPrefixFold(line[len(cmd.tag)+1:], "OK") {
+			rd.stop(errReaderStopped)
+			return
+		}
+	}
+}
+
+// deliver hands resp to cmd, or drops it if cmd has been released. It fails
+// only when the connection is being closed.
+func (rd *responseReader) deliver(cmd *pendingCommand, resp response) error {
+	select {
+	case cmd.responses <- resp:
+		return nil
+	case <-cmd.released:
+		return nil
+	case <-rd.quit:
+		return net.ErrClosed
+	}
+}
+
+// streamTarget returns the modeStream command an untagged line carrying a
+// literal belongs to, or nil if the response is to be read into memory
+func (rd *responseReader) streamTarget(line []byte) *pendingCommand {
+	if len(line) == 0 || line[0] != '*' || atom.Find(dropNl(line)) == nil {
+		return nil
+	}
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if len(rd.pending) == 0 || rd.pending[0].mode != modeStream {
+		return nil
+	}
+	return rd.pending[0]
+}
+
+// stream delivers a response to cmd piece by piece: each line ending in a
+// literal's size is followed by the literal itself, and the reader waits
+// until cmd is done with the literal before reading the rest of the
+// response.
+func (rd *responseReader) stream(cmd *pendingCommand, line []byte) error {
+	for {
+		a := atom.Find(dropNl(line))
+		if a == nil {
+			return rd.deliver(cmd, response{line: line})
+		}
+		n, err := strconv.ParseInt(strings.TrimSuffix(string(a[1:len(a)-1]), "+"), 10, 64)
+		if err != nil {
+			return err
+		}
+		lit := &literal{r: io.LimitReader(rd.r, n), closed: make(chan struct{})}
+		if err := rd.deliver(cmd, response{line: line, literal: lit}); err != nil {
+			return err
+		}
+		select {
+		case <-lit.closed:
+		case <-cmd.released:
+		case <-rd.quit:
+			return net.ErrClosed
+		}
+		if lit.err != nil {
+			return lit.err
+		}
+		if _, err := io.Copy(io.Discard, lit.r); err != nil {
+			return err
+		}
+		if line, err = rd.r.ReadBytes('\n'); err != nil {
+			return err
+		}
+	}
+}
+
+// route finds the pending command line belongs to. completed reports that
+// line is the command's tagged completion and the command is no longer
+// pending. Lines no command claims are queued as unsolicited.
+func (rd *responseReader) route(line []byte) (cmd *pendingCommand, completed bool) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+
+	switch {
+	case len(line) > 0 && line[0] == '*':
+		if len(rd.pending) > 0 {
+			return rd.pending[0], false
+		}
+		rd.unsolicited = append(rd.unsolicited, line)
+		return nil, false
+	case len(line) > 0 && line[0] == '+':
+		if len(rd.pending) > 0 {
+			return rd.pending[len(rd.pending)-1], false
+		}
+		return nil, false
+	}
+
+	tag, _, _ := bytes.Cut(line, []byte(" "))
+	for i, p := range rd.pending {
+		if p.tag != nil && bytes.Equal(p.tag, tag) {
+			rd.pending = slices.Delete(rd.pending, i, i+1)
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// stop records why the reader stopped
+func (rd *responseReader) stop(err error) {
+	rd.mu.Lock()
+	rd.err = err
+	rd.pending = nil
+	rd.mu.Unlock()
+}
+
+// register adds a pending command with tag, which must happen before the
+// command is written. It also returns the unsolicited responses queued since
+// the previous command.
+func (rd *responseReader) register(tag []byte, mode commandMode) (cmd *pendingCommand, unsolicited [][]byte, err error) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if rd.err != nil {
+		return nil, nil, rd.err
+	}
+	cmd = &pendingCommand{
+		tag: tag,
+		// One slot lets the reader hand over the completion of a pipelined
+		// command while an older command is still being read
+		responses: make(chan response, 1),
+		released:  make(chan struct{}),
+		mode:      mode,
+	}
+	rd.pending = append(rd.pending, cmd)
+	unsolicited, rd.unsolicited = rd.unsolicited, nil
+	return cmd, unsolicited, nil
+}
+
+// release tells the reader that nobody reads cmd's responses any more. A
+// tagged command stays pending so that the rest of its responses are
+// discarded rather than attributed to another command.
+func (rd *responseReader) release(cmd *pendingCommand) {
+	cmd.once.Do(func() { close(cmd.released) })
+	if cmd.tag != nil {
+		return
+	}
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if i := slices.Index(rd.pending, cmd); i >= 0 {
+		rd.pending = slices.Delete(rd.pending, i, i+1)
+	}
+}
+
+// error returns why the reader stopped, or nil if it is running
+func (rd *responseReader) error() error {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	return rd.err
+}
+
+// close stops the reader; the caller closes the connection to unblock it
+func (rd *responseReader) close() {
+	rd.once.Do(func() { close(rd.quit) })
+}
+
+// next returns the next response routed to cmd. It fails with ctx's error
+// when ctx is done, with os.ErrDeadlineExceeded when expired fires, and with
+// the reader's error once the connection is lost.
+func (rd *responseReader) next(ctx context.Context, cmd *pendingCommand, expired <-chan time.Time) ([]byte, error) {
+	resp, err := rd.nextResponse(ctx, cmd, expired)
+	return resp.line, err
+}
+
+// nextResponse is like next but also returns the literal that follows the
+// line, if any, for a modeStream command
+func (rd *responseReader) nextResponse(ctx context.Context, cmd *pendingCommand, expired <-chan time.Time) (response, error) {
+	select {
+	case resp := <-cmd.responses:
+		return resp, nil
+	case <-rd.done:
+		// A response delivered just before the reader stopped comes first
+		select {
+		case resp := <-cmd.responses:
+			return resp, nil
+		default:
+		}
+		return response{}, rd.error()
+	case <-ctx.Done():
+		return response{}, ctx.Err()
+	case <-expired:
+		return response{}, fmt.Errorf("imap read response: %w", os.ErrDeadlineExceeded)
+	}
+}
+
+// deadlineTimer returns a channel that fires at deadline, or nil for a zero
+// deadline, and a function that releases the timer.
+func deadlineTimer(deadline time.Time) (<-chan time.Time, func()) {
+	if deadline.IsZero() {
+		return nil, func() {}
+	}
+	t := time.NewTimer(time.Until(deadline))
+	return t.C, func() { t.Stop() }
+}
+
+// startCommand registers a command with the connection's reader before it is
+// written, and processes responses that arrived unsolicited since the last
+// one.
+func (d *Dialer) startCommand(tag []byte, mode commandMode) (*pendingCommand, error) {
+	cmd, unsolicited, err := d.reader.register(tag, mode)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range unsolicited {
+		d.responseLog(line)
+		d.captureCapabilities(line)
+		d.handleUnsolicited(d.config.UnsolicitedHandler, "", line)
+	}
+	return cmd, nil
+}
@@ -0,0 +1,157 @@
+package imap
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// newTestReader starts a responseReader fed by the returned writer
+func newTestReader(t *testing.T) (*responseReader, *io.PipeWriter) {
+	t.Helper()
+	pr, pw := io.Pipe()
+	rd := newResponseReader(bufio.NewReader(pr))
+	t.Cleanup(func() {
+		rd.close()
+		_ = pw.Close()
+		<-rd.done
+	})
+	return rd, pw
+}
+
+func feed(t *testing.T, w io.Writer, data string) {
+	t.Helper()
+	go func() { _, _ = io.WriteString(w, data) }()
+}
+
+func nextLine(t *testing.T, rd *responseReader, cmd *pendingCommand) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	line, err := rd.next(ctx, cmd, nil)
+	if err != nil {
+		t.Fatalf("next() error = %v", err)
+	}
+	return string(line)
+}
+
+func TestResponseReader_RoutesAndQueuesUnsolicited(t *testing.T) {
+	rd, w := newTestReader(t)
+	a, _, err := rd.register([]byte("A1"), modeBuffered)
+	if err != nil {
+		t.Fatalf("register() error = %v", err)
+	}
+
+	// The unsolicited EXISTS arrives in the same packet as the completion
+	feed(t, w, "* 1 FETCH (BODY[] {5}\r\nhello)\r\nA1 OK done\r\n* 5 EXISTS\r\n")
+	if got := nextLine(t, rd, a); got != "* 1 FETCH (BODY[] {5}\r\nhello)\r\n" {
+		t.Errorf("first line = %q", got)
+	}
+	if got := nextLine(t, rd, a); got != "A1 OK done\r\n" {
+		t.Errorf("completion = %q", got)
+	}
+
+	// Wait for the reader to queue the EXISTS before registering again
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		rd.mu.Lock()
+		n := len(rd.unsolicited)
+		rd.mu.Unlock()
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	_, unsolicited, err := rd.register([]byte("A2"), modeBuffered)
+	if err != nil {
+		t.Fatalf("register() error = %v", err)
+	}
+	if len(unsolicited) != 1 || string(unsolicited[0]) != "* 5 EXISTS\r\n" {
+		t.Errorf("unsolicited = %q, want the EXISTS line", unsolicited)
+	}
+}
+
+func TestResponseReader_PipelinedTags(t *testing.T) {
+	rd, w := newTestReader(t)
+	a, _, _ := rd.register([]byte("A1"), modeBuffered)
+	b, _, _ := rd.register([]byte("A2"), modeBuffered)
+
+	feed(t, w, "+ go ahead\r\nA1 OK first\r\nA2 NO second\r\n")
+	if got := nextLine(t, rd, b); got != "+ go ahead\r\n" {
+		t.Errorf("continuation went to %q, want the newest command", got)
+	}
+	if got := nextLine(t, rd, a); got != "A1 OK first\r\n" {
+		t.Errorf("A1 got %q", got)
+	}
+	if got := nextLine(t, rd, b); got != "A2 NO second\r\n" {
+		t.Errorf("A2 got %q", got)
+	}
+}
+
+func TestResponseReader_ReleasedCommandDiscards(t *testing.T) {
+	rd, w := newTestReader(t)
+	a, _, _ := rd.register([]byte("A1"), modeBuffered)
+	rd.release(a)
+	b, _, _ := rd.register([]byte("A2"), modeBuffered)
+
+	feed(t, w, "* 1 EXISTS\r\nA1 OK\r\n* 2 EXISTS\r\nA2 OK\r\n")
+	if got := nextLine(t, rd, b); got != "* 2 EXISTS\r\n" {
+		t.Errorf("A2 got %q, want responses after A1 completed", got)
+	}
+	if got := nextLine(t, rd, b); got != "A2 OK\r\n" {
+		t.Errorf("A2 completion = %q", got)
+	}
+}
+
+func TestResponseReader_Bye(t *testing.T) {
+	rd, w := newTestReader(t)
+	a, _, _ := rd.register([]byte("A1"), modeBuffered)
+
+	go func() {
+		_, _ = io.WriteString(w, "* BYE [UNAVAILABLE] shutting down\r\n")
+		_ = w.Close()
+	}()
+	if got := nextLine(t, rd, a); got != "* BYE [UNAVAILABLE] shutting down\r\n" {
+		t.Errorf("got %q", got)
+	}
+	_, err := rd.next(context.Background(), a, nil)
+	var imapErr *Error
+	if !errors.As(err, &imapErr) || imapErr.Status != "BYE" || !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("next() error = %v, want BYE [UNAVAILABLE]", err)
+	}
+	if _, _, err := rd.register([]byte("A2"), modeBuffered); err == nil {
+		t.Error("register() after the reader stopped should fail")
+	}
+}
+
+func TestResponseReader_StopAfterOK(t *testing.T) {
+	rd, w := newTestReader(t)
+	a, _, _ := rd.register([]byte("A1"), modeStopAfterOK)
+
+	feed(t, w, "A1 OK begin TLS\r\ninjected\r\n")
+	if got := nextLine(t, rd, a); got != "A1 OK begin TLS\r\n" {
+		t.Errorf("got %q", got)
+	}
+	select {
+	case <-rd.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("reader did not stop after OK")
+	}
+	if !errors.Is(rd.error(), errReaderStopped) {
+		t.Errorf("error() = %v, want errReaderStopped", rd.error())
+	}
+}
+
+func TestResponseReader_Deadline(t *testing.T) {
+	rd, _ := newTestReader(t)
+	a, _, _ := rd.register([]byte("A1"), modeBuffered)
+
+	expired, stop := deadlineTimer(time.Now().Add(20 * time.Millisecond))
+	defer stop()
+	if _, err := rd.next(context.Background(), a, expired); ClassifyError(err) != ErrorTransport {
+		t.Errorf("next() error = %v, want a transport timeout", err)
+	}
+}
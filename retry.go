@@ -0,0 +1,264 @@
+package imap
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"time"
+)
+
+// ErrorClass groups errors by how a retry policy should treat them
+type ErrorClass int
+
+const (
+	// ErrorTransport is a network failure, timeout, unexpected BYE or a
+	// response that cannot be read off the connection. The connection is
+	// closed and reopened before any retry.
+	ErrorTransport ErrorClass = iota
+	// ErrorNo is a NO response: the server understood the command but
+	// refused it.
+	ErrorNo
+	// ErrorBad is a BAD response: the server rejected the command syntax.
+	ErrorBad
+	// ErrorAuth is an authentication or authorization failure.
+	ErrorAuth
+	// ErrorUnsupported means the server lacks a required extension.
+	ErrorUnsupported
+	// ErrorCanceled means the context was canceled or its deadline passed.
+	ErrorCanceled
+	// ErrorProtocol is a response the client could not parse, or an error
+	// returned by a callback such as Exec's processLine. The connection is
+	// kept, and the command is not retried as it would fail the same way.
+	ErrorProtocol
+)
+
+// String returns the name of the error class
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorTransport:
+		return "transport"
+	case ErrorNo:
+		return "no"
+	case ErrorBad:
+		return "bad"
+	case ErrorAuth:
+		return "auth"
+	case ErrorUnsupported:
+		return "unsupported"
+	case ErrorCanceled:
+		return "canceled"
+	case ErrorProtocol:
+		return "protocol"
+	}
+	return "unknown"
+}
+
+// ClassifyError reports the class of an error returned by the client
+func ClassifyError(err error) ErrorClass {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrorCanceled
+	}
+	if errors.Is(err, errors.ErrUnsupported) {
+		return ErrorUnsupported
+	}
+	var imapErr *Error
+	if errors.As(err, &imapErr) {
+		switch {
+		case imapErr.Status == "BYE":
+			return ErrorTransport
+		case imapErr.Code == "AUTHENTICATIONFAILED", imapErr.Code == "AUTHORIZATIONFAILED", imapErr.Code == "EXPIRED":
+			return ErrorAuth
+		case imapErr.Status == "BAD":
+			return ErrorBad
+		}
+		return ErrorNo
+	}
+	if isTransportError(err) {
+		return ErrorTransport
+	}
+	return ErrorProtocol
+}
+
+// isTransportError reports whether err is a failure of the connection itself
+// rather than of a command on it
+func isTransportError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, os.ErrDeadlineExceeded) ||
+		errors.Is(err, errReaderStopped) ||
+		errors.Is(err, errUnreadable)
+}
+
+// RetryFailure describes a failed attempt passed to a RetryPolicy
+type RetryFailure struct {
+	// Attempt is the number of attempts made so far, starting at 1
+	Attempt int
+	// Err is the error the attempt failed with
+	Err error
+	// Class is ClassifyError(Err)
+	Class ErrorClass
+	// Idempotent reports whether the operation can be repeated safely after
+	// the server may have acted on it. Searches, fetches and SELECT are
+	// idempotent; MOVE, COPY, STORE, EXPUNGE, CREATE, DELETE and RENAME are
+	// not.
+	Idempotent bool
+	// Sent reports whether the command may have reached the server. When it
+	// is false the command was never written and repeating it is safe even
+	// if it is not idempotent.
+	Sent bool
+}
+
+// RetryPolicy decides whether a failed operation is attempted again and how
+// long to wait first. A policy may be shared by several connections, so
+// implementations must be safe for concurrent use.
+type RetryPolicy interface {
+	// NextRetry returns the delay before the next attempt, or false to give
+	// up and return f.Err to the caller.
+	NextRetry(f RetryFailure) (delay time.Duration, retry bool)
+}
+
+// BackoffPolicy is the default RetryPolicy. It retries transport failures
+// and NO [UNAVAILABLE] responses with exponential backoff and jitter. Other
+// NO and BAD responses, authentication failures and missing extensions are
+// returned immediately. Operations that are not idempotent are retried only
+// if they never reached the server.
+type BackoffPolicy struct {
+	// MaxRetries is the number of retries after the first attempt
+	MaxRetries int
+	// BaseDelay is the delay before the first retry; it doubles with each
+	// further retry
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts. Zero means no cap.
+	MaxDelay time.Duration
+	// Jitter is the fraction of each delay, between 0 and 1, that is
+	// randomized so that many clients do not retry in lockstep
+	Jitter float64
+}
+
+// DefaultRetryPolicy returns the BackoffPolicy used when Config.RetryPolicy
+// is nil: maxRetries retries starting at 500ms, doubling up to 15s, with
+// half of each delay randomized.
+func DefaultRetryPolicy(maxRetries int) *BackoffPolicy {
+	return &BackoffPolicy{
+		MaxRetries: maxRetries,
+		BaseDelay:  500 * time.Millisecond,
+		MaxDelay:   15 * time.Second,
+		Jitter:     0.5,
+	}
+}
+
+// NextRetry implements RetryPolicy
+func (p *BackoffPolicy) NextRetry(f RetryFailure) (time.Duration, bool) {
+	if f.Attempt > p.MaxRetries {
+		return 0, false
+	}
+	switch f.Class {
+	case ErrorTransport:
+	case ErrorNo:
+		if !errors.Is(f.Err, ErrUnavailable) {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	if f.Sent && !f.Idempotent {
+		return 0, false
+	}
+	return p.delay(f.Attempt), true
+}
+
+// delay returns the backoff before retry number attempt
+func (p *BackoffPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if jitter := min(max(p.Jitter, 0), 1); jitter > 0 && d > 0 {
+		spread := time.Duration(float64(d) * jitter)
+		d -= rand.N(spread + 1)
+	}
+	return d
+}
+
+// retryPolicy returns the connection's retry policy
+func (d *Dialer) retryPolicy() RetryPolicy {
+	if d.config.RetryPolicy != nil {
+		return d.config.RetryPolicy
+	}
+	return DefaultRetryPolicy(d.config.RetryCount)
+}
+
+// retry runs attempt until it succeeds, ctx is done, or the retry policy
+// gives up. A limit of zero or more additionally caps the number of retries.
+// attempt is responsible for closing a connection it found broken and for
+// reopening it (see ensureConnectedLocked) when n > 1.
+func (d *Dialer) retry(ctx context.Context, idempotent bool, limit int, attempt func(n int) (sent bool, err error)) error {
+	policy := d.retryPolicy()
+	for n := 1; ; n++ {
+		sent, err := attempt(n)
+		if err == nil {
+			return nil
+		}
+
+		class := ClassifyError(err)
+		if ctx.Err() != nil {
+			class = ErrorCanceled
+		}
+		if class == ErrorCanceled || (limit >= 0 && n > limit) {
+			return err
+		}
+
+		delay, ok := policy.NextRetry(RetryFailure{
+			Attempt:    n,
+			Err:        err,
+			Class:      class,
+			Idempotent: idempotent,
+			Sent:       sent,
+		})
+		if !ok {
+			if n > 1 && class == ErrorTransport {
+				d.errorLog("retries exhausted", "attempts", n, "error", err)
+			}
+			return err
+		}
+		if d.config.Verbose {
+			d.warnLog("retrying", "attempt", n, "class", class, "delay", delay, "error", err)
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// ensureConnectedLocked reopens the connection if a previous attempt closed
+// it; the caller holds the connection lock
+func (d *Dialer) ensureConnectedLocked(ctx context.Context) error {
+	if d.Connected {
+		return nil
+	}
+	return d.reconnectLocked(ctx)
+}
+
+// sleepContext waits for delay or until ctx is done
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,201 @@
+package imap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ErrorClass
+	}{
+		{io.EOF, ErrorTransport},
+		{fmt.Errorf("wrapped: %w", context.Canceled), ErrorCanceled},
+		{context.DeadlineExceeded, ErrorCanceled},
+		{&Error{Status: "NO", Text: "nope"}, ErrorNo},
+		{&Error{Status: "NO", Code: "UNAVAILABLE"}, ErrorNo},
+		{fmt.Errorf("imap create folder: %w", &Error{Status: "BAD"}), ErrorBad},
+		{&Error{Status: "NO", Code: "AUTHENTICATIONFAILED"}, ErrorAuth},
+		{&Error{Status: "BAD", Code: "AUTHORIZATIONFAILED"}, ErrorAuth},
+		{&Error{Status: "BYE", Text: "shutting down"}, ErrorTransport},
+		{&UnsupportedError{Extension: "MOVE"}, ErrorUnsupported},
+		{ErrSTARTTLSNotSupported, ErrorUnsupported},
+		{fmt.Errorf("imap read response: %w", os.ErrDeadlineExceeded), ErrorTransport},
+		{&net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, ErrorTransport},
+		{net.ErrClosed, ErrorTransport},
+		{io.ErrUnexpectedEOF, ErrorTransport},
+		{errReaderStopped, ErrorTransport},
+		{fmt.Errorf("%w: %w", errUnreadable, strconv.ErrRange), ErrorTransport},
+		{errors.New("IMAP1:INBOX: bad token"), ErrorProtocol},
+		{strconv.ErrSyntax, ErrorProtocol},
+	}
+	for _, tt := range tests {
+		if got := ClassifyError(tt.err); got != tt.want {
+			t.Errorf("ClassifyError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffPolicy_NextRetry(t *testing.T) {
+	p := &BackoffPolicy{MaxRetries: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+	transport := RetryFailure{Err: io.EOF, Class: ErrorTransport, Idempotent: true, Sent: true}
+
+	for attempt, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 300 * time.Millisecond} {
+		f := transport
+		f.Attempt = attempt
+		delay, ok := p.NextRetry(f)
+		if !ok || delay != want {
+			t.Errorf("attempt %d: NextRetry() = %v, %v; want %v, true", attempt, delay, ok, want)
+		}
+	}
+
+	tests := []struct {
+		name string
+		f    RetryFailure
+		want bool
+	}{
+		{"budget exhausted", RetryFailure{Attempt: 4, Err: io.EOF, Class: ErrorTransport, Idempotent: true}, false},
+		{"NO", RetryFailure{Attempt: 1, Err: &Error{Status: "NO"}, Class: ErrorNo, Idempotent: true}, false},
+		{"NO UNAVAILABLE", RetryFailure{Attempt: 1, Err: &Error{Status: "NO", Code: "UNAVAILABLE"}, Class: ErrorNo, Idempotent: true}, true},
+		{"BAD", RetryFailure{Attempt: 1, Err: &Error{Status: "BAD"}, Class: ErrorBad, Idempotent: true}, false},
+		{"auth", RetryFailure{Attempt: 1, Err: &Error{Status: "NO", Code: "AUTHENTICATIONFAILED"}, Class: ErrorAuth, Idempotent: true}, false},
+		{"not idempotent, sent", RetryFailure{Attempt: 1, Err: io.EOF, Class: ErrorTransport, Sent: true}, false},
+		{"not idempotent, unsent", RetryFailure{Attempt: 1, Err: io.EOF, Class: ErrorTransport}, true},
+	}
+	for _, tt := range tests {
+		if _, ok := p.NextRetry(tt.f); ok != tt.want {
+			t.Errorf("%s: NextRetry() retry = %v, want %v", tt.name, ok, tt.want)
+		}
+	}
+}
+
+func TestBackoffPolicy_Jitter(t *testing.T) {
+	p := &BackoffPolicy{MaxRetries: 1, BaseDelay: time.Second, Jitter: 0.5}
+	f := RetryFailure{Attempt: 1, Err: io.EOF, Class: ErrorTransport, Idempotent: true}
+	for range 100 {
+		delay, _ := p.NextRetry(f)
+		if delay < 500*time.Millisecond || delay > time.Second {
+			t.Fatalf("delay %v outside [500ms, 1s]", delay)
+		}
+	}
+}
+
+// recordingPolicy retries immediately up to max times and records failures
+type recordingPolicy struct {
+	max      int
+	mu       sync.Mutex
+	failures []RetryFailure
+}
+
+func (p *recordingPolicy) NextRetry(f RetryFailure) (time.Duration, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failures = append(p.failures, f)
+	return time.Millisecond, f.Attempt <= p.max
+}
+
+// withPolicy sets the retry policy and a short command timeout, so that
+// hanging commands fail quickly
+func withPolicy(policy RetryPolicy) func(*Config) {
+	return func(cfg *Config) {
+		cfg.RetryPolicy = policy
+		cfg.CommandTimeout = 200 * time.Millisecond
+	}
+}
+
+func TestRetry_IdempotentReplayed(t *testing.T) {
+	policy := &recordingPolicy{max: 2}
+	d, server := dialWithCapabilities(t, "", withPolicy(policy))
+	server.hangCommands["LIST"] = true
+
+	if _, err := d.GetFolders(); err == nil {
+		t.Fatal("expected GetFolders to fail")
+	}
+	if n := countCommands(server, "LIST"); n != 3 {
+		t.Errorf("LIST sent %d times, want 3", n)
+	}
+	if len(policy.failures) != 3 {
+		t.Fatalf("policy consulted %d times, want 3", len(policy.failures))
+	}
+	f := policy.failures[0]
+	if f.Attempt != 1 || f.Class != ErrorTransport || !f.Idempotent || !f.Sent {
+		t.Errorf("first failure = %+v", f)
+	}
+}
+
+func TestRetry_NonIdempotentNotReplayed(t *testing.T) {
+	d, server := dialWithCapabilities(t, "", withPolicy(&BackoffPolicy{MaxRetries: 3, BaseDelay: time.Millisecond}))
+	server.hangCommands["CREATE"] = true
+
+	if err := d.CreateFolder("Archive"); err == nil {
+		t.Fatal("expected CreateFolder to fail")
+	}
+	if n := countCommands(server, "CREATE"); n != 1 {
+		t.Errorf("CREATE sent %d times, want 1", n)
+	}
+}
+
+func TestRetry_NoResponseKeepsConnection(t *testing.T) {
+	d, server := dialWithCapabilities(t, "", withPolicy(&BackoffPolicy{MaxRetries: 3, BaseDelay: time.Millisecond}))
+	server.failCommands["LIST"] = true
+
+	_, err := d.GetFolders()
+	var imapErr *Error
+	if !errors.As(err, &imapErr) || imapErr.Status != "NO" {
+		t.Fatalf("GetFolders() error = %v, want NO", err)
+	}
+	if n := countCommands(server, "LIST"); n != 1 {
+		t.Errorf("LIST sent %d times, want 1", n)
+	}
+	if !d.Connected {
+		t.Error("a NO response should not close the connection")
+	}
+	if _, err := d.Exec("NOOP", false, 0, nil); err != nil {
+		t.Errorf("NOOP after NO: %v", err)
+	}
+}
+
+func TestRetry_BackoffHonorsContext(t *testing.T) {
+	d, server := dialWithCapabilities(t, "", withPolicy(&BackoffPolicy{MaxRetries: 3, BaseDelay: time.Hour}))
+	server.hangCommands["LIST"] = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := d.GetFoldersContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetFoldersContext() error = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("backoff ignored the context, took %v", elapsed)
+	}
+}
+
+func TestRetry_ProcessLineErrorNotRetried(t *testing.T) {
+	d, server := dialWithCapabilities(t, "", withPolicy(&BackoffPolicy{MaxRetries: 3, BaseDelay: time.Millisecond}))
+	server.responses["NOOP"] = "* 1 EXISTS\r\n"
+	errStop := errors.New("stop")
+
+	_, err := d.Exec("NOOP", false, 3, func([]byte) error { return errStop })
+	if !errors.Is(err, errStop) || ClassifyError(err) != ErrorProtocol {
+		t.Fatalf("Exec() error = %v (%v), want errStop as a protocol error", err, ClassifyError(err))
+	}
+	if n := countCommands(server, "NOOP"); n != 1 {
+		t.Errorf("NOOP sent %d times, want 1", n)
+	}
+	if !d.Connected || server.GetAuthAttempts() != 1 {
+		t.Errorf("Connected = %v, logins = %d; want the connection kept", d.Connected, server.GetAuthAttempts())
+	}
+	if _, err := d.Exec("NOOP", false, 0, nil); err != nil {
+		t.Errorf("Exec() after a callback error = %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package imap
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -53,7 +54,12 @@ func (s *SearchBuilder) buildRaw() string {
 //
 //	uids, err := conn.SearchUIDs(imap.Search().From("alice@example.com").Unseen())
 func (d *Dialer) SearchUIDs(search *SearchBuilder) ([]int, error) {
-	return d.GetUIDs(search.Build())
+	return d.GetUIDsContext(context.Background(), search.Build())
+}
+
+// SearchUIDsContext is like SearchUIDs but honors ctx
+func (d *Dialer) SearchUIDsContext(ctx context.Context, search *SearchBuilder) ([]int, error) {
+	return d.GetUIDsContext(ctx, search.Build())
 }
 
 // --- Flag criteria ---
@@ -0,0 +1,302 @@
+package imap
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// star is how "*", the largest number in use in the mailbox, is stored. It
+// sorts after every real message number.
+const star = math.MaxInt
+
+// numRange is an inclusive range of message numbers
+type numRange struct {
+	start, stop int
+}
+
+// numSet is a set of message numbers stored as sorted, non-overlapping,
+// non-adjacent ranges. It holds the methods UIDSet and SeqSet share.
+type numSet struct {
+	ranges []numRange
+}
+
+// UIDSet is a set of message UIDs, e.g. "1:500,700:*". It is sent as ranges,
+// so a contiguous run of UIDs costs the same however long it is. The zero
+// value is an empty set.
+type UIDSet struct {
+	numSet
+}
+
+// SeqSet is a set of message sequence numbers, e.g. "1:10,15". The zero value
+// is an empty set.
+type SeqSet struct {
+	numSet
+}
+
+// UIDSetNum returns a UIDSet containing uids
+func UIDSetNum(uids ...int) UIDSet {
+	var s UIDSet
+	s.AddNum(uids...)
+	return s
+}
+
+// SeqSetNum returns a SeqSet containing nums
+func SeqSetNum(nums ...int) SeqSet {
+	var s SeqSet
+	s.AddNum(nums...)
+	return s
+}
+
+// ParseUIDSet parses a UID set as sent by the server, e.g. in an ESEARCH ALL
+// or COPYUID response
+func ParseUIDSet(s string) (UIDSet, error) {
+	set, err := parseNumSet(s)
+	return UIDSet{set}, err
+}
+
+// ParseSeqSet parses a sequence set as sent by the server
+func ParseSeqSet(s string) (SeqSet, error) {
+	set, err := parseNumSet(s)
+	return SeqSet{set}, err
+}
+
+// AddSet adds every UID in other to s
+func (s *UIDSet) AddSet(other UIDSet) {
+	s.addSet(other.numSet)
+}
+
+// AddSet adds every number in other to s
+func (s *SeqSet) AddSet(other SeqSet) {
+	s.addSet(other.numSet)
+}
+
+// Split divides s into sets whose String is at most maxLen bytes long. A
+// maxLen of zero or less returns s unchanged.
+func (s UIDSet) Split(maxLen int) []UIDSet {
+	var sets []UIDSet
+	for _, part := range s.split(maxLen) {
+		sets = append(sets, UIDSet{part})
+	}
+	return sets
+}
+
+// Split divides s into sets whose String is at most maxLen bytes long. A
+// maxLen of zero or less returns s unchanged.
+func (s SeqSet) Split(maxLen int) []SeqSet {
+	var sets []SeqSet
+	for _, part := range s.split(maxLen) {
+		sets = append(sets, SeqSet{part})
+	}
+	return sets
+}
+
+// AddNum adds nums to the set. Zero stands for "*".
+func (s *numSet) AddNum(nums ...int) {
+	for _, n := range nums {
+		s.AddRange(n, n)
+	}
+}
+
+// AddRange adds the numbers from start to stop inclusive. Zero stands for
+// "*", so AddRange(700, 0) adds "700:*".
+func (s *numSet) AddRange(start, stop int) {
+	start, stop = fromStar(start), fromStar(stop)
+	if start > stop {
+		start, stop = stop, start
+	}
+	i, _ := slices.BinarySearchFunc(s.ranges, start, func(r numRange, n int) int {
+		// Find the first range that ends at or after start-1, which start
+		// extends
+		if r.stop < n-1 {
+			return -1
+		}
+		return 1
+	})
+	j := i
+	for j < len(s.ranges) && s.ranges[j].start-1 <= stop {
+		start = min(start, s.ranges[j].start)
+		stop = max(stop, s.ranges[j].stop)
+		j++
+	}
+	// Copies of a set share its ranges, so they are rebuilt rather than
+	// changed in place
+	s.ranges = slices.Concat(s.ranges[:i:i], []numRange{{start, stop}}, s.ranges[j:])
+}
+
+// addSet adds every number in other to s
+func (s *numSet) addSet(other numSet) {
+	for _, r := range other.ranges {
+		s.AddRange(r.start, r.stop)
+	}
+}
+
+// Contains reports whether n is in the set. Zero stands for "*".
+func (s numSet) Contains(n int) bool {
+	n = fromStar(n)
+	_, found := slices.BinarySearchFunc(s.ranges, n, func(r numRange, n int) int {
+		switch {
+		case r.stop < n:
+			return -1
+		case r.start > n:
+			return 1
+		}
+		return 0
+	})
+	return found
+}
+
+// IsEmpty reports whether the set contains no numbers
+func (s numSet) IsEmpty() bool {
+	return len(s.ranges) == 0
+}
+
+// Dynamic reports whether the set contains "*", whose value depends on the
+// mailbox
+func (s numSet) Dynamic() bool {
+	return len(s.ranges) > 0 && s.ranges[len(s.ranges)-1].stop == star
+}
+
+// Nums returns the numbers in the set in ascending order. It returns false if
+// the set is dynamic, since "*" cannot be expanded without the mailbox.
+func (s numSet) Nums() ([]int, bool) {
+	if s.Dynamic() {
+		return nil, false
+	}
+	var nums []int
+	for _, r := range s.ranges {
+		for n := r.start; n <= r.stop; n++ {
+			nums = append(nums, n)
+		}
+	}
+	return nums, true
+}
+
+// String returns the set in IMAP syntax, e.g. "1:3,7,10:*"
+func (s numSet) String() string {
+	var b strings.Builder
+	for i, r := range s.ranges {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		writeRange(&b, r)
+	}
+	return b.String()
+}
+
+// split divides s into sets whose String is at most maxLen bytes long
+func (s numSet) split(maxLen int) []numSet {
+	if maxLen <= 0 || len(s.ranges) == 0 {
+		return []numSet{s}
+	}
+	var sets []numSet
+	var cur numSet
+	curLen := 0
+	for _, r := range s.ranges {
+		var b strings.Builder
+		writeRange(&b, r)
+		n := b.Len()
+		if len(cur.ranges) > 0 && curLen+1+n > maxLen {
+			sets = append(sets, cur)
+			cur, curLen = numSet{}, 0
+		}
+		if len(cur.ranges) > 0 {
+			curLen++
+		}
+		cur.ranges = append(cur.ranges, r)
+		curLen += n
+	}
+	return append(sets, cur)
+}
+
+// writeRange writes r in IMAP syntax
+func writeRange(b *strings.Builder, r numRange) {
+	b.WriteString(formatNum(r.start))
+	if r.stop != r.start {
+		b.WriteByte(':')
+		b.WriteString(formatNum(r.stop))
+	}
+}
+
+// parseNumSet parses a sequence set such as "1:3,7,10:*"
+func parseNumSet(s string) (numSet, error) {
+	var set numSet
+	if strings.TrimSpace(s) == "" {
+		return set, nil
+	}
+	for part := range strings.SplitSeq(strings.TrimSpace(s), ",") {
+		first, last, isRange := strings.Cut(part, ":")
+		start, err := parseNum(first)
+		if err != nil {
+			return numSet{}, fmt.Errorf("imap: invalid set %q: %w", s, err)
+		}
+		stop := start
+		if isRange {
+			if stop, err = parseNum(last); err != nil {
+				return numSet{}, fmt.Errorf("imap: invalid set %q: %w", s, err)
+			}
+		}
+		set.AddRange(start, stop)
+	}
+	return set, nil
+}
+
+// parseNum parses a message number or "*", which it returns as zero
+func parseNum(s string) (int, error) {
+	if s == "*" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return int(n), nil
+}
+
+// fromStar maps the API's zero for "*" to its stored value
+func fromStar(n int) int {
+	if n <= 0 {
+		return star
+	}
+	return n
+}
+
+// formatNum formats a stored number
+func formatNum(n int) string {
+	if n == star {
+		return "*"
+	}
+	return strconv.Itoa(n)
+}
+
+// tagLength is the length of the tags commands are sent with
+const tagLength = 20
+
+// splitUIDs splits set so that each command built from it stays within the
+// connection's MaxLineLength. overhead is the length of the command without
+// the set.
+func (d *Dialer) splitUIDs(set UIDSet, overhead int) []UIDSet {
+	if d.config.MaxLineLength <= 0 {
+		return []UIDSet{set}
+	}
+	// The tag, the space after it and CRLF
+	return set.Split(d.config.MaxLineLength - overhead - tagLength - 3)
+}
+
+// uidSetOrAll returns uids as a set, or "1:*" if there are none. Zero UIDs
+// are ignored.
+func uidSetOrAll(uids []int) UIDSet {
+	var set UIDSet
+	if len(uids) == 0 {
+		set.AddRange(1, 0)
+		return set
+	}
+	for _, uid := range uids {
+		if uid > 0 {
+			set.AddNum(uid)
+		}
+	}
+	return set
+}
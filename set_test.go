@@ -0,0 +1,190 @@
+package imap
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestUIDSet_AddAndString(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		build func() UIDSet
+		want  string
+	}{
+		{"empty", func() UIDSet { return UIDSet{} }, ""},
+		{"merges adjacent", func() UIDSet { return UIDSetNum(3, 1, 2, 7, 5, 6) }, "1:3,5:7"},
+		{"duplicates", func() UIDSet { return UIDSetNum(4, 4, 4) }, "4"},
+		{"star", func() UIDSet {
+			s := UIDSetNum(2)
+			s.AddRange(700, 0)
+			return s
+		}, "2,700:*"},
+		{"range swallows", func() UIDSet {
+			s := UIDSetNum(5, 9, 12, 20)
+			s.AddRange(10, 4)
+			return s
+		}, "4:10,12,20"},
+		{"open range swallows", func() UIDSet {
+			s := UIDSetNum(5, 9, 12)
+			s.AddRange(8, 0)
+			return s
+		}, "5,8:*"},
+		{"merge sets", func() UIDSet {
+			s := UIDSetNum(1, 2)
+			s.AddSet(UIDSetNum(3, 10))
+			return s
+		}, "1:3,10"},
+	}
+	for _, tt := range tests {
+		if got := tt.build().String(); got != tt.want {
+			t.Errorf("%s: String() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseUIDSet(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"1:500,700:*", "1:500,700:*", false},
+		{"5:1", "1:5", false},
+		{"*:3", "3:*", false},
+		{"9,1,2,3", "1:3,9", false},
+		{"", "", false},
+		{"0", "", true},
+		{"1,,2", "", true},
+		{"a:3", "", true},
+	}
+	for _, tt := range tests {
+		s, err := ParseUIDSet(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseUIDSet(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got := s.String(); !tt.wantErr && got != tt.want {
+			t.Errorf("ParseUIDSet(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestUIDSet_Queries(t *testing.T) {
+	t.Parallel()
+	s, _ := ParseUIDSet("2:4,10")
+	for n, want := range map[int]bool{1: false, 2: true, 4: true, 5: false, 10: true, 11: false, 0: false} {
+		if got := s.Contains(n); got != want {
+			t.Errorf("Contains(%d) = %v, want %v", n, got, want)
+		}
+	}
+	if nums, ok := s.Nums(); !ok || !slices.Equal(nums, []int{2, 3, 4, 10}) {
+		t.Errorf("Nums() = %v, %v", nums, ok)
+	}
+	if s.Dynamic() || s.IsEmpty() {
+		t.Errorf("Dynamic() = %v, IsEmpty() = %v", s.Dynamic(), s.IsEmpty())
+	}
+
+	s.AddRange(20, 0)
+	if !s.Dynamic() || !s.Contains(0) || !s.Contains(1000) {
+		t.Errorf("%s: Dynamic() = %v, Contains(*) = %v", s, s.Dynamic(), s.Contains(0))
+	}
+	if _, ok := s.Nums(); ok {
+		t.Error("Nums() of a dynamic set should fail")
+	}
+}
+
+func TestUIDSet_CopyIsIndependent(t *testing.T) {
+	t.Parallel()
+	x := UIDSetNum(10, 20, 30)
+	x.AddNum(40)
+	x.ranges = x.ranges[:3:4] // spare capacity, as append leaves
+	y := x
+	y.AddNum(15)
+	y.AddRange(19, 21)
+	z := x
+	z.AddSet(UIDSetNum(31))
+	if x.String() != "10,20,30" {
+		t.Errorf("original = %s after changing copies, want 10,20,30", x)
+	}
+	if y.String() != "10,15,19:21,30" || z.String() != "10,20,30:31" {
+		t.Errorf("copies = %s and %s", y, z)
+	}
+}
+
+func TestUIDSet_Split(t *testing.T) {
+	t.Parallel()
+	s := UIDSetNum(1, 2, 3, 10, 20, 30, 400, 5000)
+	parts := s.Split(8)
+	var got []string
+	var all UIDSet
+	for _, p := range parts {
+		if len(p.String()) > 8 {
+			t.Errorf("part %q is longer than 8 bytes", p)
+		}
+		got = append(got, p.String())
+		all.AddSet(p)
+	}
+	if want := []string{"1:3,10", "20,30", "400,5000"}; !slices.Equal(got, want) {
+		t.Errorf("Split(8) = %q, want %q", got, want)
+	}
+	if all.String() != s.String() {
+		t.Errorf("parts add up to %q, want %q", all, s)
+	}
+	if parts := s.Split(0); len(parts) != 1 || parts[0].String() != s.String() {
+		t.Errorf("Split(0) = %v", parts)
+	}
+}
+
+func TestGetOverviewsSet_SplitsLongSets(t *testing.T) {
+	d, server := setupTestDialer(t)
+	d.config.MaxLineLength = 60
+
+	var set UIDSet
+	for uid := 1; uid <= 40; uid += 2 {
+		set.AddNum(uid)
+	}
+	if _, err := d.GetOverviewsSet(set); err != nil {
+		t.Fatalf("GetOverviewsSet() error = %v", err)
+	}
+
+	var sent UIDSet
+	n := 0
+	for _, c := range server.Commands() {
+		if !strings.HasPrefix(c, "UID FETCH ") {
+			continue
+		}
+		n++
+		if len(c)+tagLength+3 > 60 {
+			t.Errorf("command %q exceeds MaxLineLength", c)
+		}
+		part, err := ParseUIDSet(strings.Fields(c)[2])
+		if err != nil {
+			t.Fatalf("ParseUIDSet(%q) error = %v", c, err)
+		}
+		sent.AddSet(part)
+	}
+	if n < 2 {
+		t.Errorf("sent %d commands, want the set split", n)
+	}
+	if sent.String() != set.String() {
+		t.Errorf("fetched %q, want %q", sent, set)
+	}
+}
+
+func TestGetUIDSet(t *testing.T) {
+	d, server := dialWithCapabilities(t, "IMAP4rev1 ESEARCH")
+	server.responses["UID SEARCH RETURN (ALL) UNSEEN"] = "* ESEARCH (TAG \"X\") UID ALL 1:500,700\r\n"
+	server.responses["UID SEARCH RETURN (ALL) DELETED"] = "* ESEARCH (TAG \"X\") UID\r\n"
+
+	s, err := d.GetUIDSet("UNSEEN")
+	if err != nil || s.String() != "1:500,700" {
+		t.Errorf("GetUIDSet(UNSEEN) = %q, %v", s, err)
+	}
+	s, err = d.GetUIDSet("DELETED")
+	if err != nil || !s.IsEmpty() {
+		t.Errorf("GetUIDSet(DELETED) = %q, %v; want an empty set", s, err)
+	}
+}
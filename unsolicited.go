@@ -0,0 +1,107 @@
+package imap
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// handleUnsolicited reports the mailbox and connection changes carried by a
+// response to handler. command is the command the response was received
+// for, or "" if none was running. Responses that are the command's own
+// results, such as FETCH data for the messages a FETCH or STORE names or the
+// message counts returned by SELECT, are not reported.
+func (d *Dialer) handleUnsolicited(handler *IdleHandler, command string, line []byte) {
+	if handler == nil {
+		return
+	}
+	data := dropNl(line)
+	if !bytes.HasPrefix(data, []byte("* ")) {
+		// A tagged completion may carry an alert as well
+		if _, status, ok := bytes.Cut(data, []byte(" ")); ok && !bytes.HasPrefix(data, []byte("+")) {
+			reportAlert(handler, status)
+		}
+		return
+	}
+	data = data[2:]
+
+	switch {
+	case hasPrefixFold(data, "BYE"):
+		reportAlert(handler, data)
+		if handler.OnBye != nil {
+			e := parseStatusError(data)
+			go handler.OnBye(ByeEvent{Code: e.Code, Text: e.Text})
+		}
+		return
+	case hasPrefixFold(data, "OK"), hasPrefixFold(data, "NO"), hasPrefixFold(data, "BAD"):
+		reportAlert(handler, data)
+		return
+	case len(data) == 0 || data[0] < '0' || data[0] > '9':
+		// CAPABILITY, LIST, SEARCH and other non-message data
+		return
+	}
+
+	upper := bytes.ToUpper(data)
+	fields := bytes.Fields(upper)
+	if len(fields) < 2 {
+		return
+	}
+	switch name := commandName(command); string(fields[1]) {
+	case IdleEventFetch:
+		if ownFetchResponse(command, fields) {
+			return
+		}
+	case IdleEventExists, IdleEventRecent:
+		if name == "SELECT" || name == "EXAMINE" {
+			return
+		}
+	}
+	if err := d.runIdleEvent(upper, handler); err != nil {
+		d.debugLog("ignoring unsolicited response", "error", err)
+	}
+}
+
+// reportAlert calls handler.OnAlert if status carries an [ALERT] code
+func reportAlert(handler *IdleHandler, status []byte) {
+	if handler.OnAlert == nil {
+		return
+	}
+	if e := parseStatusError(status); e.Code == "ALERT" {
+		go handler.OnAlert(AlertEvent{Text: e.Text})
+	}
+}
+
+// ownFetchResponse reports whether the FETCH response in fields answers
+// command, i.e. command is a FETCH or STORE and names the message. FETCH
+// data for other messages is a flag change made elsewhere.
+func ownFetchResponse(command string, fields [][]byte) bool {
+	args := strings.Fields(command)
+	name := commandName(command)
+	byUID := strings.HasPrefix(name, "UID ")
+	if byUID {
+		args = args[1:]
+	}
+	switch {
+	case name != "FETCH" && name != "UID FETCH" && name != "STORE" && name != "UID STORE":
+		return false
+	case len(args) < 2:
+		return true
+	}
+	set, err := parseNumSet(args[1])
+	if err != nil {
+		// A set we cannot read, such as "$", may name any message
+		return true
+	}
+	if !byUID {
+		n, err := strconv.Atoi(string(fields[0]))
+		return err == nil && set.Contains(n)
+	}
+	// Responses to UID commands always carry the UID (RFC 3501 6.4.8)
+	for i := 2; i+1 < len(fields); i++ {
+		if string(bytes.TrimLeft(fields[i], "(")) == "UID" {
+			uid, err := strconv.Atoi(string(bytes.TrimRight(fields[i+1], ")")))
+			return err == nil && set.Contains(uid)
+		}
+	}
+	return false
+}
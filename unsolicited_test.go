@@ -0,0 +1,144 @@
+package imap
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventRecorder collects events delivered to its handler
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []string
+	notify chan struct{}
+}
+
+func newEventRecorder() *eventRecorder {
+	return &eventRecorder{notify: make(chan struct{}, 64)}
+}
+
+func (r *eventRecorder) add(event string) {
+	r.mu.Lock()
+	r.events = append(r.events, event)
+	r.mu.Unlock()
+	r.notify <- struct{}{}
+}
+
+func (r *eventRecorder) handler() *IdleHandler {
+	return &IdleHandler{
+		OnExists:  func(e ExistsEvent) { r.add(fmt.Sprintf("exists %d", e.MessageIndex)) },
+		OnExpunge: func(e ExpungeEvent) { r.add(fmt.Sprintf("expunge %d", e.MessageIndex)) },
+		OnFetch:   func(e FetchEvent) { r.add(fmt.Sprintf("fetch %d %v", e.MessageIndex, e.Flags)) },
+		OnRecent:  func(e RecentEvent) { r.add(fmt.Sprintf("recent %d", e.Count)) },
+		OnAlert:   func(e AlertEvent) { r.add("alert " + e.Text) },
+		OnBye:     func(e ByeEvent) { r.add("bye " + e.Code + " " + e.Text) },
+	}
+}
+
+// wait returns the events once n have arrived, or fails the test
+func (r *eventRecorder) wait(t *testing.T, n int) map[string]bool {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		r.mu.Lock()
+		if len(r.events) >= n {
+			got := make(map[string]bool)
+			for _, e := range r.events {
+				got[e] = true
+			}
+			r.mu.Unlock()
+			return got
+		}
+		r.mu.Unlock()
+		select {
+		case <-r.notify:
+		case <-timeout:
+			t.Fatalf("timeout waiting for %d events, got %q", n, r.events)
+		}
+	}
+}
+
+func TestHandleUnsolicited(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		command string
+		line    string
+		want    string // "" means no event
+	}{
+		{"NOOP", "* 12 EXISTS\r\n", "exists 12"},
+		{"UID STORE 5 +FLAGS (\\Seen)", "* 3 FETCH (UID 5 FLAGS (\\Seen))\r\n", ""},
+		{"UID STORE 5 +FLAGS (\\Seen)", "* 4 FETCH (UID 6 FLAGS (\\Flagged))\r\n", "fetch 4 [FLAGGED]"},
+		{"STORE 2:3 +FLAGS (\\Seen)", "* 3 FETCH (FLAGS (\\Seen))\r\n", ""},
+		{"STORE 2:3 +FLAGS (\\Seen)", "* 7 FETCH (FLAGS (\\Seen))\r\n", "fetch 7 [SEEN]"},
+		{"UID FETCH 5 (FLAGS)", "* 3 FETCH (UID 5 FLAGS (\\Seen))\r\n", ""},
+		{"UID FETCH 1:* (FLAGS)", "* 3 FETCH (UID 90 FLAGS (\\Seen))\r\n", ""},
+		{"UID FETCH 5 (FLAGS)", "* 8 FETCH (UID 9 FLAGS (\\Deleted))\r\n", "fetch 8 [DELETED]"},
+		{"UID FETCH 5 (FLAGS)", "* 8 FETCH (FLAGS (\\Deleted))\r\n", "fetch 8 [DELETED]"},
+		{"FETCH 1:4 (FLAGS)", "* 4 FETCH (FLAGS ())\r\n", ""},
+		{"FETCH 1:4 (FLAGS)", "* 5 FETCH (FLAGS (\\Answered))\r\n", "fetch 5 [ANSWERED]"},
+		{"UID MOVE 5 Archive", "* 3 EXPUNGE\r\n", "expunge 3"},
+		{"UID SEARCH ALL", "* 2 RECENT\r\n", "recent 2"},
+		{`SELECT "INBOX"`, "* 2 RECENT\r\n", ""},
+		{`EXAMINE "INBOX"`, "* 17 EXISTS\r\n", ""},
+		{`SELECT "INBOX"`, "* 4 EXPUNGE\r\n", "expunge 4"},
+		{"NOOP", "* OK [ALERT] Quota almost full\r\n", "alert Quota almost full"},
+		{"NOOP", "A1 OK [ALERT] Read-only from now on\r\n", "alert Read-only from now on"},
+		{"NOOP", "* BYE [UNAVAILABLE] Restarting\r\n", "bye UNAVAILABLE Restarting"},
+		{"", "* 9 EXISTS\r\n", "exists 9"},
+		{"UID SEARCH ALL", "* SEARCH 1 2 3\r\n", ""},
+		{"CAPABILITY", "* CAPABILITY IMAP4rev1\r\n", ""},
+		{"NOOP", "* OK still here\r\n", ""},
+		{"NOOP", "A1 OK NOOP completed\r\n", ""},
+	}
+	for _, tt := range tests {
+		r := newEventRecorder()
+		d := &Dialer{}
+		d.handleUnsolicited(r.handler(), tt.command, []byte(tt.line))
+		if tt.want == "" {
+			select {
+			case <-r.notify:
+				t.Errorf("%s: %q reported %q, want no event", tt.command, tt.line, r.events)
+			case <-time.After(20 * time.Millisecond):
+			}
+			continue
+		}
+		if got := r.wait(t, 1); !got[tt.want] {
+			t.Errorf("%s: %q reported %v, want %q", tt.command, tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestUnsolicitedHandler_DuringCommands(t *testing.T) {
+	server, err := newMockIMAPServer("user", "pass")
+	if err != nil {
+		t.Fatalf("failed to create mock server: %v", err)
+	}
+	t.Cleanup(server.Close)
+	server.responses["NOOP"] = "* 4 EXISTS\r\n* 1 RECENT\r\n* OK [ALERT] Maintenance at noon\r\n"
+	server.responses["UID SEARCH ALL"] = "* SEARCH 1 2\r\n* 2 EXPUNGE\r\n"
+
+	r := newEventRecorder()
+	cfg := newTestConfig(server)
+	cfg.UnsolicitedHandler = r.handler()
+	d, err := Dial(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	if _, err := d.Exec("NOOP", false, 0, nil); err != nil {
+		t.Fatalf("NOOP: %v", err)
+	}
+	if _, err := d.GetUIDs("ALL"); err != nil {
+		t.Fatalf("GetUIDs: %v", err)
+	}
+
+	got := r.wait(t, 4)
+	for _, want := range []string{"exists 4", "recent 1", "alert Maintenance at noon", "expunge 2"} {
+		if !got[want] {
+			t.Errorf("missing event %q, got %v", want, got)
+		}
+	}
+}
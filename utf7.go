@@ -0,0 +1,143 @@
+package imap
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"unicode/utf16"
+)
+
+// errUTF7 reports malformed UTF-7
+var errUTF7 = errors.New("imap: malformed UTF-7")
+
+// utf7Encoding is the base64 alphabet of UTF-7 (RFC 2152)
+var utf7Encoding = base64.RawStdEncoding
+
+// decodeUTF7 decodes UTF-7 (RFC 2152), in which runs of UTF-16 encoded in
+// base64 start with "+" and end with "-" or any other character outside the
+// alphabet; "+-" stands for "+"
+func decodeUTF7(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != '+' {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(s) && isUTF7Base64(s[j]) {
+			j++
+		}
+		if j == i+1 {
+			b.WriteByte('+')
+		} else {
+			units, err := decodeUTF16Base64(s[i+1:j], utf7Encoding)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(string(utf16.Decode(units)))
+		}
+		// The "-" ending a run is absorbed
+		if j < len(s) && s[j] == '-' {
+			j++
+		}
+		i = j
+	}
+	return b.String(), nil
+}
+
+// isUTF7Base64 reports whether c is in the base64 alphabet of UTF-7
+func isUTF7Base64(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '+' || c == '/'
+}
+
+// decodeUTF16Base64 decodes unpadded base64 into big-endian UTF-16 code
+// units. Bits left over after the last whole unit are ignored.
+func decodeUTF16Base64(s string, enc *base64.Encoding) ([]uint16, error) {
+	raw, err := enc.DecodeString(s)
+	if err != nil || len(raw)%2 != 0 {
+		return nil, errUTF7
+	}
+	units := make([]uint16, len(raw)/2)
+	for k := range units {
+		units[k] = uint16(raw[2*k])<<8 | uint16(raw[2*k+1])
+	}
+	return units, nil
+}
+
+// mailboxEncoding is the base64 alphabet of modified UTF-7, with "," in place
+// of "/" (RFC 3501 §5.1.3)
+var mailboxEncoding = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+,").WithPadding(base64.NoPadding)
+
+// EncodeMailboxName encodes a folder name in the modified UTF-7 of RFC 3501
+// §5.1.3, e.g. "Корзина" as "&BBoEPgRABDcEOAQ9BDA-" and "R&D" as "R&-D".
+// Folder names passed to Dialer methods are encoded automatically; this is
+// for commands sent with Exec or a Pipeline.
+func EncodeMailboxName(name string) string {
+	var (
+		b   strings.Builder
+		run []rune
+	)
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		units := utf16.Encode(run)
+		raw := make([]byte, 2*len(units))
+		for k, u := range units {
+			raw[2*k], raw[2*k+1] = byte(u>>8), byte(u)
+		}
+		b.WriteByte('&')
+		b.WriteString(mailboxEncoding.EncodeToString(raw))
+		b.WriteByte('-')
+		run = run[:0]
+	}
+	for _, r := range name {
+		switch {
+		case r == '&':
+			flush()
+			b.WriteString("&-")
+		case 0x20 <= r && r <= 0x7e:
+			flush()
+			b.WriteRune(r)
+		default:
+			run = append(run, r)
+		}
+	}
+	flush()
+	return b.String()
+}
+
+// DecodeMailboxName decodes a folder name in modified UTF-7, as returned by
+// LIST, e.g. "&BBoEPgRABDcEOAQ9BDA-" to "Корзина". It fails if name is not
+// valid modified UTF-7, e.g. if it holds 8-bit characters.
+func DecodeMailboxName(name string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(name); {
+		c := name[i]
+		switch {
+		case c < 0x20 || c > 0x7e:
+			return "", errUTF7
+		case c != '&':
+			b.WriteByte(c)
+			i++
+			continue
+		}
+		j := strings.IndexByte(name[i+1:], '-')
+		if j < 0 {
+			return "", errUTF7
+		}
+		j += i + 1
+		if j == i+1 {
+			b.WriteByte('&')
+		} else {
+			units, err := decodeUTF16Base64(name[i+1:j], mailboxEncoding)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(string(utf16.Decode(units)))
+		}
+		i = j + 1
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,32 @@
+package imap
+
+import "testing"
+
+func TestMailboxName(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		name    string
+		mailbox string
+	}{
+		{"INBOX", "INBOX"},
+		{"Корзина", "&BBoEPgRABDcEOAQ9BDA-"},
+		{"R&D", "R&-D"},
+		{"~peter/mail/台北/日本語", "~peter/mail/&U,BTFw-/&ZeVnLIqe-"},
+		{"Entwürfe & Vorlagen", "Entw&APw-rfe &- Vorlagen"},
+		{"😀", "&2D3eAA-"},
+		{"", ""},
+	} {
+		if got := EncodeMailboxName(tt.name); got != tt.mailbox {
+			t.Errorf("EncodeMailboxName(%q) = %q, want %q", tt.name, got, tt.mailbox)
+		}
+		if got, err := DecodeMailboxName(tt.mailbox); err != nil || got != tt.name {
+			t.Errorf("DecodeMailboxName(%q) = %q, %v, want %q", tt.mailbox, got, err, tt.name)
+		}
+	}
+
+	for _, s := range []string{"Entwürfe", "&BBo", "R&D", "&Jjo!-", "&AA-"} {
+		if got, err := DecodeMailboxName(s); err == nil {
+			t.Errorf("DecodeMailboxName(%q) = %q, want an error", s, got)
+		}
+	}
+}
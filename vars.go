@@ -1,6 +1,9 @@
 package imap
 
 import (
+	"context"
+	"crypto/tls"
+	"net"
 	"strings"
 	"time"
 )
@@ -11,12 +14,17 @@ var (
 	RemoveSlashes = strings.NewReplacer(`\"`, `"`)
 )
 
+// The variables below are the defaults used by New, NewWithOAuth2 and
+// DefaultConfig. They are read when a connection is created; use Config to
+// give individual connections different settings.
+
 // Verbose outputs every command and its response with the IMAP server
 var Verbose = false
 
 // SkipResponses skips printing server responses in verbose mode
 var SkipResponses = false
 
+// RetryCount is the default number of retries for connections and commands
 var RetryCount = 10
 
 // DialTimeout defines how long to wait when establishing a new connection.
@@ -27,9 +35,20 @@ var DialTimeout time.Duration
 // Zero means no timeout.
 var CommandTimeout time.Duration
 
+// MaxLineLength is the default limit on the length of a command line that
+// carries a message set; longer sets are split over several commands. RFC
+// 7162 §4 recommends that clients stay within 8192 octets.
+var MaxLineLength = 8000
+
 // TLSSkipVerify disables certificate verification when establishing new
 // connections. Use with caution; skipping verification exposes the
 // connection to man-in-the-middle attacks.
 var TLSSkipVerify bool
 
-var lastResp string
+// TLSConfig is the default TLS configuration for new connections. Nil means
+// a default configuration verified against the system roots.
+var TLSConfig *tls.Config
+
+// DialContext is the default function used to open the underlying network
+// connection. Nil means a net.Dialer.
+var DialContext func(ctx context.Context, network, addr string) (net.Conn, error)